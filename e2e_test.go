@@ -394,3 +394,268 @@ func TestE2E(t *testing.T) {
 
 	assert.Equal(t, uint8(2), distStateR.ToGoValue().(uint8), "Expected distribution to be in state 2 (complete)")
 }
+
+func TestE2EMultipleCollectibleContracts(t *testing.T) {
+	cfg := getTestCfg(t, nil)
+	a, cleanup := getTestApp(cfg, true)
+	defer cleanup()
+
+	no_packs := 3
+
+	g := gwtf.NewGoWithTheFlow([]string{"./flow.json"}, "emulator", false, 0)
+
+	flowClient, err := client.New("localhost:3569", grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer := common.FlowAddress(g.Account("issuer").Address())
+	owner := g.Account("owner").Address()
+
+	contracts := []app.AddressLocation{
+		{Name: "ExampleNFT", Address: issuer},
+		{Name: "ExampleNFT2", Address: issuer},
+	}
+
+	buckets := make([]app.Bucket, 0, len(contracts))
+	providerPaths := make([]cadence.KeyValuePair, 0, len(contracts))
+
+	for _, contract := range contracts {
+		vars := &flow_helpers.CadenceTemplateVars{
+			CollectibleNFTName:    contract.Name,
+			CollectibleNFTAddress: contract.Address.String(),
+		}
+
+		providerPath := cadence.Path{Domain: "private", Identifier: contract.Name + "CollectionProvider"}
+
+		t.Logf("Setting up %s collection for issuer and owner", contract.Name)
+
+		setupCollection := "./cadence-transactions/collectibleNFT/setup_collection_and_link_provider.cdc"
+		setupCollectionCode, err := flow_helpers.ParseCadenceTemplate(setupCollection, vars)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, account := range []string{"issuer", "owner"} {
+			_, err := g.
+				TransactionFromFile(setupCollection, setupCollectionCode).
+				SignProposeAndPayAs(account).
+				Argument(providerPath).
+				RunE()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		t.Logf("Minting %s collectibles", contract.Name)
+
+		mint := "./cadence-transactions/collectibleNFT/mint.cdc"
+		mintCode, err := flow_helpers.ParseCadenceTemplate(mint, vars)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = g.
+			TransactionFromFile(mint, mintCode).
+			SignProposeAndPayAs("issuer").
+			AccountArgument("issuer").
+			IntArgument(no_packs).
+			RunE()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		balance, err := getCollectibleBalance(flowClient, contract, flow.Address(issuer))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		collection, err := getCollectibleIDs(flowClient, contract, flow.Address(issuer), balance)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		buckets = append(buckets, app.Bucket{
+			CollectibleReference:  contract,
+			CollectibleCount:      1,
+			CollectibleCollection: collection[:no_packs],
+		})
+
+		providerPaths = append(providerPaths, cadence.KeyValuePair{
+			Key:   cadence.NewString(contract.String()),
+			Value: providerPath,
+		})
+	}
+
+	t.Log("Setting up PackIssuer and PackNFT collections")
+
+	createPackIssuer := "./cadence-transactions/pds/create_new_pack_issuer.cdc"
+	createPackIssuerCode := util.ParseCadenceTemplate(createPackIssuer)
+	_, err = g.
+		TransactionFromFile(createPackIssuer, createPackIssuerCode).
+		SignProposeAndPayAs("issuer").
+		RunE()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	createPackNFTCollection := "./cadence-transactions/packNFT/create_new_packNFT_collection.cdc"
+	createPackNFTCollectionCode := util.ParseCadenceTemplate(createPackNFTCollection)
+	for _, account := range []string{"issuer", "owner"} {
+		_, err = g.
+			TransactionFromFile(createPackNFTCollection, createPackNFTCollectionCode).
+			SignProposeAndPayAs(account).
+			RunE()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := a.SetDistCap(context.Background(), issuer); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log("Issuer creates distribution with collections on chain")
+
+	pdsDistId := "./cadence-scripts/pds/get_next_dist_id.cdc"
+	pdsDistIdCode := util.ParseCadenceTemplate(pdsDistId)
+	currentDistId, err := g.ScriptFromFile(pdsDistId, pdsDistIdCode).RunReturns()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	createDist := "./cadence-transactions/pds/create_distribution_with_collections.cdc"
+	createDistCode, err := flow_helpers.ParseCadenceTemplate(
+		createDist,
+		&flow_helpers.CadenceTemplateVars{
+			PackNFTName:    "PackNFT",
+			PackNFTAddress: os.Getenv("PACKNFT_ADDRESS"),
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = g.
+		TransactionFromFile(createDist, createDistCode).
+		SignProposeAndPayAs("issuer").
+		Argument(providerPaths[0].Value).
+		Argument(cadence.NewDictionary(providerPaths)).
+		StringArgument("MultipleCollectiblesDistTitle").
+		Argument(cadence.NewDictionary([]cadence.KeyValuePair{})).
+		RunE()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	distId, err := common.FlowIDFromCadence(currentDistId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	distribution := app.Distribution{
+		State:  common.DistributionStateInit,
+		FlowID: distId,
+		Issuer: issuer,
+		PackTemplate: app.PackTemplate{
+			PackReference: app.AddressLocation{
+				Name:    "PackNFT",
+				Address: issuer,
+			},
+			PackCount: uint(no_packs),
+			Buckets:   buckets,
+		},
+	}
+
+	if err := a.CreateDistribution(context.Background(), &distribution); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log("Wait for the distribution to complete")
+
+	for {
+		d, err := a.GetDistribution(context.Background(), distribution.ID)
+		if err != nil {
+			if strings.Contains(err.Error(), "database is locked") {
+				continue
+			}
+			t.Fatal(err)
+		}
+		if d.State == common.DistributionStateComplete {
+			distribution = *d
+			break
+		}
+		time.Sleep(time.Second)
+	}
+
+	pack := distribution.Packs[0]
+	packID := cadence.UInt64(pack.FlowID.Int64)
+
+	if contracts := pack.Collectibles.Contracts(); len(contracts) != 2 {
+		t.Fatalf("expected pack to contain collectibles from 2 contracts, got %d", len(contracts))
+	}
+
+	t.Log("Transferring a pack to owner")
+
+	transferPackNFT := "./cadence-transactions/packNFT/transfer_packNFT.cdc"
+	transferPackNFTCode := util.ParseCadenceTemplate(transferPackNFT)
+	_, err = g.
+		TransactionFromFile(transferPackNFT, transferPackNFTCode).
+		SignProposeAndPayAs("issuer").
+		AccountArgument("owner").
+		Argument(packID).
+		RunE()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log("Owner requests to reveal and open the pack")
+
+	revealRequest := "./cadence-transactions/packNFT/reveal_request.cdc"
+	revealRequestCode := util.ParseCadenceTemplate(revealRequest)
+	_, err = g.
+		TransactionFromFile(revealRequest, revealRequestCode).
+		SignProposeAndPayAs("owner").
+		Argument(packID).
+		BooleanArgument(true).
+		RunE()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for {
+		p, err := a.GetPack(context.Background(), pack.ID)
+		if err != nil {
+			if strings.Contains(err.Error(), "database is locked") {
+				continue
+			}
+			t.Fatal(err)
+		}
+		if p.State == common.PackStateOpened {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+
+	// Wait a bit more as the blocktime might be 1s if run from the test script
+	time.Sleep(time.Second * 2)
+
+	for _, contract := range contracts {
+		balance, err := getCollectibleBalance(flowClient, contract, owner)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ownerIDs, err := getCollectibleIDs(flowClient, contract, owner, balance)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, c := range pack.Collectibles {
+			if c.ContractReference != contract {
+				continue
+			}
+			if _, ok := ownerIDs.Contains(c.FlowID); !ok {
+				t.Errorf("expected owner to have collectible NFT: %s", c)
+			}
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/bjartek/go-with-the-flow/v2/gwtf"
@@ -148,6 +149,10 @@ func TestCreateDistWithCap(t *testing.T) {
 	metadata, err := pds.GetDistMetadata(g, nextDistId)
 	assert.NoError(t, err)
 	assert.Equal(t, stringifiedKeyPair, metadata)
+
+	issuer, err := pds.GetDistIssuer(g, nextDistId)
+	assert.NoError(t, err)
+	assert.Equal(t, "0x"+g.Account("issuer").Address().String(), issuer)
 }
 
 func TestPDSEscrowNFTs(t *testing.T) {
@@ -734,3 +739,77 @@ func TestPublicVerify(t *testing.T) {
 	_, err = packnft.Verify(g, currentPack, notNfts)
 	assert.Error(t, err)
 }
+
+// Escrowed collectibles are returned to the issuer once the distribution is invalidated
+func TestRefundInvalidDist(t *testing.T) {
+	g := gwtf.NewGoWithTheFlow(util.FlowJSON, os.Getenv("NETWORK"), false, 3)
+	currentDistId, err := pds.GetNextDistID(g)
+	assert.NoError(t, err)
+
+	metadata := cadence.NewDictionary([]cadence.KeyValuePair{})
+	_, err = pds.CreateDistribution(g, "NFTCollectionProvider", "issuer", "RefundDistTitle", metadata)
+	assert.NoError(t, err)
+
+	mintExampleNFT := "../cadence-transactions/exampleNFT/mint_exampleNFT.cdc"
+	mintExampleNFTCode := util.ParseCadenceTemplate(mintExampleNFT)
+	_, err = g.
+		TransactionFromFile(mintExampleNFT, mintExampleNFTCode).
+		SignProposeAndPayAs("issuer").
+		AccountArgument("issuer").
+		RunE()
+	assert.NoError(t, err)
+
+	nfts, err := examplenft.GetBalance(g, "issuer")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, nfts.ToGoValue())
+
+	_, err = pds.PDSWithdrawNFT(g, currentDistId, nfts, "pds")
+	assert.NoError(t, err)
+
+	balance, err := examplenft.GetBalance(g, "issuer")
+	assert.NoError(t, err)
+	assert.Empty(t, balance.ToGoValue())
+
+	_, err = pds.PDSUpdateDistState(g, currentDistId, "invalid")
+	assert.NoError(t, err)
+
+	_, err = pds.PDSRefundNFT(g, currentDistId, nfts, "NFTCollectionProvider")
+	assert.NoError(t, err)
+
+	balance, err = examplenft.GetBalance(g, "issuer")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, nfts.ToGoValue(), balance.ToGoValue())
+}
+
+// The shuffle seed commitment of a distribution is stored onchain and can not be changed
+func TestPDSCommitShuffleSeed(t *testing.T) {
+	g := gwtf.NewGoWithTheFlow(util.FlowJSON, os.Getenv("NETWORK"), false, 3)
+	nextDistId, err := pds.GetNextDistID(g)
+	assert.NoError(t, err)
+	currentDistId := nextDistId - 1
+
+	commitment := strings.Repeat("ab", 32)
+
+	_, err = pds.PDSCommitShuffleSeed(g, currentDistId, commitment, 0)
+	assert.Error(t, err)
+
+	events, err := pds.PDSCommitShuffleSeed(g, currentDistId, commitment, 10)
+	assert.NoError(t, err)
+
+	event := util.NewExpectedPDSEvent("ShuffleSeedCommitted")
+	event.AssertHasKey(t, events[0], "entropyBlockHeight")
+	assert.Equal(t, strconv.Itoa(int(currentDistId)), events[0].Fields["DistId"])
+	assert.Equal(t, commitment, events[0].Fields["commitment"])
+
+	onchain, entropyBlockHeight, err := pds.GetShuffleCommitment(g, currentDistId)
+	assert.NoError(t, err)
+	assert.Equal(t, commitment, onchain)
+	assert.Equal(t, strconv.FormatUint(entropyBlockHeight, 10), events[0].Fields["entropyBlockHeight"])
+
+	_, err = pds.PDSCommitShuffleSeed(g, currentDistId, strings.Repeat("cd", 32), 10)
+	assert.Error(t, err)
+
+	onchain, _, err = pds.GetShuffleCommitment(g, nextDistId)
+	assert.NoError(t, err)
+	assert.Empty(t, onchain)
+}
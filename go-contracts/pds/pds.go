@@ -2,6 +2,7 @@ package pds
 
 import (
 	"errors"
+	"os"
 
 	"github.com/bjartek/go-with-the-flow/v2/gwtf"
 	"github.com/flow-hydraulics/flow-pds/go-contracts/util"
@@ -99,6 +100,22 @@ func GetDistState(
 	return
 }
 
+func GetDistIssuer(
+	g *gwtf.GoWithTheFlow,
+	distId uint64,
+) (issuer string, err error) {
+	script := "../cadence-scripts/pds/get_dist_issuer.cdc"
+	code := util.ParseCadenceTemplate(script)
+	r, err := g.ScriptFromFile(script, code).UInt64Argument(distId).RunReturns()
+	if err != nil {
+		return
+	}
+	if o, ok := r.(cadence.Optional); ok && o.Value != nil {
+		issuer = o.Value.String()
+	}
+	return
+}
+
 func GetDistMetadata(
 	g *gwtf.GoWithTheFlow,
 	distId uint64,
@@ -122,12 +139,34 @@ func PDSWithdrawNFT(
 		TransactionFromFile(withdraw, withdrawCode).
 		SignProposeAndPayAs("pds").
 		UInt64Argument(distId).
+		StringArgument("A." + os.Getenv("EXAMPLE_NFT_ADDRESS") + ".ExampleNFT").
 		Argument(nftIds).
 		RunE()
 	events = util.ParseTestEvents(e)
 	return
 }
 
+// PDSRefundNFT returns escrowed collectibles of an invalidated distribution
+// to its issuer.
+func PDSRefundNFT(
+	g *gwtf.GoWithTheFlow,
+	distId uint64,
+	nftIds cadence.Value,
+	privPath string,
+) (events []*gwtf.FormatedEvent, err error) {
+	refund := "../cadence-transactions/pds/refund.cdc"
+	refundCode := util.ParseCadenceTemplate(refund)
+	e, err := g.
+		TransactionFromFile(refund, refundCode).
+		SignProposeAndPayAs("pds").
+		UInt64Argument(distId).
+		Argument(nftIds).
+		Argument(cadence.Path{Domain: "private", Identifier: privPath}).
+		RunE()
+	events = util.ParseTestEvents(e)
+	return
+}
+
 func PDSMintPackNFT(
 	g *gwtf.GoWithTheFlow,
 	distId uint64,
@@ -178,6 +217,46 @@ func PDSUpdateDistState(
 	return
 }
 
+// PDSCommitShuffleSeed publishes the shuffle seed commitment of a distribution.
+func PDSCommitShuffleSeed(
+	g *gwtf.GoWithTheFlow,
+	distId uint64,
+	commitment string,
+	entropyBlockDelay uint64,
+) (events []*gwtf.FormatedEvent, err error) {
+	txScript := "../cadence-transactions/pds/commit_shuffle_seed.cdc"
+	code := util.ParseCadenceTemplate(txScript)
+	e, err := g.
+		TransactionFromFile(txScript, code).
+		SignProposeAndPayAs("pds").
+		UInt64Argument(distId).
+		StringArgument(commitment).
+		UInt64Argument(entropyBlockDelay).
+		RunE()
+	events = util.ParseTestEvents(e)
+	return
+}
+
+// GetShuffleCommitment returns the shuffle seed commitment of a distribution
+// and the height of its entropy block, empty if none has been published.
+func GetShuffleCommitment(
+	g *gwtf.GoWithTheFlow,
+	distId uint64,
+) (commitment string, entropyBlockHeight uint64, err error) {
+	script := "../cadence-scripts/pds/get_shuffle_commitment.cdc"
+	code := util.ParseCadenceTemplate(script)
+	r, err := g.ScriptFromFile(script, code).UInt64Argument(distId).RunReturns()
+	if err != nil {
+		return
+	}
+	if o, ok := r.(cadence.Optional); ok && o.Value != nil {
+		fields := o.Value.(cadence.Struct).Fields
+		commitment = fields[0].ToGoValue().(string)
+		entropyBlockHeight = fields[2].ToGoValue().(uint64)
+	}
+	return
+}
+
 func PDSRevealPackNFT(
 	g *gwtf.GoWithTheFlow,
 	distId uint64,
@@ -204,7 +283,7 @@ func PDSRevealPackNFT(
 		StringArgument(salt).
 		AccountArgument(owner).
 		BooleanArgument(openReq).
-		Argument(cadence.Path{Domain: "private", Identifier: privPath}).
+		Argument(providerPaths(nftIds, privPath)).
 		RunE()
 	events = util.ParseTestEvents(e)
 	return
@@ -232,8 +311,19 @@ func PDSOpenPackNFT(
 		Argument(nftContractNames).
 		Argument(nftIds).
 		AccountArgument(owner).
-		Argument(cadence.Path{Domain: "private", Identifier: privPath}).
+		Argument(providerPaths(nftIds, privPath)).
 		RunE()
 	events = util.ParseTestEvents(e)
 	return
 }
+
+// providerPaths returns the private provider path for each NFT
+func providerPaths(nftIds cadence.Value, privPath string) cadence.Array {
+	var paths []cadence.Value
+	if ids, ok := nftIds.(cadence.Array); ok {
+		for range ids.Values {
+			paths = append(paths, cadence.Path{Domain: "private", Identifier: privPath})
+		}
+	}
+	return cadence.NewArray(paths)
+}
@@ -33,6 +33,13 @@ type Addresses struct {
 	PackNFTAddress        string
 	CollectibleNFTName    string
 	CollectibleNFTAddress string
+	CollectibleNFTs       []Contract
+}
+
+type Contract struct {
+	Name       string
+	Address    string
+	Identifier string
 }
 
 type TestEvent struct {
@@ -65,6 +72,13 @@ func ParseCadenceTemplate(templatePath string) []byte {
 		PackNFTAddress:        os.Getenv("PACKNFT_ADDRESS"),
 		CollectibleNFTName:    "ExampleNFT",
 		CollectibleNFTAddress: os.Getenv("EXAMPLE_NFT_ADDRESS"),
+		CollectibleNFTs: []Contract{
+			{
+				Name:       "ExampleNFT",
+				Address:    os.Getenv("EXAMPLE_NFT_ADDRESS"),
+				Identifier: "A." + os.Getenv("EXAMPLE_NFT_ADDRESS") + ".ExampleNFT",
+			},
+		},
 	}
 
 	buf := &bytes.Buffer{}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 
@@ -11,6 +13,7 @@ import (
 	"github.com/flow-hydraulics/flow-pds/service/config"
 	"github.com/flow-hydraulics/flow-pds/service/http"
 	"github.com/flow-hydraulics/flow-pds/service/transactions"
+	"github.com/flow-hydraulics/flow-pds/service/verify"
 	"github.com/onflow/flow-go-sdk/client"
 	log "github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
@@ -44,6 +47,15 @@ func init() {
 }
 
 func main() {
+	// Subcommands
+	if len(os.Args) > 1 && os.Args[1] == "verify-pack" {
+		if err := runVerifyPack(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	var (
 		printVersion bool
 		envFilePath  string
@@ -125,3 +137,68 @@ func runServer(cfg *config.Config) error {
 
 	return nil
 }
+
+// runVerifyPack verifies the contents of a revealed pack against its
+// commitment hash. The proof is read from a file or from stdin, in the format
+// returned by 'GET /v1/packs/{id}/proof'. The commit hash of the pack NFT is
+// read from chain, or given as a flag, but never taken from the proof.
+func runVerifyPack(args []string) error {
+	fs := flag.NewFlagSet("verify-pack", flag.ExitOnError)
+	proofPath := fs.String("proof", "-", "path of the pack proof JSON file, '-' for stdin")
+	flowID := fs.Uint64("flow-id", 0, "Flow ID of the pack NFT")
+	packContract := fs.String("pack-contract", "", "pack NFT contract, e.g. 'A.0000000000000003.PackNFT'")
+	accessNode := fs.String("access-node", "localhost:3569", "Flow access node to read the commit hash of the pack from")
+	commitHash := fs.String("commit-hash", "", "hex encoded onchain commit hash of the pack, read from the access node if not set")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	in := os.Stdin
+	if *proofPath != "-" {
+		f, err := os.Open(*proofPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	var proof verify.PackProof
+	if err := json.NewDecoder(in).Decode(&proof); err != nil {
+		return fmt.Errorf("error while decoding pack proof: %w", err)
+	}
+
+	if *commitHash == "" {
+		if *flowID == 0 || *packContract == "" {
+			return fmt.Errorf("-flow-id and -pack-contract are required to read the commit hash from chain")
+		}
+		if proof.FlowID != 0 && proof.FlowID != *flowID {
+			return fmt.Errorf("proof is for pack %d, not %d", proof.FlowID, *flowID)
+		}
+
+		contract, err := app.AddressLocationFromString(*packContract)
+		if err != nil {
+			return err
+		}
+
+		flowClient, err := client.New(*accessNode, grpc.WithInsecure())
+		if err != nil {
+			return err
+		}
+		defer flowClient.Close()
+
+		hash, err := verify.GetCommitHash(context.Background(), flowClient, contract, *flowID)
+		if err != nil {
+			return fmt.Errorf("error while reading the commit hash of pack %d: %w", *flowID, err)
+		}
+		*commitHash = hash
+	}
+
+	if err := proof.Verify(*commitHash); err != nil {
+		return err
+	}
+
+	fmt.Printf("OK, sha256(%s) = %s\n", verify.HashInput(proof.Salt, proof.Collectibles), *commitHash)
+
+	return nil
+}
@@ -2,8 +2,10 @@ package app
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/flow-hydraulics/flow-pds/service/common"
+	"github.com/onflow/flow-go-sdk"
 )
 
 // AddressLocation is a reference to a contract on chain.
@@ -12,6 +14,23 @@ type AddressLocation struct {
 	Address common.FlowAddress `gorm:"column:address"`
 }
 
+// AddressLocationFromString returns an address location from the string
+// representation, e.g. "A.01cf0e2f2f715450.PackNFT".
+func AddressLocationFromString(s string) (AddressLocation, error) {
+	split := strings.Split(s, ".")
+	if len(split) != 3 || split[0] != "A" {
+		return AddressLocation{}, fmt.Errorf("invalid contract reference '%s'", s)
+	}
+	al := AddressLocation{
+		Name:    split[2],
+		Address: common.FlowAddress(flow.HexToAddress(split[1])),
+	}
+	if err := al.Validate(); err != nil {
+		return AddressLocation{}, fmt.Errorf("invalid contract reference '%s': %w", s, err)
+	}
+	return al, nil
+}
+
 func (al AddressLocation) String() string {
 	return fmt.Sprintf("A.%s.%s", al.Address, al.Name)
 }
@@ -2,15 +2,26 @@ package app
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/flow-hydraulics/flow-pds/service/common"
 	"github.com/flow-hydraulics/flow-pds/service/config"
+	"github.com/flow-hydraulics/flow-pds/service/transactions"
 	"github.com/google/uuid"
 	"github.com/onflow/flow-go-sdk/client"
+	log "github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 )
 
+// ErrDistributionRegistered is returned when creating a distribution whose
+// onchain distribution has already been registered
+var ErrDistributionRegistered = errors.New("distribution already registered")
+
+// ErrSettlementInFlight is returned when retrying settlement while settle
+// transactions for the same collectibles have not been sealed yet
+var ErrSettlementInFlight = errors.New("settle transactions for the collectibles are still in flight")
+
 // App handles all the application logic and interfaces directly with the database
 type App struct {
 	cfg        *config.Config
@@ -54,24 +65,115 @@ func (app *App) CreateDistribution(ctx context.Context, distribution *Distributi
 		return fmt.Errorf("issuer account should not be the same as PDS admin account")
 	}
 
-	// Resolve will also validate the distribution
-	if err := distribution.Resolve(); err != nil {
+	if distribution.ShuffleMode == ShuffleModeVerifiable {
+		// Commit to a seed now, the poller will publish the commitment onchain,
+		// fix the entropy block and resolve the distribution once the entropy
+		// block has been sealed
+		// CommitShuffle will also validate the distribution
+		if err := distribution.CommitShuffle(); err != nil {
+			return err
+		}
+	} else {
+		// Resolve will also validate the distribution
+		if err := distribution.Resolve(); err != nil {
+			return err
+		}
+	}
+
+	if existing, err := GetDistributionByFlowID(app.db, distribution.FlowID); err == nil {
+		return fmt.Errorf("%w: distribution %d is registered as %s", ErrDistributionRegistered, distribution.FlowID.Int64, existing.ID)
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
 		return err
 	}
 
+	if err := app.service.VerifyDistribution(ctx, distribution); err != nil {
+		return fmt.Errorf("error while verifying distribution onchain: %w", err)
+	}
+
+	reserved, err := app.reservedCollectibles(distribution)
+	if err != nil {
+		return err
+	}
+	if len(reserved) > 0 {
+		return &ReservedCollectiblesError{Conflicts: reserved}
+	}
+
+	title, metadata, err := app.service.GetDistributionInfo(ctx, distribution.FlowID)
+	if err != nil {
+		return fmt.Errorf("error while reading distribution info from chain: %w", err)
+	}
+
+	distribution.SetInfo(title, metadata)
+
+	// Flow ID is unique in database, this catches concurrent registrations
 	if err := InsertDistribution(app.db, distribution, app.cfg.BatchInsertSize); err != nil {
+		if _, err := GetDistributionByFlowID(app.db, distribution.FlowID); err == nil {
+			return fmt.Errorf("%w: distribution %d is already registered", ErrDistributionRegistered, distribution.FlowID.Int64)
+		}
 		return err
 	}
 
 	return nil
 }
 
+// PreviewDistribution validates and resolves a distribution without persisting it.
+func (app *App) PreviewDistribution(ctx context.Context, distribution *Distribution) DistributionPreview {
+	preview := distribution.Preview()
+
+	// Check that distribution issuer address does not equal to AdminAddress
+	if distribution.Issuer == common.FlowAddressFromString(app.cfg.AdminAddress) {
+		preview.Valid = false
+		preview.Errors = append(preview.Errors, fmt.Errorf("issuer account should not be the same as PDS admin account"))
+	}
+
+	if existing, err := GetDistributionByFlowID(app.db, distribution.FlowID); err == nil {
+		preview.Valid = false
+		preview.Errors = append(preview.Errors, fmt.Errorf("%w: distribution %d is registered as %s", ErrDistributionRegistered, distribution.FlowID.Int64, existing.ID))
+	}
+
+	if err := app.service.VerifyDistribution(ctx, distribution); err != nil {
+		preview.Valid = false
+		preview.Errors = append(preview.Errors, err)
+	}
+
+	reserved, err := app.reservedCollectibles(distribution)
+	if err != nil {
+		preview.Valid = false
+		preview.Errors = append(preview.Errors, err)
+	} else if len(reserved) > 0 {
+		preview.Valid = false
+		preview.Errors = append(preview.Errors, &ReservedCollectiblesError{Conflicts: reserved})
+	}
+
+	return preview
+}
+
+// reservedCollectibles returns the collectibles of a distribution which are
+// already included in another distribution that has not completed or been invalidated.
+func (app *App) reservedCollectibles(distribution *Distribution) ([]CollectibleConflict, error) {
+	reserved := []DistributionCollectible{}
+	for contract, ids := range distribution.PackTemplate.collectibleIDs() {
+		for start := 0; start < len(ids); start += app.cfg.BatchProcessSize {
+			end := start + app.cfg.BatchProcessSize
+			if end > len(ids) {
+				end = len(ids)
+			}
+			list, err := ListReservedCollectibles(app.db, distribution.ID, contract, ids[start:end])
+			if err != nil {
+				return nil, err
+			}
+			reserved = append(reserved, list...)
+		}
+	}
+	return distribution.PackTemplate.reservedCollectibles(reserved), nil
+}
+
 // ListDistributions lists all distributions in the database. Uses 'limit' and 'offset' to
-// limit the fetched slice size.
-func (app *App) ListDistributions(ctx context.Context, limit, offset int) ([]Distribution, error) {
+// limit the fetched slice size and 'filter' to narrow down the list.
+func (app *App) ListDistributions(ctx context.Context, limit, offset int, filter DistributionFilter) ([]Distribution, error) {
 	opt := ParseListOptions(limit, offset)
 
-	return ListDistributions(app.db, opt)
+	return ListDistributions(app.db, opt, filter)
 }
 
 // GetDistribution returns a distribution from database based on its offchain ID (uuid).
@@ -93,6 +195,85 @@ func (app *App) GetDistributionState(ctx context.Context, id uuid.UUID) (common.
 	return distribution.State, nil
 }
 
+// GetDistributionProgress returns the settlement and minting progress of a distribution.
+func (app *App) GetDistributionProgress(ctx context.Context, id uuid.UUID) (*DistributionProgress, error) {
+	distribution, err := GetDistributionSmall(app.db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return GetDistributionProgress(app.db, distribution)
+}
+
+// ReconcileDistributions compares the offchain and onchain states of the
+// distributions in database and returns the ones which disagree. Distributions
+// with a state update transaction still pending are not considered mismatched.
+// Uses 'limit' and 'offset' to limit the amount of distributions checked.
+func (app *App) ReconcileDistributions(ctx context.Context, limit, offset int) ([]StateReconciliation, error) {
+	opt := ParseListOptions(limit, offset)
+
+	list, err := ListDistributions(app.db, opt, DistributionFilter{})
+	if err != nil {
+		return nil, err
+	}
+
+	mismatched := []StateReconciliation{}
+	for i := range list {
+		r, err := app.service.ReconcileState(ctx, app.db, &list[i])
+		if err != nil {
+			return nil, err
+		}
+		if r.Mismatch() {
+			log.WithFields(log.Fields{
+				"distID":               r.DistributionID,
+				"distFlowID":           r.DistFlowID,
+				"state":                r.State,
+				"expectedOnchainState": r.ExpectedOnchainState.String(),
+				"onchainState":         r.OnchainState.String(),
+			}).Warn("Distribution state mismatch")
+			mismatched = append(mismatched, *r)
+		}
+	}
+
+	return mismatched, nil
+}
+
+// GetShuffleProof returns the data needed to verify the shuffle of a seeded
+// or verifiable distribution.
+func (app *App) GetShuffleProof(ctx context.Context, id uuid.UUID) (*ShuffleProof, error) {
+	distribution, err := GetDistributionBig(app.db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	revealed, err := ListRevealedPacks(app.db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	unrevealed, err := CountUnrevealedPacks(app.db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return ShuffleProofFromDistribution(distribution, revealed, unrevealed)
+}
+
+// GetRevealedShuffleSeed returns the shuffle seed of a distribution if it may
+// be published, otherwise nil.
+func (app *App) GetRevealedShuffleSeed(ctx context.Context, dist *Distribution) (common.BinaryValue, error) {
+	if dist.State != common.DistributionStateComplete {
+		return nil, nil
+	}
+
+	unrevealed, err := CountUnrevealedPacks(app.db, dist.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return dist.RevealedShuffleSeed(unrevealed), nil
+}
+
 // AbortDistribution aborts a distribution.
 func (app *App) AbortDistribution(ctx context.Context, id uuid.UUID) error {
 	return app.db.Transaction(func(tx *gorm.DB) error {
@@ -109,6 +290,23 @@ func (app *App) AbortDistribution(ctx context.Context, id uuid.UUID) error {
 	})
 }
 
+// RetrySettlement re-queues settle transactions for the collectibles of a
+// distribution which have not been settled. It returns the amount of
+// collectibles queued.
+func (app *App) RetrySettlement(ctx context.Context, id uuid.UUID) (int, error) {
+	var count int
+	err := app.db.Transaction(func(tx *gorm.DB) error {
+		distribution, err := GetDistributionSmall(tx, id)
+		if err != nil {
+			return err
+		}
+
+		count, err = app.service.RetrySettlement(ctx, tx, distribution)
+		return err
+	})
+	return count, err
+}
+
 // GetPack returns a pack from database based on its offchain ID (uuid).
 func (app *App) GetPack(ctx context.Context, id uuid.UUID) (*Pack, error) {
 	pack, err := GetPack(app.db, id)
@@ -117,3 +315,147 @@ func (app *App) GetPack(ctx context.Context, id uuid.UUID) (*Pack, error) {
 	}
 	return pack, nil
 }
+
+// ListPacks lists the packs of a distribution in database, optionally only
+// packs in the given state. Uses 'limit' and 'offset' to limit the fetched slice size.
+func (app *App) ListPacks(ctx context.Context, distributionID uuid.UUID, state common.PackState, limit, offset int) ([]Pack, error) {
+	// Make sure the distribution exists
+	if _, err := GetDistributionSmall(app.db, distributionID); err != nil {
+		return nil, err
+	}
+
+	opt := ParseListOptions(limit, offset)
+
+	return ListDistributionPacks(app.db, distributionID, state, opt)
+}
+
+// GetPackByFlowID returns a pack from database based on its pack NFT contract
+// and onchain ID.
+func (app *App) GetPackByFlowID(ctx context.Context, contract AddressLocation, flowID common.FlowID) (*Pack, error) {
+	return GetPackByContractAndFlowID(app.db, contract, flowID)
+}
+
+// GetPackProof returns a revealed pack from database based on its offchain
+// ID (uuid) so its contents can be verified against its commitment hash.
+func (app *App) GetPackProof(ctx context.Context, id uuid.UUID) (*Pack, error) {
+	pack, err := GetPack(app.db, id)
+	if err != nil {
+		return nil, err
+	}
+	if !pack.IsRevealed() {
+		return nil, fmt.Errorf("pack %s has not been revealed", id)
+	}
+	return pack, nil
+}
+
+// ListTransactions lists Flow transactions in database matching the filter,
+// latest first. Uses 'limit' and 'offset' to limit the fetched slice size.
+func (app *App) ListTransactions(ctx context.Context, filter transactions.ListFilter, limit, offset int) ([]transactions.StorableTransaction, error) {
+	opt := ParseListOptions(limit, offset)
+
+	return transactions.List(app.db, filter, opt.Limit, opt.Offset)
+}
+
+// GetTransaction returns a Flow transaction from database along with its
+// audit entries.
+func (app *App) GetTransaction(ctx context.Context, id uuid.UUID) (*transactions.StorableTransaction, error) {
+	return transactions.GetTransactionWithAudit(app.db, id)
+}
+
+// RetryTransaction re-queues a failed Flow transaction to be prepared again
+// with a fresh reference block and sent. The retry is audited.
+func (app *App) RetryTransaction(ctx context.Context, id uuid.UUID) (*transactions.StorableTransaction, error) {
+	var t *transactions.StorableTransaction
+	err := app.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		t, err = transactions.GetTransaction(tx, id)
+		if err != nil {
+			return err
+		}
+
+		if err := checkTransactionRetryable(tx, t); err != nil {
+			return err
+		}
+
+		entry, err := t.Retry()
+		if err != nil {
+			return err
+		}
+
+		if err := t.Save(tx); err != nil {
+			return err
+		}
+
+		if err := transactions.InsertAuditEntry(tx, entry); err != nil {
+			return err
+		}
+
+		log.WithFields(log.Fields{
+			"ID":             t.ID,
+			"name":           t.Name,
+			"distributionID": t.DistributionID,
+			"retryCount":     t.RetryCount,
+		}).Info("Transaction re-queued")
+
+		return nil
+	})
+	return t, err
+}
+
+// CancelTransaction cancels a Flow transaction which has not been sent or has
+// failed. The cancellation is audited.
+func (app *App) CancelTransaction(ctx context.Context, id uuid.UUID) (*transactions.StorableTransaction, error) {
+	var t *transactions.StorableTransaction
+	err := app.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		t, err = transactions.GetTransaction(tx, id)
+		if err != nil {
+			return err
+		}
+
+		entry, err := t.Cancel()
+		if err != nil {
+			return err
+		}
+
+		if err := t.Save(tx); err != nil {
+			return err
+		}
+
+		if err := transactions.InsertAuditEntry(tx, entry); err != nil {
+			return err
+		}
+
+		log.WithFields(log.Fields{
+			"ID":             t.ID,
+			"name":           t.Name,
+			"distributionID": t.DistributionID,
+		}).Info("Transaction cancelled")
+
+		return nil
+	})
+	return t, err
+}
+
+// checkTransactionRetryable makes sure only the transactions returning
+// collectibles to the issuer or updating the onchain state are retried once
+// the distribution of a transaction has been aborted.
+func checkTransactionRetryable(db *gorm.DB, t *transactions.StorableTransaction) error {
+	if t.DistributionID == uuid.Nil {
+		return nil
+	}
+
+	dist, err := GetDistributionSmall(db, t.DistributionID)
+	if err != nil {
+		return err
+	}
+
+	switch dist.State {
+	case common.DistributionStateRefunding, common.DistributionStateInvalid:
+		if t.Name != REFUND_SCRIPT && t.Name != UPDATE_STATE_SCRIPT {
+			return fmt.Errorf("distribution %s has been aborted, transaction %s can not be retried", dist.ID, t.ID)
+		}
+	}
+
+	return nil
+}
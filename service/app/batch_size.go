@@ -0,0 +1,59 @@
+package app
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// BatchSize is the batch size learned for a batched transaction template and
+// the contract it is sent for. It is lowered when a batch runs out of gas and
+// grown back one at a time while full batches succeed, staying below the
+// smallest batch known to run out of gas.
+type BatchSize struct {
+	gorm.Model
+	ID       uuid.UUID       `gorm:"column:id;primary_key;type:uuid;"`
+	Name     string          `gorm:"column:name;uniqueIndex:idx_batch_size"` // Transaction template
+	Contract AddressLocation `gorm:"embedded;embeddedPrefix:contract_ref_;uniqueIndex:idx_batch_size"`
+
+	Size       int `gorm:"column:size"`
+	FailedSize int `gorm:"column:failed_size"` // Smallest batch which ran out of gas, 0 if none
+}
+
+func (BatchSize) TableName() string {
+	return "batch_sizes"
+}
+
+func (b *BatchSize) BeforeCreate(tx *gorm.DB) (err error) {
+	b.ID = uuid.New()
+	return nil
+}
+
+// Failed lowers the batch size below a batch of 'n' which ran out of gas.
+func (b *BatchSize) Failed(n int) {
+	if b.FailedSize == 0 || n < b.FailedSize {
+		b.FailedSize = n
+	}
+
+	half := n / 2
+	if half < 1 {
+		half = 1
+	}
+
+	if half < b.Size {
+		b.Size = half
+	}
+}
+
+// Succeeded grows the batch size by one after a full batch of 'n' succeeded,
+// up to 'max' and below the smallest batch known to run out of gas.
+func (b *BatchSize) Succeeded(n, max int) {
+	if n < b.Size || b.Size >= max {
+		return
+	}
+
+	if b.FailedSize > 0 && b.Size+1 >= b.FailedSize {
+		return
+	}
+
+	b.Size++
+}
@@ -0,0 +1,109 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/flow-hydraulics/flow-pds/service/common"
+	"github.com/flow-hydraulics/flow-pds/service/config"
+	"github.com/flow-hydraulics/flow-pds/service/transactions"
+	"github.com/google/uuid"
+	"github.com/onflow/cadence"
+	"github.com/onflow/flow-go-sdk"
+)
+
+func TestLearnBatchSize(t *testing.T) {
+	db := newTestDB(t)
+
+	svc := &ContractService{cfg: &config.Config{SettlementBatchSize: 8}}
+	contract := AddressLocation{Name: "CollectibleNFT", Address: common.FlowAddress(flow.HexToAddress("0x01"))}
+
+	settle := func(n int) *transactions.StorableTransaction {
+		ids := make([]cadence.Value, n)
+		for i := range ids {
+			ids[i] = cadence.UInt64(i + 1)
+		}
+		tx, err := transactions.NewTransactionWithDistributionID(SETTLE_SCRIPT, []byte("script"), []cadence.Value{
+			cadence.UInt64(1),
+			cadence.String(contract.String()),
+			cadence.NewArray(ids),
+		}, uuid.New())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Save(db); err != nil {
+			t.Fatal(err)
+		}
+		return tx
+	}
+
+	expectSize := func(expected int) {
+		t.Helper()
+		size, err := svc.batchSize(db, SETTLE_SCRIPT, contract, svc.cfg.SettlementBatchSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size != expected {
+			t.Errorf("expected batch size %d, got %d", expected, size)
+		}
+	}
+
+	// Nothing learned before a batch runs out of gas
+	if err := svc.learnBatchSize(db, settle(8), false); err != nil {
+		t.Fatal(err)
+	}
+	expectSize(8)
+
+	// Running out of gas halves the batch size and splits the batch
+	tx := settle(8)
+	if err := svc.learnBatchSize(db, tx, true); err != nil {
+		t.Fatal(err)
+	}
+	expectSize(4)
+
+	split, err := svc.splitTransaction(db, tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !split || tx.State != common.TransactionStateSplit {
+		t.Fatalf("expected transaction to be split, got %s", tx.State)
+	}
+
+	children, err := transactions.List(db, transactions.ListFilter{ParentID: tx.ID}, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(children) != 2 {
+		t.Fatalf("expected 2 child transactions, got %d", len(children))
+	}
+	for _, c := range children {
+		if n, err := c.BatchLen(batchArguments[SETTLE_SCRIPT]); err != nil || n != 4 {
+			t.Errorf("expected child batch of 4, got %d (%v)", n, err)
+		}
+	}
+
+	// Full batches grow the size back, staying below the size which ran out of gas
+	for i := 0; i < 5; i++ {
+		if err := svc.learnBatchSize(db, settle(8), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectSize(7)
+
+	// Partial batches do not grow the size
+	if err := svc.learnBatchSize(db, settle(2), false); err != nil {
+		t.Fatal(err)
+	}
+	expectSize(7)
+
+	// A smaller batch running out of gas lowers the ceiling
+	if err := svc.learnBatchSize(db, settle(6), true); err != nil {
+		t.Fatal(err)
+	}
+	expectSize(3)
+	for i := 0; i < 5; i++ {
+		if err := svc.learnBatchSize(db, settle(8), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectSize(5)
+}
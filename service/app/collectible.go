@@ -22,6 +22,9 @@ type Collectibles []Collectible
 // CollectibleFromString returns a collectible from the string representation.
 func CollectibleFromString(s string) (Collectible, error) {
 	split := strings.Split(string(s), ".")
+	if len(split) != 4 || split[0] != "A" {
+		return Collectible{}, fmt.Errorf("invalid collectible '%s', expected 'A.<address>.<name>.<id>'", s)
+	}
 	address := common.FlowAddress(flow.HexToAddress(split[1]))
 	name := split[2]
 	id, err := common.FlowIDFromString(split[3])
@@ -47,6 +50,30 @@ func (c Collectible) HashString() string {
 	return c.String()
 }
 
+// Contracts returns the distinct collectible contracts in order of first appearance.
+func (cc Collectibles) Contracts() []AddressLocation {
+	res := []AddressLocation{}
+	seen := make(map[AddressLocation]bool)
+	for _, c := range cc {
+		if !seen[c.ContractReference] {
+			seen[c.ContractReference] = true
+			res = append(res, c.ContractReference)
+		}
+	}
+	return res
+}
+
+// ByContract returns the collectibles of a contract.
+func (cc Collectibles) ByContract(contract AddressLocation) Collectibles {
+	res := Collectibles{}
+	for _, c := range cc {
+		if c.ContractReference == contract {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
 // Implement sort.Interface for Collectible slice
 func (cc Collectibles) Len() int           { return len(cc) }
 func (cc Collectibles) Less(i, j int) bool { return cc[i].FlowID.LessThan(cc[j].FlowID) }
@@ -58,10 +85,18 @@ func (Collectibles) GormDataType() string {
 
 // Scan a collectibles slice from database.
 func (cc *Collectibles) Scan(value interface{}) error {
+	if value == nil {
+		*cc = Collectibles{}
+		return nil
+	}
 	str, ok := value.(string)
 	if !ok {
 		return fmt.Errorf("failed to unmarshal Collectible value: %v", value)
 	}
+	if str == "" {
+		*cc = Collectibles{}
+		return nil
+	}
 	strSplit := strings.Split(string(str), ",")
 	list := make([]Collectible, len(strSplit))
 	for i, s := range strSplit {
@@ -0,0 +1,59 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/flow-hydraulics/flow-pds/service/common"
+	"github.com/onflow/flow-go-sdk"
+)
+
+func TestCollectiblesScan(t *testing.T) {
+	ref := AddressLocation{
+		Name:    "TestCollectibleNFT",
+		Address: common.FlowAddress(flow.HexToAddress("0x2")),
+	}
+
+	cc := Collectibles{
+		{ContractReference: ref, FlowID: common.FlowID{Int64: 1, Valid: true}},
+		{ContractReference: ref, FlowID: common.FlowID{Int64: 2, Valid: true}},
+	}
+
+	for _, expected := range []Collectibles{cc, {}} {
+		value, err := expected.Value()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var scanned Collectibles
+		if err := scanned.Scan(value); err != nil {
+			t.Fatalf("didn't expect an error, got %s", err)
+		}
+
+		if !reflect.DeepEqual(scanned, expected) {
+			t.Fatalf("expected %v, got %v", expected, scanned)
+		}
+	}
+}
+
+func TestAddressLocationFromString(t *testing.T) {
+	ref := AddressLocation{
+		Name:    "TestPackNFT",
+		Address: common.FlowAddress(flow.HexToAddress("0x2")),
+	}
+
+	parsed, err := AddressLocationFromString(ref.String())
+	if err != nil {
+		t.Fatalf("didn't expect an error, got %s", err)
+	}
+
+	if parsed != ref {
+		t.Fatalf("expected %s, got %s", ref, parsed)
+	}
+
+	for _, s := range []string{"", "TestPackNFT", "A.0000000000000002", "B.0000000000000002.TestPackNFT", "A.0000000000000000.TestPackNFT"} {
+		if _, err := AddressLocationFromString(s); err == nil {
+			t.Errorf("expected an error for '%s'", s)
+		}
+	}
+}
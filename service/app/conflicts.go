@@ -0,0 +1,123 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/flow-hydraulics/flow-pds/service/common"
+	"github.com/google/uuid"
+)
+
+// CollectibleConflict is a collectible which is included more than once,
+// either within a distribution or by another live distribution.
+type CollectibleConflict struct {
+	Collectible    Collectible
+	Buckets        []int     // Indexes of the buckets containing the collectible
+	DistributionID uuid.UUID // Other distribution reserving the collectible, uuid.Nil if none
+}
+
+// DuplicateCollectiblesError is returned when a collectible appears more than
+// once in the buckets of a distribution.
+type DuplicateCollectiblesError struct {
+	Conflicts []CollectibleConflict
+}
+
+// ReservedCollectiblesError is returned when a collectible is already
+// reserved by another distribution which has not completed or been invalidated.
+type ReservedCollectiblesError struct {
+	Conflicts []CollectibleConflict
+}
+
+func (e *DuplicateCollectiblesError) Error() string {
+	s := make([]string, len(e.Conflicts))
+	for i, c := range e.Conflicts {
+		s[i] = fmt.Sprintf("%s in buckets %s", c.Collectible, joinInts(c.Buckets))
+	}
+	return fmt.Sprintf("duplicate collectibles: %s", strings.Join(s, ", "))
+}
+
+func (e *ReservedCollectiblesError) Error() string {
+	s := make([]string, len(e.Conflicts))
+	for i, c := range e.Conflicts {
+		s[i] = fmt.Sprintf("%s in bucket %d reserved by distribution %s", c.Collectible, c.Buckets[0], c.DistributionID)
+	}
+	return fmt.Sprintf("collectibles reserved by other distributions: %s", strings.Join(s, ", "))
+}
+
+// duplicateCollectibles returns the collectibles which appear more than once
+// in the buckets of a template, in order of their second appearance.
+func (pt PackTemplate) duplicateCollectibles() []CollectibleConflict {
+	conflicts := []CollectibleConflict{}
+	firstBucket := make(map[Collectible]int)
+	conflictIndex := make(map[Collectible]int)
+	for i, bucket := range pt.Buckets {
+		for _, id := range bucket.CollectibleCollection {
+			c := Collectible{ContractReference: bucket.CollectibleReference, FlowID: id}
+			first, seen := firstBucket[c]
+			if !seen {
+				firstBucket[c] = i
+				continue
+			}
+			j, ok := conflictIndex[c]
+			if !ok {
+				conflicts = append(conflicts, CollectibleConflict{Collectible: c, Buckets: []int{first}})
+				j = len(conflicts) - 1
+				conflictIndex[c] = j
+			}
+			conflicts[j].Buckets = append(conflicts[j].Buckets, i)
+		}
+	}
+	return conflicts
+}
+
+// collectibleIDs returns the IDs of the collectibles in the buckets of a
+// template grouped by their contract.
+func (pt PackTemplate) collectibleIDs() map[AddressLocation][]common.FlowID {
+	ids := make(map[AddressLocation][]common.FlowID)
+	for _, bucket := range pt.Buckets {
+		ids[bucket.CollectibleReference] = append(ids[bucket.CollectibleReference], bucket.CollectibleCollection...)
+	}
+	return ids
+}
+
+// distributionCollectibles returns the collectibles of a bucket to be stored
+// with its distribution.
+func (b Bucket) distributionCollectibles() []DistributionCollectible {
+	collectibles := make([]DistributionCollectible, len(b.CollectibleCollection))
+	for i, id := range b.CollectibleCollection {
+		collectibles[i] = DistributionCollectible{
+			DistributionID:    b.DistributionID,
+			FlowID:            id,
+			ContractReference: b.CollectibleReference,
+		}
+	}
+	return collectibles
+}
+
+// reservedCollectibles returns the collectibles of a template which are
+// included in other distributions.
+func (pt PackTemplate) reservedCollectibles(others []DistributionCollectible) []CollectibleConflict {
+	reserved := make(map[Collectible]uuid.UUID)
+	for _, c := range others {
+		reserved[Collectible{ContractReference: c.ContractReference, FlowID: c.FlowID}] = c.DistributionID
+	}
+
+	conflicts := []CollectibleConflict{}
+	for i, bucket := range pt.Buckets {
+		for _, id := range bucket.CollectibleCollection {
+			c := Collectible{ContractReference: bucket.CollectibleReference, FlowID: id}
+			if distID, ok := reserved[c]; ok {
+				conflicts = append(conflicts, CollectibleConflict{Collectible: c, Buckets: []int{i}, DistributionID: distID})
+			}
+		}
+	}
+	return conflicts
+}
+
+func joinInts(ii []int) string {
+	s := make([]string, len(ii))
+	for i, v := range ii {
+		s[i] = fmt.Sprint(v)
+	}
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,687 @@
+package app
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/flow-hydraulics/flow-pds/service/common"
+)
+
+type PackConstraintType string
+
+const (
+	// Exactly 'Count' packs contain at least one collectible of 'Tier'
+	PackConstraintExactPacks PackConstraintType = "exact-packs"
+	// Every 'Interval'th pack contains at least one collectible of 'Tier'.
+	// Positions are pack indexes in the resolved distribution (Pack.Index),
+	// not mint or sale order. Indexes are published with the shuffle proof
+	// once the packs are revealed.
+	PackConstraintEveryNth PackConstraintType = "every-nth"
+	// No pack contains two collectibles with the same key in 'Keys'
+	// (e.g. two serials of the same play or edition)
+	PackConstraintUnique PackConstraintType = "unique"
+)
+
+// PackConstraint is a rule the resolved packs of a distribution must satisfy.
+type PackConstraint struct {
+	Type     PackConstraintType `json:"type"`
+	Tier     string             `json:"tier,omitempty"`
+	Count    uint               `json:"count,omitempty"`
+	Interval uint               `json:"interval,omitempty"`
+	Keys     CollectibleKeys    `json:"keys,omitempty"` // Keyed by Collectible.String() (contract and ID)
+}
+
+// PackConstraints slice type. Allows storing the constraints of a pack template
+// embedded (as a JSON text column of 'distributions' table) in database.
+type PackConstraints []PackConstraint
+
+func (PackConstraints) GormDataType() string {
+	return "text"
+}
+
+// Scan a constraints slice from database.
+func (cc *PackConstraints) Scan(value interface{}) error {
+	var b []byte
+	switch v := value.(type) {
+	case string:
+		b = []byte(v)
+	case []byte:
+		b = v
+	case nil:
+		*cc = nil
+		return nil
+	default:
+		return fmt.Errorf("failed to unmarshal PackConstraints value: %v", value)
+	}
+	if len(b) == 0 {
+		*cc = nil
+		return nil
+	}
+	return json.Unmarshal(b, cc)
+}
+
+// Convert a constraints slice to database storable format.
+func (cc PackConstraints) Value() (driver.Value, error) {
+	if len(cc) == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal(cc)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// CollectibleKeys maps collectibles to keys, e.g. play or edition IDs.
+// Bucket metadata keys are keyed by FlowID (in decimal) as the contract is
+// that of the bucket, unique constraint keys by Collectible.String().
+// Allows storing the keys (as a JSON text column) in database.
+type CollectibleKeys map[string]string
+
+func (CollectibleKeys) GormDataType() string {
+	return "text"
+}
+
+// Scan a keys map from database.
+func (kk *CollectibleKeys) Scan(value interface{}) error {
+	var b []byte
+	switch v := value.(type) {
+	case string:
+		b = []byte(v)
+	case []byte:
+		b = v
+	case nil:
+		*kk = nil
+		return nil
+	default:
+		return fmt.Errorf("failed to unmarshal CollectibleKeys value: %v", value)
+	}
+	if len(b) == 0 {
+		*kk = nil
+		return nil
+	}
+	return json.Unmarshal(b, kk)
+}
+
+// Convert a keys map to database storable format.
+func (kk CollectibleKeys) Value() (driver.Value, error) {
+	if len(kk) == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal(kk)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (c PackConstraint) String() string {
+	switch c.Type {
+	case PackConstraintExactPacks:
+		return fmt.Sprintf("exactly %d packs contain '%s'", c.Count, c.Tier)
+	case PackConstraintEveryNth:
+		return fmt.Sprintf("every %dth pack contains '%s'", c.Interval, c.Tier)
+	case PackConstraintUnique:
+		return "unique keys within a pack"
+	default:
+		return string(c.Type)
+	}
+}
+
+// isRequired returns true if the constraint requires the pack at 'packIndex' to contain its tier.
+func (c PackConstraint) isRequired(packIndex int) bool {
+	return c.Type == PackConstraintEveryNth && c.Interval > 0 && (packIndex+1)%int(c.Interval) == 0
+}
+
+// uniqueKey returns the key of a collectible which must be unique within a pack.
+type uniqueKey func(collectible Collectible) (string, bool)
+
+// collectibleKey returns the key of a collectible for a unique constraint.
+// Keys include the contract as collectibles of different contracts may share an ID.
+func (c PackConstraint) collectibleKey(collectible Collectible) (string, bool) {
+	key, ok := c.Keys[collectible.String()]
+	return key, ok
+}
+
+// containsCollectible returns true if a bucket of the collectibles contract
+// contains the collectible.
+func (pt PackTemplate) containsCollectible(collectible Collectible) bool {
+	for _, b := range pt.Buckets {
+		if b.CollectibleReference != collectible.ContractReference {
+			continue
+		}
+		if _, ok := b.CollectibleCollection.Contains(collectible.FlowID); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// tierConstraints returns the constraints regarding tiers.
+func (pt PackTemplate) tierConstraints() []PackConstraint {
+	res := []PackConstraint{}
+	for _, c := range pt.Constraints {
+		if c.Type == PackConstraintExactPacks || c.Type == PackConstraintEveryNth {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+// uniqueKeys returns the keys which must be unique within a pack: keys of
+// unique constraints and the metadata keys of the buckets.
+func (pt PackTemplate) uniqueKeys() []uniqueKey {
+	res := []uniqueKey{}
+	for _, c := range pt.Constraints {
+		if c.Type == PackConstraintUnique {
+			res = append(res, c.collectibleKey)
+		}
+	}
+
+	metadata := make(map[string]string)
+	for _, bucket := range pt.Buckets {
+		for id, key := range bucket.CollectibleMetadataKeys {
+			flowID, err := common.FlowIDFromString(id)
+			if err != nil {
+				continue
+			}
+			c := Collectible{ContractReference: bucket.CollectibleReference, FlowID: flowID}
+			metadata[c.String()] = key
+		}
+	}
+	if len(metadata) > 0 {
+		res = append(res, func(collectible Collectible) (string, bool) {
+			key, ok := metadata[collectible.String()]
+			return key, ok
+		})
+	}
+
+	return res
+}
+
+// tierIsMandatory returns true if every pack will contain 'tier' regardless
+// of the draws (a slot group consists only of buckets of 'tier').
+func (pt PackTemplate) tierIsMandatory(groups []slotGroup, tier string) bool {
+	for _, group := range groups {
+		all := true
+		for _, b := range group.buckets {
+			if pt.Buckets[b].Tier != tier {
+				all = false
+				break
+			}
+		}
+		if all {
+			return true
+		}
+	}
+	return false
+}
+
+// tierExists returns true if any bucket is of 'tier'.
+func (pt PackTemplate) tierExists(tier string) bool {
+	for _, b := range pt.Buckets {
+		if b.Tier == tier {
+			return true
+		}
+	}
+	return false
+}
+
+// hasTier returns true if the draws of a pack include a bucket of 'tier'.
+func (pt PackTemplate) hasTier(draws []int, tier string) bool {
+	for _, b := range draws {
+		if pt.Buckets[b].Tier == tier {
+			return true
+		}
+	}
+	return false
+}
+
+// isLocked returns true if the pack at 'packIndex' is required to contain 'tier'.
+func (pt PackTemplate) isLocked(packIndex int, tier string) bool {
+	for _, c := range pt.tierConstraints() {
+		if c.Tier == tier && c.isRequired(packIndex) {
+			return true
+		}
+	}
+	return false
+}
+
+// isLockedByOther returns true if the pack at 'packIndex' is required to
+// contain any tier other than 'tier'.
+func (pt PackTemplate) isLockedByOther(packIndex int, tier string) bool {
+	for _, c := range pt.tierConstraints() {
+		if c.Tier != tier && c.isRequired(packIndex) {
+			return true
+		}
+	}
+	return false
+}
+
+// requiredPacks returns the number of packs required to contain 'tier'.
+func (pt PackTemplate) requiredPacks(tier string) uint {
+	count := uint(0)
+	for p := 0; p < int(pt.PackCount); p++ {
+		if pt.isLocked(p, tier) {
+			count++
+		}
+	}
+	return count
+}
+
+// tierSlotGroups returns the indexes of the slot groups containing a bucket of 'tier'.
+func (pt PackTemplate) tierSlotGroups(groups []slotGroup, tier string) []int {
+	res := []int{}
+	for g, group := range groups {
+		for _, b := range group.buckets {
+			if pt.Buckets[b].Tier == tier {
+				res = append(res, g)
+				break
+			}
+		}
+	}
+	return res
+}
+
+// drawFrom draws a bucket from 'candidates' by their weights.
+func (pt PackTemplate) drawFrom(candidates []int, rnd io.Reader) (int, error) {
+	if len(candidates) == 1 {
+		return candidates[0], nil
+	}
+
+	total := 0
+	for _, b := range candidates {
+		total += int(pt.Buckets[b].Weight)
+	}
+
+	// Buckets outside of a slot group may have no weight
+	if total == 0 {
+		r, err := randomIndex(rnd, len(candidates))
+		if err != nil {
+			return 0, err
+		}
+		return candidates[r], nil
+	}
+
+	r, err := randomIndex(rnd, total)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, b := range candidates {
+		w := int(pt.Buckets[b].Weight)
+		if r < w {
+			return b, nil
+		}
+		r -= w
+	}
+
+	return 0, fmt.Errorf("unable to draw a bucket")
+}
+
+// includeTier changes one draw of a pack so that the pack contains 'tier'.
+// Returns false if not possible.
+func (pt PackTemplate) includeTier(groups []slotGroup, draws []int, tier string, rnd io.Reader) (bool, error) {
+	for g, group := range groups {
+		candidates := []int{}
+		for _, b := range group.buckets {
+			if pt.Buckets[b].Tier == tier {
+				candidates = append(candidates, b)
+			}
+		}
+		if len(candidates) == 0 {
+			continue
+		}
+		b, err := pt.drawFrom(candidates, rnd)
+		if err != nil {
+			return false, err
+		}
+		draws[g] = b
+		return true, nil
+	}
+	return false, nil
+}
+
+// canExcludeTier returns true if every draw of 'tier' in a pack has an alternative.
+func (pt PackTemplate) canExcludeTier(groups []slotGroup, draws []int, tier string) bool {
+	for g, group := range groups {
+		if pt.Buckets[draws[g]].Tier != tier {
+			continue
+		}
+		ok := false
+		for _, b := range group.buckets {
+			if pt.Buckets[b].Tier != tier {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// excludeTier changes the draws of a pack so that the pack does not contain 'tier'.
+func (pt PackTemplate) excludeTier(groups []slotGroup, draws []int, tier string, rnd io.Reader) error {
+	for g, group := range groups {
+		if pt.Buckets[draws[g]].Tier != tier {
+			continue
+		}
+		candidates := []int{}
+		for _, b := range group.buckets {
+			if pt.Buckets[b].Tier != tier {
+				candidates = append(candidates, b)
+			}
+		}
+		if len(candidates) == 0 {
+			return fmt.Errorf("unable to exclude tier '%s' from slot group '%s'", tier, group.name)
+		}
+		b, err := pt.drawFrom(candidates, rnd)
+		if err != nil {
+			return err
+		}
+		draws[g] = b
+	}
+	return nil
+}
+
+// pick returns 'n' random items from 'items'.
+func pick(items []int, n int, rnd io.Reader) ([]int, error) {
+	perm, err := permutation(rnd, len(items))
+	if err != nil {
+		return nil, err
+	}
+	res := make([]int, n)
+	for i := range res {
+		res[i] = items[perm[i]]
+	}
+	return res, nil
+}
+
+// applyTierConstraints adjusts the draws of each pack to satisfy tier constraints.
+func (pt PackTemplate) applyTierConstraints(groups []slotGroup, draws [][]int, rnd io.Reader) error {
+	constraints := pt.tierConstraints()
+
+	// Packs required to contain a tier
+	for _, c := range constraints {
+		if c.Type != PackConstraintEveryNth {
+			continue
+		}
+		for p := range draws {
+			if !c.isRequired(p) || pt.hasTier(draws[p], c.Tier) {
+				continue
+			}
+			ok, err := pt.includeTier(groups, draws[p], c.Tier, rnd)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("unable to satisfy constraint: %s", c)
+			}
+		}
+	}
+
+	// Exact number of packs containing a tier
+	for _, c := range constraints {
+		if c.Type != PackConstraintExactPacks {
+			continue
+		}
+
+		with := []int{}
+		without := []int{}
+		for p := range draws {
+			if pt.hasTier(draws[p], c.Tier) {
+				with = append(with, p)
+			} else {
+				without = append(without, p)
+			}
+		}
+
+		switch {
+		case len(with) > int(c.Count):
+			candidates := []int{}
+			for _, p := range with {
+				if !pt.isLocked(p, c.Tier) && pt.canExcludeTier(groups, draws[p], c.Tier) {
+					candidates = append(candidates, p)
+				}
+			}
+			n := len(with) - int(c.Count)
+			if len(candidates) < n {
+				return fmt.Errorf("unable to satisfy constraint: %s", c)
+			}
+			picked, err := pick(candidates, n, rnd)
+			if err != nil {
+				return err
+			}
+			for _, p := range picked {
+				if err := pt.excludeTier(groups, draws[p], c.Tier, rnd); err != nil {
+					return err
+				}
+			}
+
+		case len(with) < int(c.Count):
+			n := int(c.Count) - len(with)
+			if len(without) < n {
+				return fmt.Errorf("unable to satisfy constraint: %s", c)
+			}
+			// Prefer packs not required to contain another tier
+			candidates := []int{}
+			for _, p := range without {
+				if !pt.isLockedByOther(p, c.Tier) {
+					candidates = append(candidates, p)
+				}
+			}
+			if len(candidates) < n {
+				candidates = without
+			}
+			picked, err := pick(candidates, n, rnd)
+			if err != nil {
+				return err
+			}
+			for _, p := range picked {
+				ok, err := pt.includeTier(groups, draws[p], c.Tier, rnd)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					return fmt.Errorf("unable to satisfy constraint: %s", c)
+				}
+			}
+		}
+	}
+
+	return pt.checkTierConstraints(draws)
+}
+
+// checkTierConstraints makes sure the draws satisfy all tier constraints.
+func (pt PackTemplate) checkTierConstraints(draws [][]int) error {
+	for _, c := range pt.tierConstraints() {
+		count := 0
+		for p := range draws {
+			has := pt.hasTier(draws[p], c.Tier)
+			if has {
+				count++
+			}
+			if c.isRequired(p) && !has {
+				return fmt.Errorf("unable to satisfy constraint: %s, pack %d", c, p)
+			}
+		}
+		if c.Type == PackConstraintExactPacks && count != int(c.Count) {
+			return fmt.Errorf("unable to satisfy constraint: %s, got %d", c, count)
+		}
+	}
+	return nil
+}
+
+// conflicts returns true if 'collectible' shares a key with any collectible
+// in 'pack' other than the one in slot 'skip'.
+func conflicts(keys []uniqueKey, pack Collectibles, skip int, collectible Collectible) bool {
+	for _, collectibleKey := range keys {
+		key, ok := collectibleKey(collectible)
+		if !ok {
+			continue
+		}
+		for s, other := range pack {
+			if s == skip {
+				continue
+			}
+			if otherKey, ok := collectibleKey(other); ok && otherKey == key {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// applyUniqueConstraints swaps collectibles so that no pack contains two
+// collectibles sharing a key (unique constraints and bucket metadata keys). A conflicting collectible is first swapped with
+// an unused collectible of the same bucket and then with a collectible in the
+// same slot of another pack drawn from the same bucket.
+// Returns the number of conflicts resolved.
+func (pt PackTemplate) applyUniqueConstraints(res *resolution, perms [][]int, cursors []int) (uint, error) {
+	keys := pt.uniqueKeys()
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	resolved := uint(0)
+
+	for p, pack := range res.packs {
+		for s := range pack {
+			if !conflicts(keys, pack, s, pack[s]) {
+				continue
+			}
+
+			b := res.sources[p][s]
+			bucket := pt.Buckets[b]
+			swapped := false
+
+			// Unused collectibles of the bucket
+			for i := cursors[b]; i < len(perms[b]); i++ {
+				candidate := Collectible{
+					ContractReference: bucket.CollectibleReference,
+					FlowID:            bucket.CollectibleCollection[perms[b][i]],
+				}
+				if conflicts(keys, pack, s, candidate) {
+					continue
+				}
+				// Find the permutation index of the current collectible
+				for j := 0; j < cursors[b]; j++ {
+					if bucket.CollectibleCollection[perms[b][j]] == pack[s].FlowID {
+						perms[b][i], perms[b][j] = perms[b][j], perms[b][i]
+						break
+					}
+				}
+				pack[s] = candidate
+				swapped = true
+				break
+			}
+
+			// Same slot in other packs
+			for o := 1; !swapped && o < len(res.packs); o++ {
+				q := (p + o) % len(res.packs)
+				other := res.packs[q]
+				if res.sources[q][s] != b {
+					continue
+				}
+				if conflicts(keys, pack, s, other[s]) || conflicts(keys, other, s, pack[s]) {
+					continue
+				}
+				pack[s], other[s] = other[s], pack[s]
+				swapped = true
+			}
+
+			if !swapped {
+				return resolved, fmt.Errorf("unable to satisfy unique constraint for %s in pack %d", pack[s], p)
+			}
+
+			resolved++
+		}
+	}
+
+	return resolved, nil
+}
+
+func (c PackConstraint) Validate(pt PackTemplate, groups []slotGroup) error {
+	switch c.Type {
+	case PackConstraintExactPacks, PackConstraintEveryNth:
+		if c.Tier == "" {
+			return fmt.Errorf("tier must be defined")
+		}
+		if !pt.tierExists(c.Tier) {
+			return fmt.Errorf("no bucket with tier '%s'", c.Tier)
+		}
+	case PackConstraintUnique:
+		if len(c.Keys) == 0 {
+			return fmt.Errorf("keys must be defined")
+		}
+		for k := range c.Keys {
+			collectible, err := CollectibleFromString(k)
+			if err != nil {
+				return fmt.Errorf("invalid key: %w", err)
+			}
+			if !pt.containsCollectible(collectible) {
+				return fmt.Errorf("collectible '%s' is not in a bucket of contract %s", k, collectible.ContractReference)
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown constraint type '%s'", c.Type)
+	}
+
+	mandatory := pt.tierIsMandatory(groups, c.Tier)
+
+	switch c.Type {
+	case PackConstraintExactPacks:
+		if c.Count > pt.PackCount {
+			return fmt.Errorf("count %d exceeds pack count %d", c.Count, pt.PackCount)
+		}
+		if mandatory && c.Count != pt.PackCount {
+			return fmt.Errorf("every pack contains tier '%s', count must equal pack count %d", c.Tier, pt.PackCount)
+		}
+		// Packs required by 'every-nth' constraints on the same tier
+		if required := pt.requiredPacks(c.Tier); required > c.Count {
+			return fmt.Errorf("conflicts with 'every-nth' constraints on tier '%s', at least %d packs required", c.Tier, required)
+		}
+	case PackConstraintEveryNth:
+		if c.Interval == 0 {
+			return fmt.Errorf("interval can not be zero")
+		}
+		if c.Interval > pt.PackCount {
+			return fmt.Errorf("interval %d exceeds pack count %d", c.Interval, pt.PackCount)
+		}
+	}
+
+	if mandatory {
+		return nil
+	}
+
+	// The effective odds of the buckets are well defined only if the tier
+	// is drawn in a single slot group
+	if len(pt.tierSlotGroups(groups, c.Tier)) > 1 {
+		return fmt.Errorf("tier '%s' is drawn in more than one slot group", c.Tier)
+	}
+
+	// A pack can not be required to contain two tiers drawn only in the same slot group
+	if c.Type == PackConstraintEveryNth {
+		g := pt.tierSlotGroups(groups, c.Tier)
+		for _, other := range pt.Constraints {
+			if other.Type != PackConstraintEveryNth || other.Tier == c.Tier || other.Interval == 0 {
+				continue
+			}
+			if otherGroups := pt.tierSlotGroups(groups, other.Tier); len(g) != 1 || len(otherGroups) != 1 || otherGroups[0] != g[0] {
+				continue
+			}
+			for p := 0; p < int(pt.PackCount); p++ {
+				if c.isRequired(p) && other.isRequired(p) {
+					return fmt.Errorf("conflicts with '%s' at pack %d, both tiers are drawn in the same slot group", other, p)
+				}
+			}
+		}
+	}
+
+	return nil
+}
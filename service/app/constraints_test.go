@@ -0,0 +1,459 @@
+package app
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/flow-hydraulics/flow-pds/service/common"
+	"github.com/onflow/flow-go-sdk"
+)
+
+func TestWeightedDistributionResolution(t *testing.T) {
+	collection := makeCollection(80)
+
+	packCount := 20
+
+	commons := collection[:40]
+	uncommons := collection[40:60]
+	rares := collection[60:80]
+
+	collectibleRef := AddressLocation{
+		Name:    "TestCollectibleNFT",
+		Address: common.FlowAddress(flow.HexToAddress("0x2")),
+	}
+
+	d := Distribution{
+		State:  common.DistributionStateInit,
+		FlowID: common.FlowID{Int64: int64(1), Valid: true},
+		Issuer: common.FlowAddress(flow.HexToAddress("0x1")),
+		PackTemplate: PackTemplate{
+			PackReference: AddressLocation{
+				Name:    "TestPackNFT",
+				Address: common.FlowAddress(flow.HexToAddress("0x2")),
+			},
+			PackCount: uint(packCount),
+			Buckets: []Bucket{
+				{
+					CollectibleReference:  collectibleRef,
+					CollectibleCount:      2,
+					CollectibleCollection: commons,
+					Tier:                  "common",
+				},
+				{
+					CollectibleReference:  collectibleRef,
+					CollectibleCount:      1,
+					CollectibleCollection: uncommons,
+					SlotGroup:             "slot3",
+					Weight:                9,
+					Tier:                  "uncommon",
+				},
+				{
+					CollectibleReference:  collectibleRef,
+					CollectibleCount:      1,
+					CollectibleCollection: rares,
+					SlotGroup:             "slot3",
+					Weight:                1,
+					Tier:                  "rare",
+				},
+			},
+		},
+	}
+
+	slotCount, err := d.PackTemplate.PackSlotCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if slotCount != 3 {
+		t.Fatalf("expected 3 slots, got %d", slotCount)
+	}
+
+	if err := d.Resolve(); err != nil {
+		t.Fatalf("didn't expect an error, got %s", err)
+	}
+
+	rareCount := 0
+	for _, p := range d.Packs {
+		if len(p.Collectibles) != slotCount {
+			t.Fatalf("expected there to be %d slots", slotCount)
+		}
+		if _, ok := common.FlowIDList(rares).Contains(p.Collectibles[2].FlowID); ok {
+			rareCount++
+		} else if _, ok := common.FlowIDList(uncommons).Contains(p.Collectibles[2].FlowID); !ok {
+			t.Fatalf("expected slot 3 to contain an uncommon or a rare collectible")
+		}
+	}
+
+	buckets := d.PackTemplate.Buckets
+
+	if buckets[0].DrawnPackCount != uint(packCount) {
+		t.Fatalf("expected every pack to draw from the ungrouped bucket")
+	}
+
+	if int(buckets[2].DrawnPackCount) != rareCount {
+		t.Fatalf("expected drawn pack count %d to match rare count %d", buckets[2].DrawnPackCount, rareCount)
+	}
+
+	if buckets[1].DrawnPackCount+buckets[2].DrawnPackCount != uint(packCount) {
+		t.Fatalf("expected every pack to draw once from the slot group")
+	}
+
+	odds := d.PackTemplate.Odds()
+	if odds[2].Expected != 0.1 {
+		t.Fatalf("expected rare odds to be 0.1, got %f", odds[2].Expected)
+	}
+	if odds[2].Realized != float64(rareCount)/float64(packCount) {
+		t.Fatalf("unexpected realized rare odds %f", odds[2].Realized)
+	}
+}
+
+func TestWeightedDistributionValidation(t *testing.T) {
+	collection := makeCollection(30)
+
+	collectibleRef := AddressLocation{
+		Name:    "TestCollectibleNFT",
+		Address: common.FlowAddress(flow.HexToAddress("0x2")),
+	}
+
+	makeTemplate := func(buckets ...Bucket) PackTemplate {
+		return PackTemplate{
+			PackReference: AddressLocation{
+				Name:    "TestPackNFT",
+				Address: common.FlowAddress(flow.HexToAddress("0x2")),
+			},
+			PackCount: 10,
+			Buckets:   buckets,
+		}
+	}
+
+	valid := Bucket{
+		CollectibleReference:  collectibleRef,
+		CollectibleCount:      1,
+		CollectibleCollection: collection[:10],
+		SlotGroup:             "slot",
+		Weight:                1,
+	}
+
+	zeroWeight := valid
+	zeroWeight.CollectibleCollection = collection[10:20]
+	zeroWeight.Weight = 0
+
+	if err := makeTemplate(valid, zeroWeight).Validate(); err == nil {
+		t.Error("expected a validation error for zero weight")
+	}
+
+	countMismatch := valid
+	countMismatch.CollectibleCollection = collection[10:30]
+	countMismatch.CollectibleCount = 2
+
+	if err := makeTemplate(valid, countMismatch).Validate(); err == nil {
+		t.Error("expected a validation error for mismatching collectible counts")
+	}
+
+	tooSmall := valid
+	tooSmall.CollectibleCollection = collection[10:15]
+
+	if err := makeTemplate(valid, tooSmall).Validate(); err == nil {
+		t.Error("expected a validation error for a collection too small for the worst case")
+	}
+
+	other := valid
+	other.CollectibleCollection = collection[10:20]
+	other.Weight = 3
+
+	if err := makeTemplate(valid, other).Validate(); err != nil {
+		t.Errorf("didn't expect an error, got %s", err)
+	}
+}
+
+func makeConstrainedTemplate(packCount int) PackTemplate {
+	collection := makeCollection(5 * packCount)
+
+	collectibleRef := AddressLocation{
+		Name:    "TestCollectibleNFT",
+		Address: common.FlowAddress(flow.HexToAddress("0x2")),
+	}
+
+	bucket := func(from, to int, count uint, slotGroup string, weight uint, tier string) Bucket {
+		return Bucket{
+			CollectibleReference:  collectibleRef,
+			CollectibleCount:      count,
+			CollectibleCollection: collection[from*packCount : to*packCount],
+			SlotGroup:             slotGroup,
+			Weight:                weight,
+			Tier:                  tier,
+		}
+	}
+
+	return PackTemplate{
+		PackReference: AddressLocation{
+			Name:    "TestPackNFT",
+			Address: common.FlowAddress(flow.HexToAddress("0x2")),
+		},
+		PackCount: uint(packCount),
+		Buckets: []Bucket{
+			bucket(0, 2, 2, "", 0, "common"),
+			bucket(2, 3, 1, "slot3", 8, "uncommon"),
+			bucket(3, 4, 1, "slot3", 2, "rare"),
+			bucket(4, 5, 1, "slot3", 1, "legendary"),
+		},
+	}
+}
+
+func TestConstrainedDistributionResolution(t *testing.T) {
+	packCount := 24
+
+	pt := makeConstrainedTemplate(packCount)
+	pt.Constraints = PackConstraints{
+		{Type: PackConstraintExactPacks, Tier: "legendary", Count: 5},
+		{Type: PackConstraintEveryNth, Tier: "rare", Interval: 12},
+	}
+
+	// Legendaries take 5 of 24 packs, rares the 2 required packs and 2/11
+	// of the other 22, uncommons the rest
+	odds := pt.Odds()
+	expected := []float64{1, 13.0 / 24, 6.0 / 24, 5.0 / 24}
+	for i, o := range odds {
+		if math.Abs(o.Effective-expected[i]) > 1e-9 {
+			t.Errorf("expected effective odds %f for bucket %d, got %f", expected[i], i, o.Effective)
+		}
+	}
+	if odds[3].Expected != 1.0/11 {
+		t.Errorf("expected configured odds 1/11 for bucket 3, got %f", odds[3].Expected)
+	}
+
+	for i := 0; i < 10; i++ {
+		d := Distribution{
+			State:        common.DistributionStateInit,
+			FlowID:       common.FlowID{Int64: int64(1), Valid: true},
+			Issuer:       common.FlowAddress(flow.HexToAddress("0x1")),
+			PackTemplate: pt,
+		}
+
+		if err := d.Resolve(); err != nil {
+			t.Fatalf("didn't expect an error, got %s", err)
+		}
+
+		rares := common.FlowIDList(pt.Buckets[2].CollectibleCollection)
+		legendaries := common.FlowIDList(pt.Buckets[3].CollectibleCollection)
+
+		legendaryPacks := 0
+		for _, p := range d.Packs {
+			if _, hasLegendary := legendaries.Contains(p.Collectibles[2].FlowID); hasLegendary {
+				legendaryPacks++
+			}
+			if (p.Index+1)%12 == 0 {
+				if _, hasRare := rares.Contains(p.Collectibles[2].FlowID); !hasRare {
+					t.Fatalf("expected pack %d to contain a rare", p.Index)
+				}
+			}
+		}
+
+		if legendaryPacks != 5 {
+			t.Fatalf("expected exactly 5 packs to contain a legendary, got %d", legendaryPacks)
+		}
+
+		if d.PackTemplate.Buckets[3].DrawnPackCount != 5 {
+			t.Fatalf("expected legendary drawn pack count to be 5, got %d", d.PackTemplate.Buckets[3].DrawnPackCount)
+		}
+	}
+}
+
+func TestUniqueConstraintResolution(t *testing.T) {
+	packCount := 24
+
+	pt := makeConstrainedTemplate(packCount)
+
+	// Commons are serials of only 4 plays
+	keys := make(map[string]string)
+	for i, id := range pt.Buckets[0].CollectibleCollection {
+		c := Collectible{ContractReference: pt.Buckets[0].CollectibleReference, FlowID: id}
+		keys[c.String()] = fmt.Sprintf("play-%d", i%4)
+	}
+
+	pt.Constraints = PackConstraints{
+		{Type: PackConstraintUnique, Keys: keys},
+	}
+
+	for i := 0; i < 10; i++ {
+		d := Distribution{
+			State:        common.DistributionStateInit,
+			FlowID:       common.FlowID{Int64: int64(1), Valid: true},
+			Issuer:       common.FlowAddress(flow.HexToAddress("0x1")),
+			ShuffleMode:  ShuffleModeSeeded,
+			PackTemplate: pt,
+		}
+
+		if err := d.Resolve(); err != nil {
+			t.Fatalf("didn't expect an error, got %s", err)
+		}
+
+		seen := make(map[string]bool)
+		for _, p := range d.Packs {
+			a, b := keys[p.Collectibles[0].String()], keys[p.Collectibles[1].String()]
+			if a == b {
+				t.Fatalf("expected pack %d to not contain two collectibles of %s", p.Index, a)
+			}
+			for _, c := range p.Collectibles {
+				if seen[c.FlowID.String()] {
+					t.Fatalf("collectible %s in more than one pack", c.FlowID)
+				}
+				seen[c.FlowID.String()] = true
+			}
+		}
+
+		// The solver must stay verifiable
+		proof, err := ShuffleProofFromDistribution(&d, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		proof.ShuffleSeed = d.ShuffleSeed
+		resolved, err := VerifyShuffle(*proof, nil, nil)
+		if err != nil {
+			t.Fatalf("didn't expect an error, got %s", err)
+		}
+		for _, p := range d.Packs {
+			if !reflect.DeepEqual(resolved[p.Index], p.Collectibles) {
+				t.Fatalf("expected recomputed pack %d to match", p.Index)
+			}
+		}
+	}
+
+	// Every common is the same play
+	for id := range keys {
+		keys[id] = "play"
+	}
+
+	d := Distribution{
+		State:        common.DistributionStateInit,
+		FlowID:       common.FlowID{Int64: int64(1), Valid: true},
+		Issuer:       common.FlowAddress(flow.HexToAddress("0x1")),
+		PackTemplate: pt,
+	}
+
+	if err := d.Resolve(); err == nil {
+		t.Fatal("expected an error for an unsatisfiable unique constraint")
+	}
+}
+
+func TestConstraintValidation(t *testing.T) {
+	pt := makeConstrainedTemplate(24)
+
+	invalid := []PackConstraints{
+		{{Type: "unknown"}},
+		{{Type: PackConstraintExactPacks, Count: 5}},
+		{{Type: PackConstraintExactPacks, Tier: "mythic", Count: 5}},
+		{{Type: PackConstraintExactPacks, Tier: "legendary", Count: 25}},
+		{{Type: PackConstraintExactPacks, Tier: "common", Count: 5}},
+		{{Type: PackConstraintEveryNth, Tier: "rare", Count: 12}},
+		{{Type: PackConstraintEveryNth, Interval: 12}},
+		{{Type: PackConstraintEveryNth, Tier: "rare", Interval: 25}},
+		{{Type: PackConstraintEveryNth, Tier: "rare", Interval: 12}, {Type: PackConstraintExactPacks, Tier: "rare", Count: 1}},
+		{{Type: PackConstraintEveryNth, Tier: "rare", Interval: 2}, {Type: PackConstraintEveryNth, Tier: "legendary", Interval: 3}},
+		{{Type: PackConstraintUnique}},
+		{{Type: PackConstraintUnique, Keys: CollectibleKeys{"1": "play"}}},
+		{{Type: PackConstraintUnique, Keys: CollectibleKeys{"A.0000000000000003.OtherNFT.1": "play"}}},
+	}
+
+	for i, constraints := range invalid {
+		pt.Constraints = constraints
+		if err := pt.Validate(); err == nil {
+			t.Errorf("expected a validation error for constraints %d", i)
+		}
+	}
+
+	pt.Constraints = PackConstraints{
+		{Type: PackConstraintExactPacks, Tier: "common", Count: 24},
+		{Type: PackConstraintExactPacks, Tier: "rare", Count: 2},
+		{Type: PackConstraintEveryNth, Tier: "rare", Interval: 12},
+		{Type: PackConstraintEveryNth, Tier: "common", Interval: 1},
+		{Type: PackConstraintEveryNth, Tier: "legendary", Interval: 5},
+	}
+
+	if err := pt.Validate(); err != nil {
+		t.Errorf("didn't expect an error, got %s", err)
+	}
+
+	// A tier drawn in more than one slot group has no well defined odds
+	multi := makeConstrainedTemplate(24)
+	extra := make(common.FlowIDList, 48)
+	for i := range extra {
+		extra[i] = common.FlowID{Int64: int64(1000 + i), Valid: true}
+	}
+	multi.Buckets = append(multi.Buckets,
+		Bucket{CollectibleReference: multi.Buckets[0].CollectibleReference, CollectibleCount: 1, CollectibleCollection: extra[:24], SlotGroup: "slot4", Weight: 1, Tier: "uncommon"},
+		Bucket{CollectibleReference: multi.Buckets[0].CollectibleReference, CollectibleCount: 1, CollectibleCollection: extra[24:], SlotGroup: "slot4", Weight: 1, Tier: "rare"},
+	)
+	if err := multi.Validate(); err != nil {
+		t.Fatalf("didn't expect an error, got %s", err)
+	}
+	multi.Constraints = PackConstraints{{Type: PackConstraintExactPacks, Tier: "rare", Count: 2}}
+	if err := multi.Validate(); err == nil {
+		t.Error("expected a validation error for a tier drawn in more than one slot group")
+	}
+	multi.Constraints = PackConstraints{{Type: PackConstraintEveryNth, Tier: "rare", Interval: 12}}
+	if err := multi.Validate(); err == nil {
+		t.Error("expected a validation error for an every-nth tier drawn in more than one slot group")
+	}
+
+	// Collectibles of different contracts may share an ID
+	other := AddressLocation{Name: "OtherNFT", Address: common.FlowAddress(flow.HexToAddress("0x3"))}
+	a := Collectible{ContractReference: pt.Buckets[0].CollectibleReference, FlowID: pt.Buckets[0].CollectibleCollection[0]}
+	b := Collectible{ContractReference: other, FlowID: a.FlowID}
+	unique := PackConstraint{Type: PackConstraintUnique, Keys: CollectibleKeys{a.String(): "play"}}
+	if key, ok := unique.collectibleKey(a); !ok || key != "play" {
+		t.Errorf("expected key of %s, got '%s'", a, key)
+	}
+	if _, ok := unique.collectibleKey(b); ok {
+		t.Errorf("expected no key for %s", b)
+	}
+}
+
+func TestMetadataKeyResolution(t *testing.T) {
+	packCount := 24
+
+	pt := makeConstrainedTemplate(packCount)
+
+	// Commons are serials of only 4 editions
+	bucket := &pt.Buckets[0]
+	bucket.CollectibleMetadataKeys = make(CollectibleKeys)
+	for i, id := range bucket.CollectibleCollection {
+		bucket.CollectibleMetadataKeys[id.String()] = fmt.Sprintf("edition-%d", i%4)
+	}
+
+	d := Distribution{
+		State:        common.DistributionStateInit,
+		FlowID:       common.FlowID{Int64: int64(1), Valid: true},
+		Issuer:       common.FlowAddress(flow.HexToAddress("0x1")),
+		ShuffleMode:  ShuffleModeSeeded,
+		ShuffleSeed:  make(common.BinaryValue, SHUFFLE_SEED_LENGTH_IN_BYTES),
+		PackTemplate: pt,
+	}
+
+	if err := d.Resolve(); err != nil {
+		t.Fatalf("didn't expect an error, got %s", err)
+	}
+
+	for _, p := range d.Packs {
+		a := bucket.CollectibleMetadataKeys[p.Collectibles[0].FlowID.String()]
+		b := bucket.CollectibleMetadataKeys[p.Collectibles[1].FlowID.String()]
+		if a == b {
+			t.Fatalf("expected pack %d to not contain two collectibles of %s", p.Index, a)
+		}
+	}
+
+	// Fixed seed, two commons of 4 editions collide in roughly every 4th pack
+	if d.ResolutionConflicts == 0 {
+		t.Error("expected the resolver to report conflicts")
+	}
+
+	unknown := pt
+	unknown.Buckets = append([]Bucket{}, pt.Buckets...)
+	unknown.Buckets[0].CollectibleMetadataKeys = CollectibleKeys{"1000": "edition-1"}
+
+	if err := unknown.Validate(); err == nil {
+		t.Error("expected a validation error for a metadata key of an unknown collectible")
+	}
+}
@@ -1,8 +1,11 @@
 package app
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/flow-hydraulics/flow-pds/service/common"
@@ -23,6 +26,8 @@ const (
 	REVEALED       = "Revealed"
 	OPEN_REQUEST   = "OpenRequest"
 	OPENED         = "Opened"
+	DEPOSIT        = "Deposit"
+	MINT           = "Mint"
 )
 
 const (
@@ -33,6 +38,20 @@ const (
 	REVEAL_SCRIPT           = "./cadence-transactions/pds/reveal_packNFT.cdc"
 	OPEN_SCRIPT             = "./cadence-transactions/pds/open_packNFT.cdc"
 	UPDATE_STATE_SCRIPT     = "./cadence-transactions/pds/update_dist_state.cdc"
+	REFUND_SCRIPT           = "./cadence-transactions/pds/refund.cdc"
+	COMMIT_SHUFFLE_SCRIPT   = "./cadence-transactions/pds/commit_shuffle_seed.cdc"
+)
+
+const (
+	GET_NEXT_DIST_ID_SCRIPT            = "./cadence-scripts/pds/get_next_dist_id.cdc"
+	GET_DIST_STATE_SCRIPT              = "./cadence-scripts/pds/get_dist_state.cdc"
+	GET_DIST_ISSUER_SCRIPT             = "./cadence-scripts/pds/get_dist_issuer.cdc"
+	GET_DIST_TITLE_SCRIPT              = "./cadence-scripts/pds/get_dist_title.cdc"
+	GET_DIST_METADATA_SCRIPT           = "./cadence-scripts/pds/get_dist_metadata.cdc"
+	CHECK_ISSUER_COLLECTION_SCRIPT     = "./cadence-scripts/pds/check_issuer_collection.cdc"
+	COLLECTIBLE_BALANCE_IDS_SCRIPT     = "./cadence-scripts/collectibleNFT/balance_ids.cdc"
+	MISSING_ISSUER_COLLECTIBLES_SCRIPT = "./cadence-scripts/pds/missing_issuer_collectibles.cdc"
+	GET_SHUFFLE_COMMITMENT_SCRIPT      = "./cadence-scripts/pds/get_shuffle_commitment.cdc"
 )
 
 // ContractService handles interfacing with the chain
@@ -63,6 +82,22 @@ func NewContractService(cfg *config.Config, flowClient *client.Client) (*Contrac
 	return &ContractService{cfg, flowClient, pdsAccount}, nil
 }
 
+// retryPolicies returns the configured retry policy of each class of transaction errors.
+func (svc *ContractService) retryPolicies() transactions.RetryPolicies {
+	return transactions.RetryPolicies{
+		flow_helpers.ErrorClassTransient: {
+			MaxRetries: svc.cfg.TransientMaxRetries,
+			BaseDelay:  svc.cfg.TransientRetryDelay,
+			MaxDelay:   svc.cfg.MaxRetryDelay,
+		},
+		flow_helpers.ErrorClassCadence: {
+			MaxRetries: svc.cfg.CadenceMaxRetries,
+			BaseDelay:  svc.cfg.CadenceRetryDelay,
+			MaxDelay:   svc.cfg.MaxRetryDelay,
+		},
+	}
+}
+
 func (svc *ContractService) SetDistCap(ctx context.Context, db *gorm.DB, issuer common.FlowAddress) error {
 	logger := log.WithFields(log.Fields{
 		"method": "SetDistCap",
@@ -109,6 +144,356 @@ func (svc *ContractService) SetDistCap(ctx context.Context, db *gorm.DB, issuer
 	return nil
 }
 
+// VerifyDistribution checks that the distribution exists onchain, is still
+// in initialized state and was created through the PackIssuer of its issuer.
+// Distributions whose PackIssuer is not recorded onchain are rejected.
+func (svc *ContractService) VerifyDistribution(ctx context.Context, dist *Distribution) error {
+	nextIDScript, err := flow_helpers.ParseCadenceTemplate(GET_NEXT_DIST_ID_SCRIPT, nil)
+	if err != nil {
+		return err
+	}
+
+	nextIDValue, err := svc.flowClient.ExecuteScriptAtLatestBlock(ctx, nextIDScript, nil)
+	if err != nil {
+		return err
+	}
+
+	nextID, ok := nextIDValue.(cadence.UInt64)
+	if !ok {
+		return fmt.Errorf("unexpected next distribution ID: %v", nextIDValue)
+	}
+
+	// Onchain distribution IDs start from 1
+	if dist.FlowID.Int64 < 1 || uint64(dist.FlowID.Int64) >= uint64(nextID) {
+		return fmt.Errorf("distribution %d does not exist onchain", dist.FlowID.Int64)
+	}
+
+	state, err := svc.GetOnchainState(ctx, dist.FlowID)
+	if err != nil {
+		return err
+	}
+
+	if state != common.OnchainDistStateInitialized {
+		return fmt.Errorf("distribution %d is not in initialized state onchain, got %s", dist.FlowID.Int64, state)
+	}
+
+	arguments := []cadence.Value{
+		cadence.UInt64(dist.FlowID.Int64),
+	}
+
+	issuerScript, err := flow_helpers.ParseCadenceTemplate(GET_DIST_ISSUER_SCRIPT, nil)
+	if err != nil {
+		return err
+	}
+
+	issuerValue, err := svc.flowClient.ExecuteScriptAtLatestBlock(ctx, issuerScript, arguments)
+	if err != nil {
+		return err
+	}
+
+	issuer, ok := issuerValue.(cadence.Optional)
+	if !ok || issuer.Value == nil {
+		return fmt.Errorf("issuer of distribution %d is unknown onchain", dist.FlowID.Int64)
+	}
+
+	if address, ok := issuer.Value.(cadence.Address); !ok || common.FlowAddress(address) != dist.Issuer {
+		return fmt.Errorf("distribution %d was not created by issuer %s", dist.FlowID.Int64, dist.Issuer)
+	}
+
+	return nil
+}
+
+// GetOnchainState reads the state of a distribution from chain.
+func (svc *ContractService) GetOnchainState(ctx context.Context, distFlowID common.FlowID) (common.OnchainDistState, error) {
+	script, err := flow_helpers.ParseCadenceTemplate(GET_DIST_STATE_SCRIPT, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	arguments := []cadence.Value{
+		cadence.UInt64(distFlowID.Int64),
+	}
+
+	value, err := svc.flowClient.ExecuteScriptAtLatestBlock(ctx, script, arguments)
+	if err != nil {
+		return 0, err
+	}
+
+	state, ok := value.(cadence.UInt8)
+	if !ok {
+		return 0, fmt.Errorf("unexpected state for distribution %d: %v", distFlowID.Int64, value)
+	}
+
+	return common.OnchainDistState(state), nil
+}
+
+// GetDistributionInfo reads the title and metadata of a distribution from chain.
+func (svc *ContractService) GetDistributionInfo(ctx context.Context, distFlowID common.FlowID) (string, map[string]string, error) {
+	arguments := []cadence.Value{
+		cadence.UInt64(distFlowID.Int64),
+	}
+
+	titleScript, err := flow_helpers.ParseCadenceTemplate(GET_DIST_TITLE_SCRIPT, nil)
+	if err != nil {
+		return "", nil, err
+	}
+
+	titleValue, err := svc.flowClient.ExecuteScriptAtLatestBlock(ctx, titleScript, arguments)
+	if err != nil {
+		return "", nil, err
+	}
+
+	title, ok := titleValue.(cadence.String)
+	if !ok {
+		return "", nil, fmt.Errorf("unexpected distribution title: %v", titleValue)
+	}
+
+	metadataScript, err := flow_helpers.ParseCadenceTemplate(GET_DIST_METADATA_SCRIPT, nil)
+	if err != nil {
+		return "", nil, err
+	}
+
+	metadataValue, err := svc.flowClient.ExecuteScriptAtLatestBlock(ctx, metadataScript, arguments)
+	if err != nil {
+		return "", nil, err
+	}
+
+	dict, ok := metadataValue.(cadence.Dictionary)
+	if !ok {
+		return "", nil, fmt.Errorf("unexpected distribution metadata: %v", metadataValue)
+	}
+
+	metadata := make(map[string]string, len(dict.Pairs))
+	for _, pair := range dict.Pairs {
+		k, kOk := pair.Key.(cadence.String)
+		v, vOk := pair.Value.(cadence.String)
+		if !kOk || !vOk {
+			return "", nil, fmt.Errorf("unexpected distribution metadata pair: %v", pair)
+		}
+		metadata[string(k)] = string(v)
+	}
+
+	return string(title), metadata, nil
+}
+
+// GetShuffleCommitment reads the shuffle seed commitment of a verifiable
+// distribution from PDS contract state, nil if none has been published.
+func (svc *ContractService) GetShuffleCommitment(ctx context.Context, distFlowID common.FlowID) (*ShuffleCommitment, error) {
+	script, err := flow_helpers.ParseCadenceTemplate(GET_SHUFFLE_COMMITMENT_SCRIPT, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	arguments := []cadence.Value{
+		cadence.UInt64(distFlowID.Int64),
+	}
+
+	value, err := svc.flowClient.ExecuteScriptAtLatestBlock(ctx, script, arguments)
+	if err != nil {
+		return nil, err
+	}
+
+	optional, ok := value.(cadence.Optional)
+	if !ok {
+		return nil, fmt.Errorf("unexpected shuffle seed commitment for distribution %d: %v", distFlowID.Int64, value)
+	}
+
+	if optional.Value == nil {
+		return nil, nil
+	}
+
+	s, ok := optional.Value.(cadence.Struct)
+	if !ok || len(s.Fields) != 3 {
+		return nil, fmt.Errorf("unexpected shuffle seed commitment for distribution %d: %v", distFlowID.Int64, value)
+	}
+
+	commitment, err := common.BinaryValueFromCadence(s.Fields[0])
+	if err != nil {
+		return nil, err
+	}
+
+	blockHeight, ok := s.Fields[1].(cadence.UInt64)
+	if !ok {
+		return nil, fmt.Errorf("unexpected shuffle seed commitment block height for distribution %d: %v", distFlowID.Int64, s.Fields[1])
+	}
+
+	entropyBlockHeight, ok := s.Fields[2].(cadence.UInt64)
+	if !ok {
+		return nil, fmt.Errorf("unexpected entropy block height for distribution %d: %v", distFlowID.Int64, s.Fields[2])
+	}
+
+	return &ShuffleCommitment{
+		DistFlowID:         distFlowID,
+		Commitment:         commitment,
+		BlockHeight:        uint64(blockHeight),
+		EntropyBlockHeight: uint64(entropyBlockHeight),
+	}, nil
+}
+
+// GetEntropyBlockID reads the ID of the sealed block at the given height from
+// chain, to check the entropy block of a verifiable distribution.
+func (svc *ContractService) GetEntropyBlockID(ctx context.Context, height uint64) (common.BinaryValue, error) {
+	latestBlockHeader, err := svc.flowClient.GetLatestBlockHeader(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if latestBlockHeader.Height < height {
+		return nil, fmt.Errorf("entropy block %d not sealed yet", height)
+	}
+
+	header, err := svc.flowClient.GetBlockHeaderByHeight(ctx, height)
+	if err != nil {
+		return nil, err
+	}
+
+	return header.ID.Bytes(), nil
+}
+
+// ResolveDistribution resolves a 'verifiable' distribution once its entropy
+// block has been sealed. The ID of the entropy block is mixed with the
+// committed shuffle seed to shuffle the collectibles into packs.
+// Until the entropy block is fixed the seed commitment is published onchain.
+func (svc *ContractService) ResolveDistribution(ctx context.Context, db *gorm.DB, dist *Distribution) error {
+	logger := log.WithFields(log.Fields{
+		"method":             "ResolveDistribution",
+		"distID":             dist.ID,
+		"distFlowID":         dist.FlowID,
+		"entropyBlockHeight": dist.EntropyBlockHeight,
+	})
+
+	if dist.ShuffleMode != ShuffleModeVerifiable {
+		// Other distributions are resolved when created
+		return nil // commit
+	}
+
+	if dist.EntropyBlockHeight == 0 {
+		return svc.publishShuffleCommitment(ctx, db, dist, logger)
+	}
+
+	latestBlockHeader, err := svc.flowClient.GetLatestBlockHeader(ctx, true)
+	if err != nil {
+		return err // rollback
+	}
+
+	if latestBlockHeader.Height < dist.EntropyBlockHeight {
+		logger.Trace("Entropy block not sealed yet")
+		return nil // commit
+	}
+
+	logger.Info("Resolve distribution")
+
+	entropyBlockHeader, err := svc.flowClient.GetBlockHeaderByHeight(ctx, dist.EntropyBlockHeight)
+	if err != nil {
+		return err // rollback
+	}
+
+	// Fetch buckets with their collections
+	full, err := GetDistributionBig(db, dist.ID)
+	if err != nil {
+		return err // rollback
+	}
+
+	if err := full.ResolveWithEntropyBlock(entropyBlockHeader.ID.Bytes()); err != nil {
+		return err // rollback
+	}
+
+	if err := InsertDistributionPacks(db, full, svc.cfg.BatchInsertSize); err != nil {
+		return err // rollback
+	}
+
+	// Store the realized odds
+	if err := UpdateDistributionBuckets(db, full); err != nil {
+		return err // rollback
+	}
+
+	// Update the distribution in database
+	if err := UpdateDistribution(db, full); err != nil {
+		return err // rollback
+	}
+
+	*dist = *full
+
+	logger.WithFields(log.Fields{
+		"entropyBlockID": entropyBlockHeader.ID,
+	}).Trace("Resolve distribution complete")
+
+	return nil // commit
+}
+
+// publishShuffleCommitment saves a transaction publishing the shuffle seed
+// commitment of a verifiable distribution onchain. Once the transaction has
+// been sealed the entropy block of the distribution is read from the
+// commitment stored onchain.
+func (svc *ContractService) publishShuffleCommitment(ctx context.Context, db *gorm.DB, dist *Distribution, logger *log.Entry) error {
+	published, err := transactions.List(db, transactions.ListFilter{DistributionID: dist.ID, Name: COMMIT_SHUFFLE_SCRIPT}, 1, 0)
+	if err != nil {
+		return err // rollback
+	}
+
+	if len(published) == 0 {
+		txScript, err := flow_helpers.ParseCadenceTemplate(COMMIT_SHUFFLE_SCRIPT, nil)
+		if err != nil {
+			return err // rollback
+		}
+
+		arguments := []cadence.Value{
+			cadence.UInt64(dist.FlowID.Int64),
+			cadence.String(dist.ShuffleSeedCommitment.String()),
+			cadence.UInt64(svc.cfg.EntropyBlockDelay),
+		}
+
+		t, err := transactions.NewTransactionWithDistributionID(COMMIT_SHUFFLE_SCRIPT, txScript, arguments, dist.ID)
+		if err != nil {
+			return err // rollback
+		}
+
+		if err := t.Save(db); err != nil {
+			return err // rollback
+		}
+
+		logger.Info("Shuffle seed commitment transaction saved")
+
+		return nil // commit
+	}
+
+	t := published[0]
+
+	switch t.State {
+	case common.TransactionStateComplete:
+		commitment, err := svc.GetShuffleCommitment(ctx, dist.FlowID)
+		if err != nil {
+			return err // rollback
+		}
+
+		if commitment == nil || !bytes.Equal(commitment.Commitment, dist.ShuffleSeedCommitment) {
+			return fmt.Errorf("onchain shuffle seed commitment does not match distribution %s", dist.ID) // rollback
+		}
+
+		if err := dist.FixEntropyBlock(t.TransactionID, commitment.EntropyBlockHeight); err != nil {
+			return err // rollback
+		}
+
+		if err := UpdateDistribution(db, dist); err != nil {
+			return err // rollback
+		}
+
+		logger.WithFields(log.Fields{
+			"commitmentTxID":     dist.CommitmentTxID,
+			"entropyBlockHeight": dist.EntropyBlockHeight,
+		}).Info("Shuffle seed commitment published")
+
+	case common.TransactionStateFailed, common.TransactionStateCancelled:
+		dist.InvalidReason = fmt.Sprintf("shuffle seed commitment could not be published onchain: %s", t.Error)
+
+		logger.Warn("Shuffle seed commitment transaction failed, invalidating distribution")
+
+		return svc.invalidate(db, dist, logger)
+	}
+
+	return nil // commit
+}
+
 // SetupDistribution will make sure the PDS account has a collection onchain
 // for the collectible NFTs in the Distribution.
 // It also makes sure the withdraw capability is linked.
@@ -203,6 +588,8 @@ func (svc *ContractService) SetupDistribution(ctx context.Context, db *gorm.DB,
 
 // StartSettlement sets the given distributions state to 'settling' and starts the settlement
 // phase onchain.
+// Before settling it checks that the issuer still owns every collectible and
+// that PDS can withdraw them. If not, the distribution is invalidated instead.
 // It lists all collectible NFTs in the distribution and creates batches
 // of 'SETTLE_BATCH_SIZE' from them.
 // It then creates and stores the settlement Flow transactions (PDS account withdraw from issuer to escrow) in
@@ -217,6 +604,35 @@ func (svc *ContractService) StartSettlement(ctx context.Context, db *gorm.DB, di
 
 	logger.Info("Start settlement")
 
+	missing, unlinked, err := svc.checkIssuerCollectibles(ctx, db, dist)
+	if err != nil {
+		return err // rollback
+	}
+
+	if len(missing) > 0 || len(unlinked) > 0 {
+		reasons := []string{}
+		if len(unlinked) > 0 {
+			contracts := make([]string, len(unlinked))
+			for i, c := range unlinked {
+				contracts[i] = c.String()
+			}
+			reasons = append(reasons, fmt.Sprintf("can not withdraw from issuer collections: %s", strings.Join(contracts, ", ")))
+		}
+		if len(missing) > 0 {
+			reasons = append(reasons, fmt.Sprintf("issuer does not own %d collectibles", len(missing)))
+		}
+
+		dist.InvalidReason = strings.Join(reasons, "; ")
+		dist.MissingCollectibles = missing
+
+		logger.WithFields(log.Fields{
+			"missingCount":  len(missing),
+			"unlinkedCount": len(unlinked),
+		}).Warn("Issuer collectibles check failed, invalidating distribution")
+
+		return svc.invalidate(db, dist, logger)
+	}
+
 	// Make sure the distribution is in correct state
 	if err := dist.SetSettling(); err != nil {
 		return err // rollback
@@ -237,7 +653,9 @@ func (svc *ContractService) StartSettlement(ctx context.Context, db *gorm.DB, di
 		CurrentCount:   0,
 		TotalCount:     0, // Update later
 		StartAtBlock:   latestBlockHeader.Height - 1,
+		PhaseDeadline:  NewPhaseDeadline(time.Now(), svc.cfg.SettlementTimeout),
 		EscrowAddress:  common.FlowAddressFromString(svc.cfg.AdminAddress),
+		ReconciledAt:   time.Now(),
 	}
 
 	if err := InsertSettlement(db, &settlement); err != nil {
@@ -281,58 +699,14 @@ func (svc *ContractService) StartSettlement(ctx context.Context, db *gorm.DB, di
 		return err // rollback
 	}
 
-	err = NotSettledCollectiblesInBatches(db, settlement.ID, svc.cfg.SettlementBatchSize, func(tx *gorm.DB, batchNumber int, batch SettlementCollectibles) error {
-		for contract, collectibles := range batch.GroupByContract() {
-			txScript, err := flow_helpers.ParseCadenceTemplate(
-				SETTLE_SCRIPT,
-				&flow_helpers.CadenceTemplateVars{
-					CollectibleNFTName:    contract.Name,
-					CollectibleNFTAddress: contract.Address.String(),
-				},
-			)
-			if err != nil {
-				return err // rollback
-			}
-
-			batchLogger := logger.WithFields(log.Fields{
-				"batchNumber": batchNumber,
-			})
-
-			batchLogger.Debug("Initiating settle transaction")
-
-			flowIDs := make([]cadence.Value, len(collectibles))
-			for i, c := range batch {
-				flowIDs[i] = cadence.UInt64(c.FlowID.Int64)
-			}
+	if err := svc.queueSettleTransactions(db, dist, &settlement, logger); err != nil {
+		return err // rollback
+	}
 
-			arguments := []cadence.Value{
-				cadence.UInt64(dist.FlowID.Int64),
-				cadence.NewArray(flowIDs),
-			}
+	logger.Trace("Start settlement complete")
 
-			t, err := transactions.NewTransactionWithDistributionID(SETTLE_SCRIPT, txScript, arguments, dist.ID)
-			if err != nil {
-				return err // rollback
-			}
-
-			if err := t.Save(db); err != nil {
-				return err // rollback
-			}
-
-			batchLogger.Trace("Settle transaction saved")
-		}
-
-		return nil
-	})
-
-	if err != nil {
-		return err // rollback
-	}
-
-	logger.Trace("Start settlement complete")
-
-	return nil // commit
-}
+	return nil // commit
+}
 
 // StartMinting sets the given distributions state to 'minting' and starts the minting
 // phase onchain.
@@ -401,6 +775,11 @@ func (svc *ContractService) StartMinting(ctx context.Context, db *gorm.DB, dist
 				return err // rollback
 			}
 
+			// Events already handled are skipped when replayed
+			if err := RewindEventCursors(db, existing.ID, cpc.StartAtBlock); err != nil {
+				return err // rollback
+			}
+
 			// Use the existing one from now on
 			cpc = *existing
 		}
@@ -411,15 +790,113 @@ func (svc *ContractService) StartMinting(ctx context.Context, db *gorm.DB, dist
 		CurrentCount:   0,
 		TotalCount:     0, // Update later
 		StartAtBlock:   latestBlockHeader.Height - 1,
+		PhaseDeadline:  NewPhaseDeadline(time.Now(), svc.cfg.MintingTimeout),
 	}
 
 	if err := InsertMinting(db, &minting); err != nil {
 		return err // rollback
 	}
 
+	totalPackCount, err := svc.queueMintTransactions(db, dist, logger)
+	if err != nil {
+		return err // rollback
+	}
+
+	minting.TotalCount = uint(totalPackCount)
+
+	if err := UpdateMinting(db, &minting); err != nil {
+		return err // rollback
+	}
+
+	logger.Trace("Start minting complete")
+
+	return nil // commit
+}
+
+// queueSettleTransactions creates and stores settle Flow transactions in
+// batches of 'SETTLEMENT_BATCH_SIZE' for the collectibles of a settlement
+// which have not been settled yet.
+func (svc *ContractService) queueSettleTransactions(db *gorm.DB, dist *Distribution, settlement *Settlement, logger *log.Entry) error {
+	err := NotSettledCollectiblesInBatches(db, settlement.ID, svc.cfg.SettlementBatchSize, func(tx *gorm.DB, batchNumber int, batch SettlementCollectibles) error {
+		for contract, collectibles := range batch.GroupByContract() {
+			txScript, err := flow_helpers.ParseCadenceTemplate(
+				SETTLE_SCRIPT,
+				&flow_helpers.CadenceTemplateVars{
+					CollectibleNFTName:    contract.Name,
+					CollectibleNFTAddress: contract.Address.String(),
+				},
+			)
+			if err != nil {
+				return err
+			}
+
+			// Stay within the batch size learned for the contract
+			size, err := svc.batchSize(db, SETTLE_SCRIPT, contract, svc.cfg.SettlementBatchSize)
+			if err != nil {
+				return err
+			}
+
+			for begin := 0; begin < len(collectibles); begin += size {
+				end := begin + size
+				if end > len(collectibles) {
+					end = len(collectibles)
+				}
+
+				batchLogger := logger.WithFields(log.Fields{
+					"batchNumber": batchNumber,
+					"contract":    contract.String(),
+					"batchSize":   size,
+				})
+
+				batchLogger.Debug("Initiating settle transaction")
+
+				flowIDs := make([]cadence.Value, end-begin)
+				for i, c := range collectibles[begin:end] {
+					flowIDs[i] = cadence.UInt64(c.FlowID.Int64)
+				}
+
+				arguments := []cadence.Value{
+					cadence.UInt64(dist.FlowID.Int64),
+					cadence.String(contract.String()),
+					cadence.NewArray(flowIDs),
+				}
+
+				t, err := transactions.NewTransactionWithDistributionID(SETTLE_SCRIPT, txScript, arguments, dist.ID)
+				if err != nil {
+					return err
+				}
+
+				if err := t.Save(db); err != nil {
+					return err
+				}
+
+				batchLogger.Trace("Settle transaction saved")
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// queueMintTransactions creates and stores mint Flow transactions in batches
+// of 'MINT_BATCH_SIZE' for the packs of a distribution which have not been
+// minted yet. It returns the amount of packs queued.
+func (svc *ContractService) queueMintTransactions(db *gorm.DB, dist *Distribution, logger *log.Entry) (int, error) {
 	totalPackCount := 0
 
-	err = DistributionPacksInBatches(db, dist.ID, svc.cfg.MintingBatchSize, func(tx *gorm.DB, batchNumber int, batch []Pack) error {
+	// Stay within the batch size learned for the pack contract
+	size, err := svc.batchSize(db, MINT_SCRIPT, dist.PackTemplate.PackReference, svc.cfg.MintingBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	err = NotMintedPacksInBatches(db, dist.ID, size, func(tx *gorm.DB, batchNumber int, batch []Pack) error {
 		totalPackCount += len(batch)
 
 		txScript, err := flow_helpers.ParseCadenceTemplate(
@@ -452,11 +929,11 @@ func (svc *ContractService) StartMinting(ctx context.Context, db *gorm.DB, dist
 
 		t, err := transactions.NewTransactionWithDistributionID(MINT_SCRIPT, txScript, arguments, dist.ID)
 		if err != nil {
-			return err // rollback
+			return err
 		}
 
 		if err := t.Save(db); err != nil {
-			return err // rollback
+			return err
 		}
 
 		batchLogger.Trace("Mint transaction saved")
@@ -465,18 +942,10 @@ func (svc *ContractService) StartMinting(ctx context.Context, db *gorm.DB, dist
 	})
 
 	if err != nil {
-		return err // rollback
-	}
-
-	minting.TotalCount = uint(totalPackCount)
-
-	if err := UpdateMinting(db, &minting); err != nil {
-		return err // rollback
+		return 0, err
 	}
 
-	logger.Trace("Start minting complete")
-
-	return nil // commit
+	return totalPackCount, nil
 }
 
 // Abort a distribution
@@ -489,143 +958,126 @@ func (svc *ContractService) Abort(ctx context.Context, db *gorm.DB, dist *Distri
 
 	logger.Info("Abort")
 
-	// Make sure the distribution is in correct state
-	if err := dist.SetInvalid(); err != nil {
-		return err // rollback
+	if dist.State == common.DistributionStateRefunding {
+		return fmt.Errorf("distribution is already being aborted")
 	}
 
-	// Update the distribution in database
-	if err := UpdateDistribution(db, dist); err != nil {
+	// Stop any settle, mint, reveal or open transactions which have not been sent yet
+	cancelled, err := transactions.CancelPending(db, dist.ID)
+	if err != nil {
 		return err // rollback
 	}
 
-	// Update distribution state onchain
+	logger.WithFields(log.Fields{"cancelledCount": cancelled}).Info("Pending transactions cancelled")
 
-	txScript, err := flow_helpers.ParseCadenceTemplate(UPDATE_STATE_SCRIPT, nil)
-	if err != nil {
-		return err // rollback
+	if !dist.MayHoldEscrow() {
+		return svc.invalidate(db, dist, logger)
 	}
 
-	arguments := []cadence.Value{
-		cadence.UInt64(dist.FlowID.Int64),
-		cadence.UInt8(1),
-	}
+	// Collectibles may have been moved to escrow, they are returned to the
+	// issuer before the distribution is set to 'invalid'
 
-	t, err := transactions.NewTransactionWithDistributionID(UPDATE_STATE_SCRIPT, txScript, arguments, dist.ID)
-	if err != nil {
+	// Make sure the distribution is in correct state
+	if err := dist.SetRefunding(); err != nil {
 		return err // rollback
 	}
 
-	if err := t.Save(db); err != nil {
+	// Update the distribution in database
+	if err := UpdateDistribution(db, dist); err != nil {
 		return err // rollback
 	}
 
-	logger.WithFields(log.Fields{
-		"state":    1,
-		"stateStr": "invalid",
-	}).Info("Distribution state update transaction saved")
-
-	return nil // commit
+	return svc.updateOnchainState(db, dist, logger)
 }
 
-// UpdateSettlementStatus polls for 'Deposit' events regarding the given distributions
-// collectible NFTs.
-// It updates the settelement status in database accordingly.
-func (svc *ContractService) UpdateSettlementStatus(ctx context.Context, db *gorm.DB, dist *Distribution) error {
+// StartRefund starts returning the escrowed collectibles of an aborted
+// distribution to its issuer. It waits until none of the distributions
+// transactions are in flight and then checks which of the collectibles
+// the escrow holds.
+// It then creates and stores the refund Flow transactions in database in
+// batches of 'SETTLEMENT_BATCH_SIZE' to be later processed by a poller.
+func (svc *ContractService) StartRefund(ctx context.Context, db *gorm.DB, dist *Distribution) error {
 	logger := log.WithFields(log.Fields{
-		"method":     "UpdateSettlementStatus",
+		"method":     "StartRefund",
 		"distID":     dist.ID,
 		"distFlowID": dist.FlowID,
 	})
 
-	logger.Trace("Update settlement status")
-
-	settlement, err := GetDistributionSettlement(db, dist.ID)
-	if err != nil {
-		return err // rollback
-	}
-
-	latestBlockHeader, err := svc.flowClient.GetLatestBlockHeader(ctx, true)
+	txStates, err := transactions.CountByState(db, dist.ID)
 	if err != nil {
 		return err // rollback
 	}
 
-	begin := settlement.StartAtBlock + 1
-	end := min(latestBlockHeader.Height, begin+svc.cfg.MaxBlocksPerCheck)
-
-	logger = logger.WithFields(log.Fields{
-		"blockBegin": begin,
-		"blockEnd":   end,
-	})
+	inFlight := txStates[common.TransactionStateInit] +
+		txStates[common.TransactionStateRetry] +
+		txStates[common.TransactionStateSent]
 
-	if begin > end {
-		// Nothing to update
-		logger.Trace("No blocks to handle")
+	if inFlight > 0 {
+		logger.WithFields(log.Fields{"inFlightCount": inFlight}).Trace("Waiting for transactions in flight")
 		return nil // commit
 	}
 
-	err = NotSettledCollectiblesInBatches(db, settlement.ID, svc.cfg.BatchProcessSize, func(tx *gorm.DB, batchNumber int, batch SettlementCollectibles) error {
-		for contract, collectibles := range batch.GroupByContract() {
-			arr, err := svc.flowClient.GetEventsForHeightRange(ctx, client.EventRangeQuery{
-				Type:        fmt.Sprintf("%s.Deposit", contract.String()),
-				StartHeight: begin,
-				EndHeight:   end,
-			})
-			if err != nil {
-				return err
-			}
-
-			for _, be := range arr {
-				for _, e := range be.Events {
-					eventLogger := logger.WithFields(log.Fields{"eventType": e.Type, "eventID": e.ID()})
+	logger.Info("Start refund")
 
-					eventLogger.Trace("Handling event")
+	latestBlockHeader, err := svc.flowClient.GetLatestBlockHeader(ctx, true)
+	if err != nil {
+		return err // rollback
+	}
 
-					evtValueMap := flow_helpers.EventValuesToMap(e)
+	refund := Refund{
+		DistributionID: dist.ID,
+		CurrentCount:   0,
+		TotalCount:     0, // Update later
+		StartAtBlock:   latestBlockHeader.Height - 1,
+		IssuerAddress:  dist.Issuer,
+	}
 
-					collectibleFlowIDCadence, ok := evtValueMap["id"]
-					if !ok {
-						err := fmt.Errorf("could not read 'id' from event %s", e)
-						return err
-					}
+	if err := InsertRefund(db, &refund); err != nil {
+		return err // rollback
+	}
 
-					collectibleFlowID, err := common.FlowIDFromCadence(collectibleFlowIDCadence)
+	escrowAddress := common.FlowAddressFromString(svc.cfg.AdminAddress)
+	totalCollectibleCount := 0
 
-					if err != nil {
-						return err
-					}
+	err = DistributionPacksInBatches(db, dist.ID, svc.cfg.BatchProcessSize, func(tx *gorm.DB, batchNumber int, batch []Pack) error {
+		collectibles := make(Collectibles, 0)
+		for _, pack := range batch {
+			collectibles = append(collectibles, pack.Collectibles...)
+		}
 
-					addressCadence, ok := evtValueMap["to"]
-					if !ok {
-						err := fmt.Errorf("could not read 'to' from event %s", e)
-						return err
-					}
+		for _, contract := range collectibles.Contracts() {
+			contractCollectibles := collectibles.ByContract(contract)
 
-					address, err := common.FlowAddressFromCadence(addressCadence)
-					if err != nil {
-						return err
-					}
+			missing, err := svc.missingCollectibles(ctx, escrowAddress, contractCollectibles)
+			if err != nil {
+				return err
+			}
 
-					if address == settlement.EscrowAddress {
-						if i, ok := collectibles.ContainsID(collectibleFlowID); ok {
-							// Collectible in "collectibles" at index "i"
+			notEscrowed := make(map[common.FlowID]bool, len(missing))
+			for _, c := range missing {
+				notEscrowed[c.FlowID] = true
+			}
 
-							// Make sure the collectible is in correct state
-							if err := collectibles[i].SetSettled(); err != nil {
-								return err
-							}
+			refundCollectibles := make([]RefundCollectible, 0, len(contractCollectibles)-len(missing))
+			for _, c := range contractCollectibles {
+				if !notEscrowed[c.FlowID] {
+					refundCollectibles = append(refundCollectibles, RefundCollectible{
+						RefundID:          refund.ID,
+						FlowID:            c.FlowID,
+						ContractReference: c.ContractReference,
+						IsRefunded:        false,
+					})
+				}
+			}
 
-							// Update the collectible in database
-							if err := UpdateSettlementCollectible(db, &collectibles[i]); err != nil {
-								return err
-							}
+			if len(refundCollectibles) == 0 {
+				continue
+			}
 
-							settlement.IncrementCount()
-						}
-					}
+			totalCollectibleCount += len(refundCollectibles)
 
-					eventLogger.Trace("Handling event complete")
-				}
+			if err := InsertRefundCollectibles(db, refundCollectibles, svc.cfg.BatchInsertSize); err != nil {
+				return err
 			}
 		}
 
@@ -636,47 +1088,87 @@ func (svc *ContractService) UpdateSettlementStatus(ctx context.Context, db *gorm
 		return err // rollback
 	}
 
-	if settlement.IsComplete() {
-		// TODO: consider updating the distribution separately
+	refund.TotalCount = uint(totalCollectibleCount)
 
-		// Make sure the distribution is in correct state
-		if err := dist.SetSettled(); err != nil {
-			return err // rollback
-		}
+	if err := UpdateRefund(db, &refund); err != nil {
+		return err // rollback
+	}
 
-		// Update the distribution in database
-		if err := UpdateDistribution(db, dist); err != nil {
-			return err // rollback
-		}
+	logger.WithFields(log.Fields{"refundCount": totalCollectibleCount}).Info("Escrowed collectibles checked")
 
-		logger.Info("Settlement complete")
+	if refund.IsComplete() {
+		// Nothing to refund
+		return svc.completeRefund(db, dist, logger)
 	}
 
-	settlement.StartAtBlock = end
+	err = NotRefundedCollectiblesInBatches(db, refund.ID, svc.cfg.SettlementBatchSize, func(tx *gorm.DB, batchNumber int, batch RefundCollectibles) error {
+		for contract, collectibles := range batch.GroupByContract() {
+			txScript, err := flow_helpers.ParseCadenceTemplate(
+				REFUND_SCRIPT,
+				&flow_helpers.CadenceTemplateVars{
+					CollectibleNFTName:    contract.Name,
+					CollectibleNFTAddress: contract.Address.String(),
+				},
+			)
+			if err != nil {
+				return err // rollback
+			}
 
-	// Update the settlement status in database
-	if err := UpdateSettlement(db, settlement); err != nil {
+			batchLogger := logger.WithFields(log.Fields{
+				"batchNumber": batchNumber,
+				"contract":    contract.String(),
+			})
+
+			batchLogger.Debug("Initiating refund transaction")
+
+			flowIDs := make([]cadence.Value, len(collectibles))
+			for i, c := range collectibles {
+				flowIDs[i] = cadence.UInt64(c.FlowID.Int64)
+			}
+
+			arguments := []cadence.Value{
+				cadence.UInt64(dist.FlowID.Int64),
+				cadence.NewArray(flowIDs),
+				cadence.Path{Domain: "private", Identifier: contract.ProviderPath()},
+			}
+
+			t, err := transactions.NewTransactionWithDistributionID(REFUND_SCRIPT, txScript, arguments, dist.ID)
+			if err != nil {
+				return err // rollback
+			}
+
+			if err := t.Save(db); err != nil {
+				return err // rollback
+			}
+
+			batchLogger.Trace("Refund transaction saved")
+		}
+
+		return nil
+	})
+
+	if err != nil {
 		return err // rollback
 	}
 
-	logger.Trace("Update settlement status complete")
+	logger.Trace("Start refund complete")
 
 	return nil // commit
 }
 
-// UpdateMintingStatus polls for 'Mint' events regarding the given distributions
-// Pack NFTs.
-// It updates the minting status in database accordingly.
-func (svc *ContractService) UpdateMintingStatus(ctx context.Context, db *gorm.DB, dist *Distribution) error {
+// UpdateRefundStatus polls for 'Deposit' events regarding the given distributions
+// escrowed collectible NFTs being returned to the issuer.
+// It updates the refund status in database accordingly.
+func (svc *ContractService) UpdateRefundStatus(ctx context.Context, db *gorm.DB, dist *Distribution) error {
 	logger := log.WithFields(log.Fields{
-		"method":     "UpdateMintingStatus",
+		"method":     "UpdateRefundStatus",
 		"distID":     dist.ID,
 		"distFlowID": dist.FlowID,
 	})
 
-	logger.Trace("Update minting status")
+	logger.Trace("Update refund status")
 
-	minting, err := GetDistributionMinting(db, dist.ID)
+	refund, err := GetDistributionRefund(db, dist.ID)
 	if err != nil {
 		return err // rollback
 	}
@@ -686,162 +1178,322 @@ func (svc *ContractService) UpdateMintingStatus(ctx context.Context, db *gorm.DB
 		return err // rollback
 	}
 
-	begin := minting.StartAtBlock + 1
-	end := min(latestBlockHeader.Height, begin+svc.cfg.MaxBlocksPerCheck)
-
-	logger = logger.WithFields(log.Fields{
-		"blockBegin": begin,
-		"blockEnd":   end,
-	})
-
-	if begin > end {
-		// Nothing to update
-		logger.Trace("No blocks to handle")
-		return nil // commit
-	}
-
-	reference := dist.PackTemplate.PackReference.String()
-
-	arr, err := svc.flowClient.GetEventsForHeightRange(ctx, client.EventRangeQuery{
-		Type:        fmt.Sprintf("%s.Mint", reference),
-		StartHeight: begin,
-		EndHeight:   end,
-	})
+	contracts, err := RefundContracts(db, refund.ID)
 	if err != nil {
 		return err // rollback
 	}
 
-	for _, be := range arr {
-		for _, e := range be.Events {
-			eventLogger := logger.WithFields(log.Fields{"eventType": e.Type, "eventID": e.ID()})
+	lastBlock := latestBlockHeader.Height
+
+	for _, contract := range contracts {
+		contract := contract
 
-			eventLogger.Trace("Handling event")
+		cursor, err := GetOrCreateEventCursor(db, refund.ID, contract, []string{DEPOSIT}, refund.StartAtBlock, svc.cfg.MaxBlocksPerCheck)
+		if err != nil {
+			return err // rollback
+		}
 
+		_, err = svc.ingestEvents(ctx, db, cursor, latestBlockHeader.Height, logger, func(db *gorm.DB, e flow.Event, eventName string) error {
 			evtValueMap := flow_helpers.EventValuesToMap(e)
 
-			packFlowIDCadence, ok := evtValueMap["id"]
+			collectibleFlowIDCadence, ok := evtValueMap["id"]
 			if !ok {
 				err := fmt.Errorf("could not read 'id' from event %s", e)
-				return err // rollback
+				return err
 			}
 
-			packFlowID, err := common.FlowIDFromCadence(packFlowIDCadence)
+			collectibleFlowID, err := common.FlowIDFromCadence(collectibleFlowIDCadence)
 			if err != nil {
-				return err // rollback
+				return err
 			}
 
-			commitmentHashCadence, ok := evtValueMap["commitHash"]
+			addressCadence, ok := evtValueMap["to"]
 			if !ok {
-				err := fmt.Errorf("could not read 'commitHash' from event %s", e)
-				return err // rollback
+				err := fmt.Errorf("could not read 'to' from event %s", e)
+				return err
 			}
 
-			commitmentHash, err := common.BinaryValueFromCadence(commitmentHashCadence)
+			address, err := common.FlowAddressFromCadence(addressCadence)
 			if err != nil {
-				return err // rollback
+				return err
+			}
+
+			if address != refund.IssuerAddress {
+				return nil
 			}
 
-			pack, err := GetMintingPack(db, commitmentHash)
+			collectible, err := GetNotRefundedCollectible(db, refund.ID, contract, collectibleFlowID)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				// Not a collectible of this refund or already refunded
+				return nil
+			}
 			if err != nil {
-				eventLogger.WithFields(log.Fields{
-					"packFlowID":     packFlowID,
-					"commitmentHash": commitmentHash,
-					"error":          err,
-				}).Warn("Error while handling event")
-				continue // ignore this commitmenthash, go to next event
+				return err
 			}
 
-			// Set the FlowID of the pack
-			// Make sure the pack is in correct state
-			if err := pack.Seal(packFlowID); err != nil {
-				return err // rollback
+			// Make sure the collectible is in correct state
+			if err := collectible.SetRefunded(); err != nil {
+				return err
 			}
 
-			// Update the pack in database
-			if err := UpdatePack(db, pack); err != nil {
-				return err // rollback
+			// Update the collectible in database
+			if err := UpdateRefundCollectible(db, collectible); err != nil {
+				return err
 			}
 
-			minting.IncrementCount()
+			refund.IncrementCount()
 
-			eventLogger.Trace("Handling event complete")
+			return nil
+		})
+		if err != nil {
+			return err // rollback
 		}
+
+		lastBlock = min(lastBlock, cursor.LastBlock)
 	}
 
-	if minting.IsComplete() {
-		// Distribution is now complete
+	// Events of every contract have been handled up to this block
+	refund.StartAtBlock = lastBlock
 
-		// TODO: consider updating the distribution separately
+	// Update the refund status in database
+	if err := UpdateRefund(db, refund); err != nil {
+		return err // rollback
+	}
 
-		// Make sure the distribution is in correct state
-		if err := dist.SetComplete(); err != nil {
-			return err // rollback
+	if refund.IsComplete() {
+		return svc.completeRefund(db, dist, logger)
+	}
+
+	logger.Trace("Update refund status complete")
+
+	return nil // commit
+}
+
+// completeRefund sets a refunded distribution to 'invalid'. The onchain
+// state has already been updated when the distribution was aborted.
+func (svc *ContractService) completeRefund(db *gorm.DB, dist *Distribution, logger *log.Entry) error {
+	// Make sure the distribution is in correct state
+	if err := dist.SetInvalid(); err != nil {
+		return err // rollback
+	}
+
+	// Update the distribution in database
+	if err := UpdateDistribution(db, dist); err != nil {
+		return err // rollback
+	}
+
+	logger.Info("Refund complete")
+
+	return nil // commit
+}
+
+// invalidate sets the given distributions state to 'invalid' and updates
+// the state onchain.
+func (svc *ContractService) invalidate(db *gorm.DB, dist *Distribution, logger *log.Entry) error {
+	// Make sure the distribution is in correct state
+	if err := dist.SetInvalid(); err != nil {
+		return err // rollback
+	}
+
+	// Update the distribution in database
+	if err := UpdateDistribution(db, dist); err != nil {
+		return err // rollback
+	}
+
+	return svc.updateOnchainState(db, dist, logger)
+}
+
+// updateOnchainState saves a transaction which sets the onchain state of the
+// given distribution to match its current offchain state.
+func (svc *ContractService) updateOnchainState(db *gorm.DB, dist *Distribution, logger *log.Entry) error {
+	state := dist.State.OnchainState()
+
+	txScript, err := flow_helpers.ParseCadenceTemplate(UPDATE_STATE_SCRIPT, nil)
+	if err != nil {
+		return err // rollback
+	}
+
+	arguments := []cadence.Value{
+		cadence.UInt64(dist.FlowID.Int64),
+		cadence.UInt8(state),
+	}
+
+	t, err := transactions.NewTransactionWithDistributionID(UPDATE_STATE_SCRIPT, txScript, arguments, dist.ID)
+	if err != nil {
+		return err // rollback
+	}
+
+	if err := t.Save(db); err != nil {
+		return err // rollback
+	}
+
+	logger.WithFields(log.Fields{
+		"state":    uint8(state),
+		"stateStr": state.String(),
+	}).Info("Distribution state update transaction saved")
+
+	return nil // commit
+}
+
+// ReconcileState compares the offchain state of a distribution to its onchain state.
+func (svc *ContractService) ReconcileState(ctx context.Context, db *gorm.DB, dist *Distribution) (*StateReconciliation, error) {
+	onchainState, err := svc.GetOnchainState(ctx, dist.FlowID)
+	if err != nil {
+		return nil, err
+	}
+
+	pending, err := transactions.CountPending(db, dist.ID, UPDATE_STATE_SCRIPT)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StateReconciliation{
+		DistributionID:       dist.ID,
+		DistFlowID:           dist.FlowID,
+		State:                dist.State,
+		ExpectedOnchainState: dist.State.OnchainState(),
+		OnchainState:         onchainState,
+		PendingUpdate:        pending > 0,
+	}, nil
+}
+
+// checkIssuerCollectibles checks that the issuer of the given distribution
+// owns all of its collectibles and that PDS can withdraw from the issuers
+// collections. Collectibles are checked in batches of 'SETTLEMENT_BATCH_SIZE'.
+// It returns the collectibles the issuer does not own and the contracts PDS
+// can not withdraw from.
+func (svc *ContractService) checkIssuerCollectibles(ctx context.Context, db *gorm.DB, dist *Distribution) (Collectibles, []AddressLocation, error) {
+	missing := Collectibles{}
+	unlinked := []AddressLocation{}
+	checked := make(map[AddressLocation]bool)
+
+	err := DistributionPacksInBatches(db, dist.ID, svc.cfg.BatchProcessSize, func(tx *gorm.DB, batchNumber int, batch []Pack) error {
+		collectibles := make(Collectibles, 0)
+		for _, pack := range batch {
+			collectibles = append(collectibles, pack.Collectibles...)
 		}
 
-		// Update the distribution in database
-		if err := UpdateDistribution(db, dist); err != nil {
-			return err // rollback
+		for _, contract := range collectibles.Contracts() {
+			if !checked[contract] {
+				checked[contract] = true
+
+				script, err := flow_helpers.ParseCadenceTemplate(CHECK_ISSUER_COLLECTION_SCRIPT, nil)
+				if err != nil {
+					return err
+				}
+
+				arguments := []cadence.Value{
+					cadence.UInt64(dist.FlowID.Int64),
+					cadence.String(contract.String()),
+				}
+
+				res, err := svc.flowClient.ExecuteScriptAtLatestBlock(ctx, script, arguments)
+				if err != nil {
+					return err
+				}
+
+				if linked, ok := res.(cadence.Bool); !ok || !bool(linked) {
+					unlinked = append(unlinked, contract)
+				}
+			}
+
+			contractMissing, err := svc.missingCollectibles(ctx, dist.Issuer, collectibles.ByContract(contract))
+			if err != nil {
+				return err
+			}
+
+			missing = append(missing, contractMissing...)
 		}
 
-		logger.Info("Minting complete")
+		return nil
+	})
 
-		// Update distribution state onchain
+	if err != nil {
+		return nil, nil, err
+	}
 
-		txScript, err := flow_helpers.ParseCadenceTemplate(UPDATE_STATE_SCRIPT, nil)
-		if err != nil {
-			return err // rollback
+	return missing, unlinked, nil
+}
+
+// missingCollectibles returns the given collectibles of a single contract
+// which the owner does not hold in its collection. Collectibles are checked
+// in batches of 'SETTLEMENT_BATCH_SIZE'.
+func (svc *ContractService) missingCollectibles(ctx context.Context, owner common.FlowAddress, collectibles Collectibles) (Collectibles, error) {
+	missing := Collectibles{}
+
+	if len(collectibles) == 0 {
+		return missing, nil
+	}
+
+	contract := collectibles[0].ContractReference
+
+	script, err := flow_helpers.ParseCadenceTemplate(
+		MISSING_ISSUER_COLLECTIBLES_SCRIPT,
+		&flow_helpers.CadenceTemplateVars{
+			CollectibleNFTName:    contract.Name,
+			CollectibleNFTAddress: contract.Address.String(),
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	for start := 0; start < len(collectibles); start += svc.cfg.SettlementBatchSize {
+		end := start + svc.cfg.SettlementBatchSize
+		if end > len(collectibles) {
+			end = len(collectibles)
+		}
+
+		flowIDs := make([]cadence.Value, end-start)
+		for i, c := range collectibles[start:end] {
+			flowIDs[i] = cadence.UInt64(c.FlowID.Int64)
 		}
 
 		arguments := []cadence.Value{
-			cadence.UInt64(dist.FlowID.Int64),
-			cadence.UInt8(2),
+			cadence.Address(owner),
+			cadence.NewArray(flowIDs),
 		}
 
-		t, err := transactions.NewTransactionWithDistributionID(UPDATE_STATE_SCRIPT, txScript, arguments, dist.ID)
+		res, err := svc.flowClient.ExecuteScriptAtLatestBlock(ctx, script, arguments)
 		if err != nil {
-			return err // rollback
+			return nil, err
 		}
 
-		if err := t.Save(db); err != nil {
-			return err // rollback
+		ids, ok := res.(cadence.Array)
+		if !ok {
+			return nil, fmt.Errorf("unexpected result from missing collectibles script: %v", res)
 		}
 
-		logger.WithFields(log.Fields{
-			"state":    2,
-			"stateStr": "complete",
-		}).Info("Distribution state update transaction saved")
-	}
-
-	minting.StartAtBlock = end
-
-	// Update the minting status in database
-	if err := UpdateMinting(db, minting); err != nil {
-		return err // rollback
+		for _, id := range ids.Values {
+			flowID, err := common.FlowIDFromCadence(id)
+			if err != nil {
+				return nil, err
+			}
+			missing = append(missing, Collectible{
+				FlowID:            flowID,
+				ContractReference: contract,
+			})
+		}
 	}
 
-	logger.Trace("Update minting status complete")
-
-	return nil // commit
+	return missing, nil
 }
 
-// UpdateCirculatingPackContract polls for 'REVEAL_REQUEST', 'REVEALED', 'OPEN_REQUEST' and 'OPENED' events
-// regarding the given CirculatingPackContract.
-// It handles each the 'REVEAL_REQUEST' and 'OPEN_REQUEST' events by creating
-// and storing an appropriate Flow transaction in database to be later processed by a poller.
-// 'REVEALED' and 'OPENED' events are used to sync the state of a pack in database with onchain state.
-func (svc *ContractService) UpdateCirculatingPackContract(ctx context.Context, db *gorm.DB, cpc *CirculatingPackContract) error {
+// UpdateSettlementStatus polls for 'Deposit' events regarding the given distributions
+// collectible NFTs.
+// It updates the settelement status in database accordingly.
+func (svc *ContractService) UpdateSettlementStatus(ctx context.Context, db *gorm.DB, dist *Distribution) error {
 	logger := log.WithFields(log.Fields{
-		"method": "UpdateCirculatingPack",
-		"cpcID":  cpc.ID,
+		"method":     "UpdateSettlementStatus",
+		"distID":     dist.ID,
+		"distFlowID": dist.FlowID,
 	})
 
-	logger.Trace("Update circulating pack")
+	logger.Trace("Update settlement status")
 
-	eventNames := []string{
-		REVEAL_REQUEST,
-		REVEALED,
-		OPEN_REQUEST,
-		OPENED,
+	settlement, err := GetDistributionSettlement(db, dist.ID)
+	if err != nil {
+		return err // rollback
 	}
 
 	latestBlockHeader, err := svc.flowClient.GetLatestBlockHeader(ctx, true)
@@ -849,267 +1501,1261 @@ func (svc *ContractService) UpdateCirculatingPackContract(ctx context.Context, d
 		return err // rollback
 	}
 
-	begin := cpc.StartAtBlock + 1
-	end := min(latestBlockHeader.Height, begin+svc.cfg.MaxBlocksPerCheck)
+	if svc.cfg.EscrowReconcileInterval > 0 && time.Since(settlement.ReconciledAt) >= svc.cfg.EscrowReconcileInterval {
+		healed, err := svc.reconcileEscrow(ctx, db, settlement, latestBlockHeader.Height)
+		if err != nil {
+			// Not fatal, event scanning continues
+			logger.WithFields(log.Fields{"error": err}).Warn("Error while reconciling escrow")
+		} else if healed > 0 {
+			logger.WithFields(log.Fields{"healedCount": healed}).Warn("Settled collectibles missed by event scanning found in escrow")
+		}
 
-	logger = logger.WithFields(log.Fields{
-		"blockBegin": begin,
-		"blockEnd":   end,
-	})
+		settlement.ReconciledAt = time.Now()
 
-	if begin > end {
-		logger.Trace("No blocks to handle")
-		return nil // commit
+		if settlement.IsComplete() {
+			return svc.completeSettlement(db, dist, settlement, logger)
+		}
+
+		// Update the settlement status in database
+		if err := UpdateSettlement(db, settlement); err != nil {
+			return err // rollback
+		}
 	}
 
-	contractRef := AddressLocation{Name: cpc.Name, Address: cpc.Address}
+	contracts, err := SettlementContracts(db, settlement.ID)
+	if err != nil {
+		return err // rollback
+	}
 
-	for _, eventName := range eventNames {
-		arr, err := svc.flowClient.GetEventsForHeightRange(ctx, client.EventRangeQuery{
-			Type:        cpc.EventName(eventName),
-			StartHeight: begin,
-			EndHeight:   end,
-		})
+	caughtUp := true
+	lastBlock := latestBlockHeader.Height
+
+	for _, contract := range contracts {
+		contract := contract
+
+		cursor, err := GetOrCreateEventCursor(db, settlement.ID, contract, []string{DEPOSIT}, settlement.StartAtBlock, svc.cfg.MaxBlocksPerCheck)
 		if err != nil {
 			return err // rollback
 		}
 
-		for _, be := range arr {
-			for _, e := range be.Events {
-				eventLogger := logger.WithFields(log.Fields{"eventType": e.Type, "eventID": e.ID()})
-
-				eventLogger.Debug("Handling event")
+		contractCaughtUp, err := svc.ingestEvents(ctx, db, cursor, latestBlockHeader.Height, logger, func(db *gorm.DB, e flow.Event, eventName string) error {
+			evtValueMap := flow_helpers.EventValuesToMap(e)
 
-				evtValueMap := flow_helpers.EventValuesToMap(e)
+			collectibleFlowIDCadence, ok := evtValueMap["id"]
+			if !ok {
+				err := fmt.Errorf("could not read 'id' from event %s", e)
+				return err
+			}
 
-				packFlowIDCadence, ok := evtValueMap["id"]
-				if !ok {
-					err := fmt.Errorf("could not read 'id' from event %s", e)
-					return err // rollback
-				}
+			collectibleFlowID, err := common.FlowIDFromCadence(collectibleFlowIDCadence)
+			if err != nil {
+				return err
+			}
 
-				packFlowID, err := common.FlowIDFromCadence(packFlowIDCadence)
-				if err != nil {
-					return err // rollback
-				}
+			addressCadence, ok := evtValueMap["to"]
+			if !ok {
+				err := fmt.Errorf("could not read 'to' from event %s", e)
+				return err
+			}
 
-				pack, err := GetPackByContractAndFlowID(db, contractRef, packFlowID)
-				if err != nil {
-					return err // rollback
-				}
+			address, err := common.FlowAddressFromCadence(addressCadence)
+			if err != nil {
+				return err
+			}
 
-				distribution, err := GetDistributionSmall(db, pack.DistributionID)
-				if err != nil {
-					return err // rollback
-				}
+			if address != settlement.EscrowAddress {
+				return nil
+			}
 
-				eventLogger = eventLogger.WithFields(log.Fields{
-					"distID":     distribution.ID,
-					"distFlowID": distribution.FlowID,
-					"packID":     pack.ID,
-					"packFlowID": pack.FlowID,
-				})
+			collectible, err := GetNotSettledCollectible(db, settlement.ID, contract, collectibleFlowID)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				// Not a collectible of this settlement or already settled
+				return nil
+			}
+			if err != nil {
+				return err
+			}
 
-				switch eventName {
-				// -- REVEAL_REQUEST, Owner has requested to reveal a pack ------------
-				case REVEAL_REQUEST:
-
-					// Make sure the pack is in correct state
-					if err := pack.RevealRequestHandled(); err != nil {
-						err := fmt.Errorf("error while handling %s: %w", eventName, err)
-						return err // rollback
-					}
-
-					// Update the pack in database
-					if err := UpdatePack(db, pack); err != nil {
-						return err // rollback
-					}
-
-					// Get the owner of the pack from the transaction that emitted the open request event
-					tx, err := svc.flowClient.GetTransaction(ctx, e.TransactionID)
-					if err != nil {
-						return err // rollback
-					}
-					owner := tx.Authorizers[0]
-
-					// NOTE: this only handles one collectible contract per pack
-					contract := pack.Collectibles[0].ContractReference
-
-					collectibleCount := len(pack.Collectibles)
-					collectibleContractAddresses := make([]cadence.Value, collectibleCount)
-					collectibleContractNames := make([]cadence.Value, collectibleCount)
-					collectibleIDs := make([]cadence.Value, collectibleCount)
-
-					for i, c := range pack.Collectibles {
-						collectibleContractAddresses[i] = cadence.Address(c.ContractReference.Address)
-						collectibleContractNames[i] = cadence.String(c.ContractReference.Name)
-						collectibleIDs[i] = cadence.UInt64(c.FlowID.Int64)
-					}
-
-					openRequestValue, ok := evtValueMap["openRequest"]
-					if !ok { // TODO(nanuuki): rollback or use a default value for openRequest?
-						err := fmt.Errorf("could not read 'openRequest' from event %s", e)
-						return err // rollback
-					}
-
-					openRequest := openRequestValue.ToGoValue().(bool)
-					eventLogger = eventLogger.WithFields(log.Fields{"openRequest": openRequest})
-
-					arguments := []cadence.Value{
-						cadence.UInt64(distribution.FlowID.Int64),
-						cadence.UInt64(pack.FlowID.Int64),
-						cadence.NewArray(collectibleContractAddresses),
-						cadence.NewArray(collectibleContractNames),
-						cadence.NewArray(collectibleIDs),
-						cadence.String(pack.Salt.String()),
-						cadence.Address(owner),
-						cadence.NewBool(openRequest),
-						cadence.Path{Domain: "private", Identifier: contract.ProviderPath()},
-					}
-
-					// NOTE: this only handles one collectible contract per pack
-					txScript, err := flow_helpers.ParseCadenceTemplate(
-						REVEAL_SCRIPT,
-						&flow_helpers.CadenceTemplateVars{
-							PackNFTName:           pack.ContractReference.Name,
-							PackNFTAddress:        pack.ContractReference.Address.String(),
-							CollectibleNFTName:    contract.Name,
-							CollectibleNFTAddress: contract.Address.String(),
-						},
-					)
-					if err != nil {
-						return err // rollback
-					}
-
-					t, err := transactions.NewTransactionWithDistributionID(REVEAL_SCRIPT, txScript, arguments, distribution.ID)
-					if err != nil {
-						return err // rollback
-					}
-
-					if err := t.Save(db); err != nil {
-						return err // rollback
-					}
-
-					if openRequest { // NOTE: This block should run only if we want to reveal AND open the pack
-						// Reset the ID to save a second indentical transaction
-						t.ID = uuid.Nil
-						if err := t.Save(db); err != nil {
-							return err // rollback
-						}
-					}
-
-					eventLogger.Info("Pack reveal transaction created")
-
-				// -- REVEALED, Pack has been revealed onchain ------------------------
-				case REVEALED:
-
-					// Make sure the pack is in correct state
-					if err := pack.Reveal(); err != nil {
-						err := fmt.Errorf("error while handling %s: %w", eventName, err)
-						return err // rollback
-					}
-
-					// Update the pack in database
-					if err := UpdatePack(db, pack); err != nil {
-						return err // rollback
-					}
-
-				// -- OPEN_REQUEST, Owner has requested to open a pack ----------------
-				case OPEN_REQUEST:
-
-					// Make sure the pack is in correct state
-					if err := pack.OpenRequestHandled(); err != nil {
-						err := fmt.Errorf("error while handling %s: %w", eventName, err)
-						return err // rollback
-					}
-
-					// Update the pack in database
-					if err := UpdatePack(db, pack); err != nil {
-						return err // rollback
-					}
-
-					// Get the owner of the pack from the transaction that emitted the open request event
-					tx, err := svc.flowClient.GetTransaction(ctx, e.TransactionID)
-					if err != nil {
-						return err // rollback
-					}
-					owner := tx.Authorizers[0]
-
-					// NOTE: this only handles one collectible contract per pack
-					contract := pack.Collectibles[0].ContractReference
-
-					collectibleCount := len(pack.Collectibles)
-
-					collectibleContractAddresses := make([]cadence.Value, collectibleCount)
-					collectibleContractNames := make([]cadence.Value, collectibleCount)
-					collectibleIDs := make([]cadence.Value, collectibleCount)
-
-					for i, c := range pack.Collectibles {
-						collectibleContractAddresses[i] = cadence.Address(c.ContractReference.Address)
-						collectibleContractNames[i] = cadence.String(c.ContractReference.Name)
-						collectibleIDs[i] = cadence.UInt64(c.FlowID.Int64)
-					}
-
-					arguments := []cadence.Value{
-						cadence.UInt64(distribution.FlowID.Int64),
-						cadence.UInt64(pack.FlowID.Int64),
-						cadence.NewArray(collectibleContractAddresses),
-						cadence.NewArray(collectibleContractNames),
-						cadence.NewArray(collectibleIDs),
-						cadence.Address(owner),
-						cadence.Path{Domain: "private", Identifier: contract.ProviderPath()},
-					}
-
-					txScript, err := flow_helpers.ParseCadenceTemplate(
-						OPEN_SCRIPT,
-						&flow_helpers.CadenceTemplateVars{
-							CollectibleNFTName:    contract.Name,
-							CollectibleNFTAddress: contract.Address.String(),
-						},
-					)
-					if err != nil {
-						return err // rollback
-					}
-
-					t, err := transactions.NewTransactionWithDistributionID(OPEN_SCRIPT, txScript, arguments, distribution.ID)
-					if err != nil {
-						return err // rollback
-					}
-
-					if err := t.Save(db); err != nil {
-						return err // rollback
-					}
-
-					eventLogger.Info("Pack open transaction created")
-
-				// -- OPENED, Pack has been opened onchain ----------------------------
-				case OPENED:
-
-					// Make sure the pack is in correct state
-					if err := pack.Open(); err != nil {
-						err := fmt.Errorf("error while handling %s: %w", eventName, err)
-						return err // rollback
-					}
-
-					// Update the pack in database
-					if err := UpdatePack(db, pack); err != nil {
-						return err // rollback
-					}
-				}
+			// Make sure the collectible is in correct state
+			if err := collectible.SetSettled(); err != nil {
+				return err
+			}
 
-				eventLogger.Trace("Handling event complete")
+			// Update the collectible in database
+			if err := UpdateSettlementCollectible(db, collectible); err != nil {
+				return err
 			}
+
+			settlement.IncrementCount()
+
+			return nil
+		})
+		if err != nil {
+			return err // rollback
 		}
+
+		caughtUp = caughtUp && contractCaughtUp
+		lastBlock = min(lastBlock, cursor.LastBlock)
 	}
 
-	cpc.StartAtBlock = end
+	// Events of every contract have been handled up to this block
+	settlement.StartAtBlock = lastBlock
 
-	// Update the CirculatingPackContract in database
-	if err := UpdateCirculatingPackContract(db, cpc); err != nil {
+	if settlement.IsComplete() {
+		return svc.completeSettlement(db, dist, settlement, logger)
+	}
+
+	// Update the settlement status in database
+	if err := UpdateSettlement(db, settlement); err != nil {
 		return err // rollback
 	}
 
-	logger.Trace("Update circulating pack complete")
+	if caughtUp {
+		// All events up to the latest block have been handled
+		return svc.checkSettlementDeadline(db, dist, settlement, logger)
+	}
+
+	logger.Trace("Update settlement status complete")
 
 	return nil // commit
 }
+
+// RetrySettlement re-queues settle transactions for the collectibles of a
+// settling or stalled distribution which have not been settled yet. Collectibles
+// already held by the escrow are marked settled instead. It refuses to run
+// while settle transactions for the same collectibles are still pending.
+// It returns the amount of collectibles queued.
+func (svc *ContractService) RetrySettlement(ctx context.Context, db *gorm.DB, dist *Distribution) (int, error) {
+	logger := log.WithFields(log.Fields{
+		"method":     "RetrySettlement",
+		"distID":     dist.ID,
+		"distFlowID": dist.FlowID,
+	})
+
+	if dist.State != common.DistributionStateSettling && dist.State != common.DistributionStateStalled {
+		return 0, fmt.Errorf("settlement can not be retried in state '%s'", dist.State)
+	}
+
+	if _, err := GetDistributionMinting(db, dist.ID); err == nil {
+		return 0, fmt.Errorf("distribution has already been settled")
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, err // rollback
+	}
+
+	settlement, err := GetDistributionSettlement(db, dist.ID)
+	if err != nil {
+		return 0, err // rollback
+	}
+
+	notSettled := make(map[Collectible]*SettlementCollectible)
+	err = NotSettledCollectiblesInBatches(db, settlement.ID, svc.cfg.BatchProcessSize, func(tx *gorm.DB, batchNumber int, batch SettlementCollectibles) error {
+		for i := range batch {
+			c := batch[i]
+			notSettled[Collectible{ContractReference: c.ContractReference, FlowID: c.FlowID}] = &c
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err // rollback
+	}
+
+	pending, err := transactions.ListPending(db, dist.ID, SETTLE_SCRIPT)
+	if err != nil {
+		return 0, err // rollback
+	}
+
+	inFlight := 0
+	for _, t := range pending {
+		collectibles, err := settleTransactionCollectibles(&t)
+		if err != nil {
+			return 0, err // rollback
+		}
+		for _, c := range collectibles {
+			if _, ok := notSettled[c]; ok {
+				inFlight++
+			}
+		}
+	}
+
+	if inFlight > 0 {
+		return 0, fmt.Errorf("%w: %d collectibles", ErrSettlementInFlight, inFlight)
+	}
+
+	// Events of sealed settle transactions may not have been handled yet,
+	// check which collectibles the escrow already holds
+	byContract := make(map[AddressLocation]Collectibles)
+	for c := range notSettled {
+		byContract[c.ContractReference] = append(byContract[c.ContractReference], c)
+	}
+
+	settledCount := 0
+	for contract, collectibles := range byContract {
+		missing, err := svc.missingCollectibles(ctx, settlement.EscrowAddress, collectibles)
+		if err != nil {
+			return 0, err // rollback
+		}
+
+		notEscrowed := make(map[common.FlowID]bool, len(missing))
+		for _, c := range missing {
+			notEscrowed[c.FlowID] = true
+		}
+
+		for _, c := range collectibles {
+			if notEscrowed[c.FlowID] {
+				continue
+			}
+
+			sc := notSettled[Collectible{ContractReference: contract, FlowID: c.FlowID}]
+
+			// Make sure the collectible is in correct state
+			if err := sc.SetSettled(); err != nil {
+				return 0, err // rollback
+			}
+
+			// Update the collectible in database
+			if err := UpdateSettlementCollectible(db, sc); err != nil {
+				return 0, err // rollback
+			}
+
+			settlement.IncrementCount()
+			settledCount++
+		}
+	}
+
+	if dist.State == common.DistributionStateStalled {
+		if err := dist.SetState(common.DistributionStateSettling, common.DistributionStateStalled); err != nil {
+			return 0, err // rollback
+		}
+		dist.StalledReason = ""
+	}
+
+	// Manual retry starts over with a fresh deadline and retries
+	settlement.PhaseDeadline = NewPhaseDeadline(time.Now(), svc.cfg.SettlementTimeout)
+
+	queuedCount := len(notSettled) - settledCount
+
+	if settlement.IsComplete() {
+		if err := dist.SetSettled(); err != nil {
+			return 0, err // rollback
+		}
+		logger.Info("Settlement complete")
+	} else {
+		// The failed transactions are replaced by the re-queued batches
+		if _, err := transactions.CancelFailed(db, dist.ID, SETTLE_SCRIPT); err != nil {
+			return 0, err // rollback
+		}
+
+		if err := svc.queueSettleTransactions(db, dist, settlement, logger); err != nil {
+			return 0, err // rollback
+		}
+	}
+
+	if err := UpdateSettlement(db, settlement); err != nil {
+		return 0, err // rollback
+	}
+
+	if err := UpdateDistribution(db, dist); err != nil {
+		return 0, err // rollback
+	}
+
+	logger.WithFields(log.Fields{
+		"queuedCount":  queuedCount,
+		"settledCount": settledCount,
+	}).Info("Settlement retried")
+
+	return queuedCount, nil // commit
+}
+
+// settleTransactionCollectibles returns the collectibles a settle transaction withdraws.
+func settleTransactionCollectibles(t *transactions.StorableTransaction) (Collectibles, error) {
+	arguments, err := t.ArgumentsAsCadence()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(arguments) != 3 {
+		return nil, fmt.Errorf("unexpected arguments for settle transaction %s", t.ID)
+	}
+
+	contractValue, ok := arguments[1].(cadence.String)
+	if !ok {
+		return nil, fmt.Errorf("unexpected collection argument for settle transaction %s", t.ID)
+	}
+
+	contract, err := AddressLocationFromString(string(contractValue))
+	if err != nil {
+		return nil, err
+	}
+
+	ids, ok := arguments[2].(cadence.Array)
+	if !ok {
+		return nil, fmt.Errorf("unexpected ID argument for settle transaction %s", t.ID)
+	}
+
+	collectibles := make(Collectibles, len(ids.Values))
+	for i, id := range ids.Values {
+		flowID, err := common.FlowIDFromCadence(id)
+		if err != nil {
+			return nil, err
+		}
+		collectibles[i] = Collectible{ContractReference: contract, FlowID: flowID}
+	}
+
+	return collectibles, nil
+}
+
+// completeSettlement sets a distribution whose collectibles have all been
+// settled to 'settled' and saves the settlement status.
+func (svc *ContractService) completeSettlement(db *gorm.DB, dist *Distribution, settlement *Settlement, logger *log.Entry) error {
+	// TODO: consider updating the distribution separately
+
+	// Make sure the distribution is in correct state
+	if err := dist.SetSettled(); err != nil {
+		return err // rollback
+	}
+
+	// Update the distribution in database
+	if err := UpdateDistribution(db, dist); err != nil {
+		return err // rollback
+	}
+
+	// Update the settlement status in database
+	if err := UpdateSettlement(db, settlement); err != nil {
+		return err // rollback
+	}
+
+	logger.Info("Settlement complete")
+
+	return nil // commit
+}
+
+// reconcileEscrow reads the IDs held by the escrow collections at the given
+// block height and marks the matching collectibles of a settlement settled.
+// This heals gaps left by Deposit events which were missed while scanning.
+// It returns the amount of collectibles marked settled.
+func (svc *ContractService) reconcileEscrow(ctx context.Context, db *gorm.DB, settlement *Settlement, height uint64) (int, error) {
+	held := make(map[AddressLocation]map[common.FlowID]bool)
+	healed := 0
+
+	err := NotSettledCollectiblesInBatches(db, settlement.ID, svc.cfg.BatchProcessSize, func(tx *gorm.DB, batchNumber int, batch SettlementCollectibles) error {
+		for contract, collectibles := range batch.GroupByContract() {
+			ids, ok := held[contract]
+			if !ok {
+				var err error
+				ids, err = svc.collectionIDs(ctx, settlement.EscrowAddress, contract, height)
+				if err != nil {
+					return err
+				}
+				held[contract] = ids
+			}
+
+			for i := range collectibles {
+				if !ids[collectibles[i].FlowID] {
+					continue
+				}
+
+				// Make sure the collectible is in correct state
+				if err := collectibles[i].SetSettled(); err != nil {
+					return err
+				}
+
+				// Update the collectible in database
+				if err := UpdateSettlementCollectible(db, &collectibles[i]); err != nil {
+					return err
+				}
+
+				settlement.IncrementCount()
+				healed++
+			}
+		}
+
+		return nil
+	})
+
+	return healed, err
+}
+
+// collectionIDs reads the IDs in the collection of a contract owned by an
+// account at the given block height, in pages of 'BATCH_PROCESS_SIZE'.
+func (svc *ContractService) collectionIDs(ctx context.Context, owner common.FlowAddress, contract AddressLocation, height uint64) (map[common.FlowID]bool, error) {
+	script, err := flow_helpers.ParseCadenceTemplate(
+		COLLECTIBLE_BALANCE_IDS_SCRIPT,
+		&flow_helpers.CadenceTemplateVars{
+			CollectibleNFTName:    contract.Name,
+			CollectibleNFTAddress: contract.Address.String(),
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(map[common.FlowID]bool)
+	limit := svc.cfg.BatchProcessSize
+
+	for offset := 0; ; offset += limit {
+		arguments := []cadence.Value{
+			cadence.Address(owner),
+			cadence.UInt64(offset),
+			cadence.UInt64(limit),
+		}
+
+		res, err := svc.flowClient.ExecuteScriptAtBlockHeight(ctx, height, script, arguments)
+		if err != nil {
+			return nil, err
+		}
+
+		page, ok := res.(cadence.Array)
+		if !ok {
+			return nil, fmt.Errorf("unexpected result from balance IDs script: %v", res)
+		}
+
+		for _, v := range page.Values {
+			id, err := common.FlowIDFromCadence(v)
+			if err != nil {
+				return nil, err
+			}
+			ids[id] = true
+		}
+
+		if len(page.Values) < limit {
+			return ids, nil
+		}
+	}
+}
+
+// checkSettlementDeadline re-queues settle transactions for the collectibles
+// which have not been settled if any settle transactions have failed or the
+// settlement deadline has passed. Once retries are exhausted the distribution
+// is set to 'stalled'. Should only be called when all events up to the latest
+// block have been handled, so collectibles are not settled twice.
+func (svc *ContractService) checkSettlementDeadline(db *gorm.DB, dist *Distribution, settlement *Settlement, logger *log.Entry) error {
+	pending, err := transactions.CountPending(db, dist.ID, SETTLE_SCRIPT)
+	if err != nil {
+		return err // rollback
+	}
+
+	failed, err := transactions.CountFailedSince(db, dist.ID, SETTLE_SCRIPT, settlement.QueuedAt)
+	if err != nil {
+		return err // rollback
+	}
+
+	now := time.Now()
+
+	logger = logger.WithFields(log.Fields{
+		"failedCount":  failed,
+		"retryCount":   settlement.RetryCount,
+		"settledCount": settlement.CurrentCount,
+		"totalCount":   settlement.TotalCount,
+	})
+
+	switch settlement.nextAction(now, failed, pending, svc.cfg.MaxPhaseRetries) {
+	case phaseWait:
+		return nil // commit
+	case phaseStall:
+		reason := fmt.Sprintf(
+			"settlement incomplete after %d retries, %d of %d collectibles settled",
+			settlement.RetryCount, settlement.CurrentCount, settlement.TotalCount,
+		)
+		return svc.stall(db, dist, reason, logger)
+	}
+
+	logger.Warn("Settlement incomplete, re-queueing missing batches")
+
+	// The failed transactions are replaced by the re-queued batches
+	if _, err := transactions.CancelFailed(db, dist.ID, SETTLE_SCRIPT); err != nil {
+		return err // rollback
+	}
+
+	settlement.Requeued(now, svc.cfg.SettlementTimeout)
+
+	if err := svc.queueSettleTransactions(db, dist, settlement, logger); err != nil {
+		return err // rollback
+	}
+
+	if err := UpdateSettlement(db, settlement); err != nil {
+		return err // rollback
+	}
+
+	return nil // commit
+}
+
+// UpdateMintingStatus polls for 'Mint' events regarding the given distributions
+// Pack NFTs.
+// It updates the minting status in database accordingly.
+func (svc *ContractService) UpdateMintingStatus(ctx context.Context, db *gorm.DB, dist *Distribution) error {
+	logger := log.WithFields(log.Fields{
+		"method":     "UpdateMintingStatus",
+		"distID":     dist.ID,
+		"distFlowID": dist.FlowID,
+	})
+
+	logger.Trace("Update minting status")
+
+	minting, err := GetDistributionMinting(db, dist.ID)
+	if err != nil {
+		return err // rollback
+	}
+
+	latestBlockHeader, err := svc.flowClient.GetLatestBlockHeader(ctx, true)
+	if err != nil {
+		return err // rollback
+	}
+
+	cursor, err := GetOrCreateEventCursor(db, minting.ID, dist.PackTemplate.PackReference, []string{MINT}, minting.StartAtBlock, svc.cfg.MaxBlocksPerCheck)
+	if err != nil {
+		return err // rollback
+	}
+
+	caughtUp, err := svc.ingestEvents(ctx, db, cursor, latestBlockHeader.Height, logger, func(db *gorm.DB, e flow.Event, eventName string) error {
+		eventLogger := logger.WithFields(log.Fields{"eventType": e.Type, "eventID": e.ID()})
+
+		evtValueMap := flow_helpers.EventValuesToMap(e)
+
+		packFlowIDCadence, ok := evtValueMap["id"]
+		if !ok {
+			err := fmt.Errorf("could not read 'id' from event %s", e)
+			return err
+		}
+
+		packFlowID, err := common.FlowIDFromCadence(packFlowIDCadence)
+		if err != nil {
+			return err
+		}
+
+		commitmentHashCadence, ok := evtValueMap["commitHash"]
+		if !ok {
+			err := fmt.Errorf("could not read 'commitHash' from event %s", e)
+			return err
+		}
+
+		commitmentHash, err := common.BinaryValueFromCadence(commitmentHashCadence)
+		if err != nil {
+			return err
+		}
+
+		distFlowIDCadence, ok := evtValueMap["distId"]
+		if !ok {
+			err := fmt.Errorf("could not read 'distId' from event %s", e)
+			return err
+		}
+
+		distFlowID, err := common.FlowIDFromCadence(distFlowIDCadence)
+		if err != nil {
+			return err
+		}
+
+		if !distFlowID.EqualTo(dist.FlowID) {
+			// Pack of another distribution using the same pack contract
+			eventLogger.Trace("Skipping event of another distribution")
+			return nil
+		}
+
+		pack, err := GetMintingPack(db, dist.ID, commitmentHash)
+		if err != nil {
+			eventLogger.WithFields(log.Fields{
+				"packFlowID":     packFlowID,
+				"commitmentHash": commitmentHash,
+				"error":          err,
+			}).Warn("Error while handling event")
+			return nil // ignore this commitmenthash, go to next event
+		}
+
+		// Set the FlowID of the pack
+		// Make sure the pack is in correct state
+		if err := pack.Seal(packFlowID); err != nil {
+			return err
+		}
+
+		// Update the pack in database
+		if err := UpdatePack(db, pack); err != nil {
+			return err
+		}
+
+		minting.IncrementCount()
+
+		return nil
+	})
+	if err != nil {
+		return err // rollback
+	}
+
+	packStates, err := CountDistributionPacksByState(db, dist.ID)
+	if err != nil {
+		return err // rollback
+	}
+
+	// All packs have been sealed once none are left in init state
+	if packStates[common.PackStateInit] == 0 {
+		// Distribution is now complete
+
+		// TODO: consider updating the distribution separately
+
+		// Make sure the distribution is in correct state
+		if err := dist.SetComplete(); err != nil {
+			return err // rollback
+		}
+
+		// Update the distribution in database
+		if err := UpdateDistribution(db, dist); err != nil {
+			return err // rollback
+		}
+
+		logger.Info("Minting complete")
+
+		// Update distribution state onchain
+		if err := svc.updateOnchainState(db, dist, logger); err != nil {
+			return err // rollback
+		}
+	}
+
+	minting.StartAtBlock = cursor.LastBlock
+
+	// Update the minting status in database
+	if err := UpdateMinting(db, minting); err != nil {
+		return err // rollback
+	}
+
+	if caughtUp && dist.State == common.DistributionStateMinting {
+		// All events up to the latest block have been handled
+		return svc.checkMintingDeadline(db, dist, minting, logger)
+	}
+
+	logger.Trace("Update minting status complete")
+
+	return nil // commit
+}
+
+// checkMintingDeadline re-queues mint transactions for the packs which have
+// not been minted if any mint transactions have failed or the minting deadline
+// has passed. Once retries are exhausted the distribution is set to 'stalled'.
+// Should only be called when all events up to the latest block have been
+// handled, so packs are not minted twice.
+func (svc *ContractService) checkMintingDeadline(db *gorm.DB, dist *Distribution, minting *Minting, logger *log.Entry) error {
+	pending, err := transactions.CountPending(db, dist.ID, MINT_SCRIPT)
+	if err != nil {
+		return err // rollback
+	}
+
+	failed, err := transactions.CountFailedSince(db, dist.ID, MINT_SCRIPT, minting.QueuedAt)
+	if err != nil {
+		return err // rollback
+	}
+
+	now := time.Now()
+
+	logger = logger.WithFields(log.Fields{
+		"failedCount": failed,
+		"retryCount":  minting.RetryCount,
+		"mintedCount": minting.CurrentCount,
+		"totalCount":  minting.TotalCount,
+	})
+
+	switch minting.nextAction(now, failed, pending, svc.cfg.MaxPhaseRetries) {
+	case phaseWait:
+		return nil // commit
+	case phaseStall:
+		reason := fmt.Sprintf(
+			"minting incomplete after %d retries, %d of %d packs minted",
+			minting.RetryCount, minting.CurrentCount, minting.TotalCount,
+		)
+		return svc.stall(db, dist, reason, logger)
+	}
+
+	logger.Warn("Minting incomplete, re-queueing missing batches")
+
+	// The failed transactions are replaced by the re-queued batches
+	if _, err := transactions.CancelFailed(db, dist.ID, MINT_SCRIPT); err != nil {
+		return err // rollback
+	}
+
+	minting.Requeued(now, svc.cfg.MintingTimeout)
+
+	if _, err := svc.queueMintTransactions(db, dist, logger); err != nil {
+		return err // rollback
+	}
+
+	if err := UpdateMinting(db, minting); err != nil {
+		return err // rollback
+	}
+
+	return nil // commit
+}
+
+// stall sets the given distributions state to 'stalled'. The settlement and
+// minting status are kept as is so the phase can be resumed.
+func (svc *ContractService) stall(db *gorm.DB, dist *Distribution, reason string, logger *log.Entry) error {
+	// Make sure the distribution is in correct state
+	if err := dist.SetStalled(reason); err != nil {
+		return err // rollback
+	}
+
+	// Update the distribution in database
+	if err := UpdateDistribution(db, dist); err != nil {
+		return err // rollback
+	}
+
+	logger.WithFields(log.Fields{"reason": reason}).Error("Distribution stalled, manual action required")
+
+	return nil // commit
+}
+
+// UpdateCirculatingPackContract polls for 'REVEAL_REQUEST', 'REVEALED', 'OPEN_REQUEST' and 'OPENED' events
+// regarding the given CirculatingPackContract.
+// It handles each the 'REVEAL_REQUEST' and 'OPEN_REQUEST' events by creating
+// and storing an appropriate Flow transaction in database to be later processed by a poller.
+// 'REVEALED' and 'OPENED' events are used to sync the state of a pack in database with onchain state.
+func (svc *ContractService) UpdateCirculatingPackContract(ctx context.Context, db *gorm.DB, cpc *CirculatingPackContract) error {
+	logger := log.WithFields(log.Fields{
+		"method": "UpdateCirculatingPack",
+		"cpcID":  cpc.ID,
+	})
+
+	logger.Trace("Update circulating pack")
+
+	eventNames := []string{
+		REVEAL_REQUEST,
+		REVEALED,
+		OPEN_REQUEST,
+		OPENED,
+	}
+
+	latestBlockHeader, err := svc.flowClient.GetLatestBlockHeader(ctx, true)
+	if err != nil {
+		return err // rollback
+	}
+
+	contractRef := AddressLocation{Name: cpc.Name, Address: cpc.Address}
+
+	cursor, err := GetOrCreateEventCursor(db, cpc.ID, contractRef, eventNames, cpc.StartAtBlock, svc.cfg.MaxBlocksPerCheck)
+	if err != nil {
+		return err // rollback
+	}
+
+	_, err = svc.ingestEvents(ctx, db, cursor, latestBlockHeader.Height, logger, func(db *gorm.DB, e flow.Event, eventName string) error {
+		eventLogger := logger.WithFields(log.Fields{"eventType": e.Type, "eventID": e.ID()})
+
+		evtValueMap := flow_helpers.EventValuesToMap(e)
+
+		packFlowIDCadence, ok := evtValueMap["id"]
+		if !ok {
+			err := fmt.Errorf("could not read 'id' from event %s", e)
+			return poisonEvent(err)
+		}
+
+		packFlowID, err := common.FlowIDFromCadence(packFlowIDCadence)
+		if err != nil {
+			return poisonEvent(err)
+		}
+
+		pack, err := GetPackByContractAndFlowID(db, contractRef, packFlowID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			err := fmt.Errorf("unknown pack %d: %w", packFlowID.Int64, err)
+			return poisonEvent(err)
+		}
+		if err != nil {
+			return err
+		}
+
+		distribution, err := GetDistributionSmall(db, pack.DistributionID)
+		if err != nil {
+			return err
+		}
+
+		eventLogger = eventLogger.WithFields(log.Fields{
+			"distID":     distribution.ID,
+			"distFlowID": distribution.FlowID,
+			"packID":     pack.ID,
+			"packFlowID": pack.FlowID,
+		})
+
+		switch eventName {
+		// -- REVEAL_REQUEST, Owner has requested to reveal a pack ------------
+		case REVEAL_REQUEST:
+
+			// Make sure the pack is in correct state
+			if err := pack.RevealRequestHandled(); err != nil {
+				err := fmt.Errorf("error while handling %s: %w", eventName, err)
+				return poisonEvent(err)
+			}
+
+			// Update the pack in database
+			if err := UpdatePack(db, pack); err != nil {
+				return err
+			}
+
+			// Get the owner of the pack from the transaction that emitted the open request event
+			tx, err := svc.flowClient.GetTransaction(ctx, e.TransactionID)
+			if err != nil {
+				return err
+			}
+			owner := tx.Authorizers[0]
+
+			collectibleContractAddresses, collectibleContractNames, collectibleIDs, collectibleProviderPaths := collectibleArguments(pack.Collectibles)
+
+			openRequestValue, ok := evtValueMap["openRequest"]
+			if !ok { // TODO(nanuuki): rollback or use a default value for openRequest?
+				err := fmt.Errorf("could not read 'openRequest' from event %s", e)
+				return poisonEvent(err)
+			}
+
+			openRequest, ok := openRequestValue.ToGoValue().(bool)
+			if !ok {
+				err := fmt.Errorf("invalid 'openRequest' in event %s", e)
+				return poisonEvent(err)
+			}
+			eventLogger = eventLogger.WithFields(log.Fields{"openRequest": openRequest})
+
+			arguments := []cadence.Value{
+				cadence.UInt64(distribution.FlowID.Int64),
+				cadence.UInt64(pack.FlowID.Int64),
+				collectibleContractAddresses,
+				collectibleContractNames,
+				collectibleIDs,
+				cadence.String(pack.Salt.String()),
+				cadence.Address(owner),
+				cadence.NewBool(openRequest),
+				collectibleProviderPaths,
+			}
+
+			txScript, err := flow_helpers.ParseCadenceTemplate(
+				REVEAL_SCRIPT,
+				&flow_helpers.CadenceTemplateVars{
+					PackNFTName:     pack.ContractReference.Name,
+					PackNFTAddress:  pack.ContractReference.Address.String(),
+					CollectibleNFTs: cadenceContracts(pack.Collectibles),
+				},
+			)
+			if err != nil {
+				return err
+			}
+
+			t, err := transactions.NewTransactionWithDistributionID(REVEAL_SCRIPT, txScript, arguments, distribution.ID)
+			if err != nil {
+				return err
+			}
+
+			if err := t.Save(db); err != nil {
+				return err
+			}
+
+			if openRequest { // NOTE: This block should run only if we want to reveal AND open the pack
+				// Reset the ID to save a second indentical transaction
+				t.ID = uuid.Nil
+				if err := t.Save(db); err != nil {
+					return err
+				}
+			}
+
+			eventLogger.Info("Pack reveal transaction created")
+
+		// -- REVEALED, Pack has been revealed onchain ------------------------
+		case REVEALED:
+
+			// Make sure the pack is in correct state
+			if err := pack.Reveal(); err != nil {
+				err := fmt.Errorf("error while handling %s: %w", eventName, err)
+				return poisonEvent(err)
+			}
+
+			// Update the pack in database
+			if err := UpdatePack(db, pack); err != nil {
+				return err
+			}
+
+		// -- OPEN_REQUEST, Owner has requested to open a pack ----------------
+		case OPEN_REQUEST:
+
+			// Make sure the pack is in correct state
+			if err := pack.OpenRequestHandled(); err != nil {
+				err := fmt.Errorf("error while handling %s: %w", eventName, err)
+				return poisonEvent(err)
+			}
+
+			// Update the pack in database
+			if err := UpdatePack(db, pack); err != nil {
+				return err
+			}
+
+			// Get the owner of the pack from the transaction that emitted the open request event
+			tx, err := svc.flowClient.GetTransaction(ctx, e.TransactionID)
+			if err != nil {
+				return err
+			}
+			owner := tx.Authorizers[0]
+
+			collectibleContractAddresses, collectibleContractNames, collectibleIDs, collectibleProviderPaths := collectibleArguments(pack.Collectibles)
+
+			arguments := []cadence.Value{
+				cadence.UInt64(distribution.FlowID.Int64),
+				cadence.UInt64(pack.FlowID.Int64),
+				collectibleContractAddresses,
+				collectibleContractNames,
+				collectibleIDs,
+				cadence.Address(owner),
+				collectibleProviderPaths,
+			}
+
+			txScript, err := flow_helpers.ParseCadenceTemplate(
+				OPEN_SCRIPT,
+				&flow_helpers.CadenceTemplateVars{
+					CollectibleNFTs: cadenceContracts(pack.Collectibles),
+				},
+			)
+			if err != nil {
+				return err
+			}
+
+			t, err := transactions.NewTransactionWithDistributionID(OPEN_SCRIPT, txScript, arguments, distribution.ID)
+			if err != nil {
+				return err
+			}
+
+			if err := t.Save(db); err != nil {
+				return err
+			}
+
+			eventLogger.Info("Pack open transaction created")
+
+		// -- OPENED, Pack has been opened onchain ----------------------------
+		case OPENED:
+
+			// Make sure the pack is in correct state
+			if err := pack.Open(); err != nil {
+				err := fmt.Errorf("error while handling %s: %w", eventName, err)
+				return poisonEvent(err)
+			}
+
+			// Update the pack in database
+			if err := UpdatePack(db, pack); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err // rollback
+	}
+
+	cpc.StartAtBlock = cursor.LastBlock
+
+	// Update the CirculatingPackContract in database
+	if err := UpdateCirculatingPackContract(db, cpc); err != nil {
+		return err // rollback
+	}
+
+	logger.Trace("Update circulating pack complete")
+
+	return nil // commit
+}
+
+// ingestEvents fetches the events of a cursor block range by block range, up
+// to the given latest sealed block, and passes them to 'handle' in the order
+// they were emitted. Sealed blocks are final so handled events never change.
+// Events already handled for the cursor are skipped, which makes replaying a
+// rewound cursor safe. Events which can never be handled are moved to dead
+// letters instead of blocking the cursor. The cursor is saved within the same database
+// transaction as the handled events, so it never advances without them.
+// When behind, up to 'MaxRangesPerCheck' ranges are fetched per call. A range
+// rejected by the access node is halved, and grown back once ranges succeed.
+// It returns true if all events up to the latest block have been handled.
+func (svc *ContractService) ingestEvents(ctx context.Context, db *gorm.DB, cursor *EventCursor, latestHeight uint64, logger *log.Entry, handle func(db *gorm.DB, e flow.Event, eventName string) error) (bool, error) {
+	if cursor.RangeSize == 0 || cursor.RangeSize > svc.cfg.MaxBlocksPerCheck {
+		cursor.RangeSize = svc.cfg.MaxBlocksPerCheck
+	}
+
+	for i := 0; i < svc.cfg.MaxRangesPerCheck; i++ {
+		begin := cursor.LastBlock + 1
+		if begin > latestHeight {
+			break
+		}
+		end := min(latestHeight, begin+cursor.RangeSize-1)
+
+		rangeLogger := logger.WithFields(log.Fields{
+			"cursorID":   cursor.ID,
+			"blockBegin": begin,
+			"blockEnd":   end,
+		})
+
+		events, err := svc.fetchEvents(ctx, cursor, begin, end)
+		if err != nil {
+			if flow_helpers.IsRangeRejectedError(err) && cursor.RangeSize > 1 {
+				cursor.RangeSize = cursor.RangeSize / 2
+				rangeLogger.WithFields(log.Fields{
+					"rangeSize": cursor.RangeSize,
+					"error":     err,
+				}).Warn("Block range rejected, halving range")
+				continue
+			}
+			return false, err
+		}
+
+		if err := handleEvents(db, cursor, events, rangeLogger, handle); err != nil {
+			return false, err
+		}
+
+		cursor.LastBlock = end
+		cursor.RangeSize = min(cursor.RangeSize*2, svc.cfg.MaxBlocksPerCheck)
+	}
+
+	if err := UpdateEventCursor(db, cursor); err != nil {
+		return false, err
+	}
+
+	return cursor.LastBlock >= latestHeight, nil
+}
+
+// handleEvents passes the events fetched for a cursor to 'handle' one by one,
+// skipping the ones already handled. Each event is handled in a savepoint so
+// an event which can never be handled (see poisonEvent) can be rolled back
+// alone and moved to dead letters instead of blocking the cursor.
+func handleEvents(db *gorm.DB, cursor *EventCursor, events []cursorEvent, logger *log.Entry, handle func(db *gorm.DB, e flow.Event, eventName string) error) error {
+	for _, e := range events {
+		transactionID := e.TransactionID.Hex()
+
+		processed, err := IsEventProcessed(db, cursor.ID, transactionID, uint(e.EventIndex))
+		if err != nil {
+			return err
+		}
+
+		eventLogger := logger.WithFields(log.Fields{"eventType": e.Type, "eventID": e.ID()})
+
+		if processed {
+			eventLogger.Debug("Skipping event already handled")
+			continue
+		}
+
+		eventLogger.Trace("Handling event")
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			return handle(tx, e.Event, e.Name)
+		})
+
+		var poison *poisonEventError
+		if errors.As(err, &poison) {
+			eventLogger.WithFields(log.Fields{"error": poison.Err}).Error("Unable to handle event, moving it to dead letters")
+
+			if err := InsertDeadLetterEvent(db, &DeadLetterEvent{
+				CursorID:      cursor.ID,
+				TransactionID: transactionID,
+				EventIndex:    uint(e.EventIndex),
+				EventType:     e.Type,
+				BlockHeight:   e.Height,
+				Payload:       e.Value.String(),
+				Error:         poison.Err.Error(),
+			}); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		}
+
+		if err := InsertProcessedEvent(db, &ProcessedEvent{
+			CursorID:      cursor.ID,
+			TransactionID: transactionID,
+			EventIndex:    uint(e.EventIndex),
+		}); err != nil {
+			return err
+		}
+
+		eventLogger.Trace("Handling event complete")
+	}
+
+	return nil
+}
+
+// fetchEvents returns the events of a cursor emitted between 'begin' and 'end'
+// (inclusive) in the order they were emitted.
+func (svc *ContractService) fetchEvents(ctx context.Context, cursor *EventCursor, begin, end uint64) ([]cursorEvent, error) {
+	events := []cursorEvent{}
+
+	for _, name := range cursor.Names() {
+		arr, err := svc.flowClient.GetEventsForHeightRange(ctx, client.EventRangeQuery{
+			Type:        cursor.EventType(name),
+			StartHeight: begin,
+			EndHeight:   end,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, be := range arr {
+			for _, e := range be.Events {
+				events = append(events, cursorEvent{Event: e, Name: name, Height: be.Height})
+			}
+		}
+	}
+
+	sortEvents(events)
+
+	return events, nil
+}
+
+// batchArguments maps the transactions sent in batches to the index of their batch argument.
+var batchArguments = map[string]int{
+	SETTLE_SCRIPT: 2,
+	MINT_SCRIPT:   1,
+	REFUND_SCRIPT: 1,
+}
+
+// splitTransaction replaces a batched transaction which ran out of gas with
+// two child transactions of half the batch each. The parent transaction is
+// set to 'split' so it is never retried. It returns false if the transaction
+// is not batched or its batch can not be split further.
+func (svc *ContractService) splitTransaction(db *gorm.DB, t *transactions.StorableTransaction) (bool, error) {
+	index, ok := batchArguments[t.Name]
+	if !ok {
+		return false, nil
+	}
+
+	halves, err := t.Split(index)
+	if err != nil {
+		return false, err
+	}
+
+	if len(halves) == 0 {
+		return false, nil
+	}
+
+	for _, h := range halves {
+		if err := h.Save(db); err != nil {
+			return false, err
+		}
+	}
+
+	t.State = common.TransactionStateSplit
+
+	if err := t.Save(db); err != nil {
+		return false, err
+	}
+
+	log.WithFields(log.Fields{
+		"ID":             t.ID,
+		"name":           t.Name,
+		"distributionID": t.DistributionID,
+		"error":          t.Error,
+	}).Warn("Transaction ran out of gas, split in half")
+
+	return true, nil
+}
+
+// batchSize returns the batch size to use for a batched transaction template
+// and contract: the configured size, or the learned size if it is smaller.
+func (svc *ContractService) batchSize(db *gorm.DB, name string, contract AddressLocation, configured int) (int, error) {
+	learned, err := GetBatchSize(db, name, contract)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return configured, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if learned.Size > 0 && learned.Size < configured {
+		return learned.Size, nil
+	}
+
+	return configured, nil
+}
+
+// learnBatchSize updates the batch size learned for the template and contract
+// of a settle or mint transaction which either ran out of gas or succeeded.
+func (svc *ContractService) learnBatchSize(db *gorm.DB, t *transactions.StorableTransaction, outOfGas bool) error {
+	var contract AddressLocation
+	var configured int
+
+	switch t.Name {
+	case SETTLE_SCRIPT:
+		arguments, err := t.ArgumentsAsCadence()
+		if err != nil {
+			return err
+		}
+		if len(arguments) != 3 {
+			return fmt.Errorf("unexpected arguments for settle transaction %s", t.ID)
+		}
+		contractValue, ok := arguments[1].(cadence.String)
+		if !ok {
+			return fmt.Errorf("unexpected collection argument for settle transaction %s", t.ID)
+		}
+		if contract, err = AddressLocationFromString(string(contractValue)); err != nil {
+			return err
+		}
+		configured = svc.cfg.SettlementBatchSize
+
+	case MINT_SCRIPT:
+		dist, err := GetDistributionSmall(db, t.DistributionID)
+		if err != nil {
+			return err
+		}
+		contract = dist.PackTemplate.PackReference
+		configured = svc.cfg.MintingBatchSize
+
+	default:
+		return nil
+	}
+
+	n, err := t.BatchLen(batchArguments[t.Name])
+	if err != nil {
+		return err
+	}
+
+	learned, err := GetBatchSize(db, t.Name, contract)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if !outOfGas {
+			// Nothing to learn until a batch runs out of gas
+			return nil
+		}
+		learned = &BatchSize{Name: t.Name, Contract: contract, Size: configured}
+	} else if err != nil {
+		return err
+	}
+
+	previous := learned.Size
+
+	if outOfGas {
+		learned.Failed(n)
+	} else {
+		learned.Succeeded(n, configured)
+	}
+
+	if learned.Size != previous {
+		log.WithFields(log.Fields{
+			"name":         t.Name,
+			"contract":     contract.String(),
+			"previousSize": previous,
+			"batchSize":    learned.Size,
+		}).Info("Batch size updated")
+	} else if !outOfGas {
+		return nil
+	}
+
+	return SaveBatchSize(db, learned)
+}
+
+// collectibleArguments returns the transaction arguments describing the
+// collectibles of a pack: contract addresses, contract names, flow IDs and
+// the private provider path of each collectible in PDS account.
+func collectibleArguments(cc Collectibles) (addresses, names, ids, providerPaths cadence.Array) {
+	addressValues := make([]cadence.Value, len(cc))
+	nameValues := make([]cadence.Value, len(cc))
+	idValues := make([]cadence.Value, len(cc))
+	pathValues := make([]cadence.Value, len(cc))
+
+	for i, c := range cc {
+		addressValues[i] = cadence.Address(c.ContractReference.Address)
+		nameValues[i] = cadence.String(c.ContractReference.Name)
+		idValues[i] = cadence.UInt64(c.FlowID.Int64)
+		pathValues[i] = cadence.Path{Domain: "private", Identifier: c.ContractReference.ProviderPath()}
+	}
+
+	return cadence.NewArray(addressValues), cadence.NewArray(nameValues), cadence.NewArray(idValues), cadence.NewArray(pathValues)
+}
+
+// cadenceContracts returns the collectible contracts to import in a transaction template.
+func cadenceContracts(cc Collectibles) []flow_helpers.CadenceContract {
+	contracts := cc.Contracts()
+	res := make([]flow_helpers.CadenceContract, len(contracts))
+	for i, c := range contracts {
+		res[i] = flow_helpers.CadenceContract{Name: c.Name, Address: c.Address.String(), Identifier: c.String()}
+	}
+	return res
+}
@@ -0,0 +1,59 @@
+package app
+
+import "time"
+
+// PhaseDeadline tracks the deadline and retries of the settlement or minting
+// phase of a distribution.
+type PhaseDeadline struct {
+	QueuedAt   time.Time `gorm:"column:queued_at"` // When the latest batches were queued
+	Deadline   time.Time `gorm:"column:deadline"`  // Zero if there is no deadline
+	RetryCount uint      `gorm:"column:retry_count"`
+}
+
+type phaseAction int
+
+const (
+	phaseWait phaseAction = iota
+	phaseRequeue
+	phaseStall
+)
+
+func NewPhaseDeadline(now time.Time, timeout time.Duration) PhaseDeadline {
+	p := PhaseDeadline{}
+	p.queued(now, timeout)
+	return p
+}
+
+func (p *PhaseDeadline) queued(now time.Time, timeout time.Duration) {
+	p.QueuedAt = now
+	p.Deadline = time.Time{}
+	if timeout > 0 {
+		p.Deadline = now.Add(timeout)
+	}
+}
+
+// Requeued resets the deadline after the missing batches have been queued again.
+func (p *PhaseDeadline) Requeued(now time.Time, timeout time.Duration) {
+	p.RetryCount++
+	p.queued(now, timeout)
+}
+
+func (p PhaseDeadline) IsPastDeadline(now time.Time) bool {
+	return !p.Deadline.IsZero() && now.After(p.Deadline)
+}
+
+// nextAction decides what to do with an incomplete phase given the amount of
+// its transactions which have failed since the latest batches were queued and
+// which are still pending.
+func (p PhaseDeadline) nextAction(now time.Time, failed, pending int64, maxRetries int) phaseAction {
+	if pending > 0 {
+		return phaseWait
+	}
+	if failed == 0 && !p.IsPastDeadline(now) {
+		return phaseWait
+	}
+	if int(p.RetryCount) >= maxRetries {
+		return phaseStall
+	}
+	return phaseRequeue
+}
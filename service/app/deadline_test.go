@@ -0,0 +1,46 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/flow-hydraulics/flow-pds/service/common"
+)
+
+func TestPhaseDeadline(t *testing.T) {
+	now := time.Now()
+	p := NewPhaseDeadline(now, time.Minute)
+
+	if a := p.nextAction(now, 0, 0, 1); a != phaseWait {
+		t.Errorf("expected to wait before the deadline, got %d", a)
+	}
+	if a := p.nextAction(now, 1, 1, 1); a != phaseWait {
+		t.Errorf("expected to wait while transactions are pending, got %d", a)
+	}
+	if a := p.nextAction(now, 1, 0, 1); a != phaseRequeue {
+		t.Errorf("expected to re-queue after a failure, got %d", a)
+	}
+	if a := p.nextAction(now.Add(2*time.Minute), 0, 0, 1); a != phaseRequeue {
+		t.Errorf("expected to re-queue after the deadline, got %d", a)
+	}
+
+	p.Requeued(now.Add(2*time.Minute), time.Minute)
+	if p.RetryCount != 1 || p.IsPastDeadline(now.Add(2*time.Minute)) {
+		t.Errorf("expected deadline to be reset, got %+v", p)
+	}
+	if a := p.nextAction(now.Add(2*time.Minute), 1, 0, 1); a != phaseStall {
+		t.Errorf("expected to stall once retries are exhausted, got %d", a)
+	}
+
+	if NewPhaseDeadline(now, 0).IsPastDeadline(now.Add(time.Hour)) {
+		t.Error("expected no deadline with zero timeout")
+	}
+
+	d := Distribution{State: common.DistributionStateSettling}
+	if err := d.SetStalled("test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetRefunding(); err != nil {
+		t.Errorf("expected a stalled distribution to be refundable, got %v", err)
+	}
+}
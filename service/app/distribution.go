@@ -2,8 +2,7 @@ package app
 
 import (
 	"fmt"
-	"math/rand"
-	"time"
+	"sort"
 
 	"github.com/flow-hydraulics/flow-pds/service/common"
 	"github.com/google/uuid"
@@ -14,17 +13,32 @@ type Distribution struct {
 	gorm.Model
 	ID uuid.UUID `gorm:"column:id;primary_key;type:uuid;"`
 
-	FlowID       common.FlowID            `gorm:"column:flow_id"` // A reference on the PDS Contract to this distribution
+	FlowID       common.FlowID            `gorm:"column:flow_id;uniqueIndex"` // A reference on the PDS Contract to this distribution
 	Issuer       common.FlowAddress       `gorm:"column:issuer"`
 	State        common.DistributionState `gorm:"column:state;not null;default:null"`
 	PackTemplate PackTemplate             `gorm:"embedded;embeddedPrefix:template_"`
 	Packs        []Pack                   `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+
+	Title    string                 `gorm:"column:title"`                                  // Title of the distribution onchain
+	Metadata []DistributionMetadata `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"` // Metadata of the distribution onchain
+
+	ShuffleMode           ShuffleMode        `gorm:"column:shuffle_mode"`            // How collectibles are shuffled into packs
+	ShuffleSeed           common.BinaryValue `gorm:"column:shuffle_seed"`            // private
+	ShuffleSeedCommitment common.BinaryValue `gorm:"column:shuffle_seed_commitment"` // public
+	EntropyBlockHeight    uint64             `gorm:"column:entropy_block_height"`    // Height of the block used as public entropy (verifiable shuffle)
+	EntropyBlockID        common.BinaryValue `gorm:"column:entropy_block_id"`        // ID of the block used as public entropy (verifiable shuffle)
+	CommitmentTxID        string             `gorm:"column:commitment_tx_id"`        // Flow transaction publishing the seed commitment (verifiable shuffle)
+	ResolutionConflicts   uint               `gorm:"column:resolution_conflicts"`    // How many unique key conflicts the resolver worked around
+	InvalidReason         string             `gorm:"column:invalid_reason"`          // Why the distribution was invalidated by the service
+	MissingCollectibles   Collectibles       `gorm:"column:missing_collectibles"`    // Collectibles the issuer did not own when settlement was about to start
+	StalledReason         string             `gorm:"column:stalled_reason"`          // Why settlement or minting could not be completed
 }
 
 type PackTemplate struct {
 	PackReference AddressLocation `gorm:"embedded;embeddedPrefix:pack_ref_"`             // Reference to the pack NFT contract
 	PackCount     uint            `gorm:"column:pack_count"`                             // How many packs to create
 	Buckets       []Bucket        `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"` // How to distribute collectibles in a pack
+	Constraints   PackConstraints `gorm:"column:constraints"`                            // Rules the resolved packs must satisfy
 }
 
 type Bucket struct {
@@ -35,6 +49,41 @@ type Bucket struct {
 	CollectibleReference  AddressLocation   `gorm:"embedded;embeddedPrefix:collectible_ref_"` // Reference to the collectible NFT contract
 	CollectibleCount      uint              `gorm:"column:collectible_count"`                 // How many collectibles to pick from this bucket
 	CollectibleCollection common.FlowIDList `gorm:"column:collectible_collection"`            // Collection of collectibles to pick from
+
+	// Optional metadata key (e.g. edition or set) of each collectible in the collection.
+	// A pack never receives two collectibles with the same key.
+	CollectibleMetadataKeys CollectibleKeys `gorm:"column:collectible_metadata_keys"`
+
+	// Buckets sharing a SlotGroup fill the same slots of a pack. For each pack
+	// one of them is drawn with a probability of Weight / (sum of the groups weights).
+	// An empty SlotGroup means the bucket is always drawn.
+	SlotGroup      string `gorm:"column:slot_group"`
+	Weight         uint   `gorm:"column:weight"`
+	Tier           string `gorm:"column:tier"`             // Rarity tier of the collectibles in this bucket, e.g. "rare"
+	DrawnPackCount uint   `gorm:"column:drawn_pack_count"` // How many packs were resolved with collectibles from this bucket
+}
+
+// DistributionMetadata is a key-value pair of the onchain metadata of a distribution.
+// Stored in a table of its own to allow filtering distributions by metadata.
+type DistributionMetadata struct {
+	gorm.Model
+	DistributionID uuid.UUID `gorm:"index"`
+	ID             uuid.UUID `gorm:"column:id;primary_key;type:uuid;"`
+
+	Key   string `gorm:"column:key;index"`
+	Value string `gorm:"column:value"`
+}
+
+// DistributionCollectible is a collectible included in the buckets of a distribution.
+// Stored in a table of its own to find collectibles reserved by other distributions
+// without loading their buckets.
+type DistributionCollectible struct {
+	gorm.Model
+	DistributionID uuid.UUID `gorm:"index"`
+	ID             uuid.UUID `gorm:"column:id;primary_key;type:uuid;"`
+
+	FlowID            common.FlowID   `gorm:"column:flow_id;index"`                  // ID of the collectible NFT
+	ContractReference AddressLocation `gorm:"embedded;embeddedPrefix:contract_ref_"` // Reference to the collectible NFT contract
 }
 
 type Pack struct {
@@ -43,6 +92,7 @@ type Pack struct {
 	ID             uuid.UUID `gorm:"column:id;primary_key;type:uuid;"`
 
 	ContractReference AddressLocation    `gorm:"embedded;embeddedPrefix:contract_ref_"` // Reference to the collectible NFT contract
+	Index             uint               `gorm:"column:pack_index"`                     // Index of the pack in the resolved distribution
 	FlowID            common.FlowID      `gorm:"column:flow_id;index"`                  // ID of the pack NFT
 	State             common.PackState   `gorm:"column:state;not null;default:null"`    // public
 	Salt              common.BinaryValue `gorm:"column:salt"`                           // private
@@ -68,6 +118,24 @@ func (b *Bucket) BeforeCreate(tx *gorm.DB) (err error) {
 	return nil
 }
 
+func (DistributionMetadata) TableName() string {
+	return "distribution_metadata"
+}
+
+func (m *DistributionMetadata) BeforeCreate(tx *gorm.DB) (err error) {
+	m.ID = uuid.New()
+	return nil
+}
+
+func (DistributionCollectible) TableName() string {
+	return "distribution_collectibles"
+}
+
+func (c *DistributionCollectible) BeforeCreate(tx *gorm.DB) (err error) {
+	c.ID = uuid.New()
+	return nil
+}
+
 func (Pack) TableName() string {
 	return "distribution_packs"
 }
@@ -82,6 +150,7 @@ func (p *Pack) BeforeCreate(tx *gorm.DB) (err error) {
 // - distribute given collectibles into packs based on given template
 // - hash each pack
 // - set the distributions state to resolved
+// Randomness is read from crypto/rand or derived from a seed depending on ShuffleMode.
 func (dist *Distribution) Resolve() error {
 	if dist.State != common.DistributionStateInit {
 		return fmt.Errorf("distribution has to be in 'init' state, got '%s'", dist.State)
@@ -91,50 +160,32 @@ func (dist *Distribution) Resolve() error {
 		return fmt.Errorf("distribution validation error: %w", err)
 	}
 
-	packCount := int(dist.PackTemplate.PackCount)
-	packSlotCount, err := dist.PackTemplate.PackSlotCount()
+	rnd, err := dist.shuffleSource()
 	if err != nil {
 		return err
 	}
 
-	// Init packs and their slots
-	packs := make([]Pack, packCount)
+	// Distributing collectibles
+	res, err := dist.PackTemplate.resolveCollectibles(rnd)
+	if err != nil {
+		return err
+	}
+
+	packs := make([]Pack, len(res.packs))
 	for i := range packs {
+		packs[i].Index = uint(i)
 		packs[i].State = common.PackStateInit
 		packs[i].ContractReference = dist.PackTemplate.PackReference
-		packs[i].Collectibles = make([]Collectible, packSlotCount)
+		packs[i].Collectibles = res.packs[i]
 	}
 
-	// Distributing collectibles
-	slotBaseIndex := 0
-	for _, bucket := range dist.PackTemplate.Buckets {
-		// How many collectibles to pick from this bucket per pack
-		countPerPack := int(bucket.CollectibleCount)
-		// How many collectibles to pick from this bucket in total
-		countTotal := packCount * countPerPack
-
-		// TODO (latenssi): Is this safe enough?
-		r := rand.New(rand.NewSource(time.Now().UnixNano()))
-
-		// Generate a slice of random indexes to bucket.CollectibleCollection
-		permutation := r.Perm(len(bucket.CollectibleCollection))
-
-		for i := 0; i < countTotal; i++ {
-			randomIndex := permutation[i]
-			packIndex := i % packCount
-			slotIndex := (i / packCount) + slotBaseIndex
-
-			collectible := Collectible{
-				ContractReference: bucket.CollectibleReference,
-				FlowID:            bucket.CollectibleCollection[randomIndex],
-			}
-
-			packs[packIndex].Collectibles[slotIndex] = collectible
-		}
-
-		slotBaseIndex += countPerPack
+	// Store the realized odds
+	for i := range dist.PackTemplate.Buckets {
+		dist.PackTemplate.Buckets[i].DrawnPackCount = res.drawnPackCounts[i]
 	}
 
+	dist.ResolutionConflicts = res.conflicts
+
 	// Setting commitment hashes of each pack
 	for i := range packs {
 		if err := packs[i].SetCommitmentHash(); err != nil {
@@ -148,6 +199,72 @@ func (dist *Distribution) Resolve() error {
 	return nil
 }
 
+// CommitShuffle commits a verifiable distribution to a shuffle seed.
+// The entropy block is fixed by FixEntropyBlock once the commitment has been
+// published onchain and the distribution is resolved later on by
+// ResolveWithEntropyBlock.
+func (dist *Distribution) CommitShuffle() error {
+	if dist.State != common.DistributionStateInit {
+		return fmt.Errorf("distribution has to be in 'init' state, got '%s'", dist.State)
+	}
+
+	if dist.ShuffleMode != ShuffleModeVerifiable {
+		return fmt.Errorf("shuffle mode has to be '%s', got '%s'", ShuffleModeVerifiable, dist.ShuffleMode)
+	}
+
+	if err := dist.Validate(); err != nil {
+		return fmt.Errorf("distribution validation error: %w", err)
+	}
+
+	return dist.CommitShuffleSeed()
+}
+
+// FixEntropyBlock records the onchain transaction publishing the shuffle seed
+// commitment of a verifiable distribution and the height of the entropy block
+// stored onchain with the commitment. The entropy block comes after the block
+// the commitment was published in, so it was not known at the time.
+func (dist *Distribution) FixEntropyBlock(commitmentTxID string, entropyBlockHeight uint64) error {
+	if dist.ShuffleMode != ShuffleModeVerifiable {
+		return fmt.Errorf("shuffle mode has to be '%s', got '%s'", ShuffleModeVerifiable, dist.ShuffleMode)
+	}
+
+	if dist.EntropyBlockHeight != 0 {
+		return fmt.Errorf("entropy block already fixed")
+	}
+
+	dist.CommitmentTxID = commitmentTxID
+	dist.EntropyBlockHeight = entropyBlockHeight
+
+	return nil
+}
+
+// ResolveWithEntropyBlock sets the ID of the entropy block and resolves
+// a verifiable distribution.
+func (dist *Distribution) ResolveWithEntropyBlock(blockID common.BinaryValue) error {
+	if dist.ShuffleMode != ShuffleModeVerifiable {
+		return fmt.Errorf("shuffle mode has to be '%s', got '%s'", ShuffleModeVerifiable, dist.ShuffleMode)
+	}
+
+	if !dist.EntropyBlockID.IsEmpty() {
+		return fmt.Errorf("entropy block already set")
+	}
+
+	dist.EntropyBlockID = blockID
+
+	return dist.Resolve()
+}
+
+// RevealedShuffleSeed returns the shuffle seed once it is safe to publish it,
+// otherwise nil. With the seed anyone can recompute the contents of every
+// pack, so it is published only after all packs have been minted and
+// revealed ('unrevealed' is the number of packs not revealed yet).
+func (dist Distribution) RevealedShuffleSeed(unrevealed int64) common.BinaryValue {
+	if dist.State != common.DistributionStateComplete || unrevealed > 0 {
+		return nil
+	}
+	return dist.ShuffleSeed
+}
+
 func (dist *Distribution) SetState(target common.DistributionState, prereq common.DistributionState) error {
 	if dist.State != prereq {
 		return fmt.Errorf("distribution can not be set to '%s' from '%s'", target, dist.State)
@@ -183,6 +300,43 @@ func (dist *Distribution) SetComplete() error {
 	return dist.SetState(common.DistributionStateComplete, common.DistributionStateMinting)
 }
 
+// SetRefunding sets the status to "refunding" if preceding state was valid
+func (dist *Distribution) SetRefunding() error {
+	if !dist.MayHoldEscrow() {
+		return fmt.Errorf("distribution can not be set to '%s' from '%s'", common.DistributionStateRefunding, dist.State)
+	}
+
+	dist.State = common.DistributionStateRefunding
+
+	return nil
+}
+
+// MayHoldEscrow tells whether collectibles of the distribution may have been
+// withdrawn from the issuer to the PDS escrow.
+func (dist Distribution) MayHoldEscrow() bool {
+	switch dist.State {
+	case common.DistributionStateSettling,
+		common.DistributionStateSettled,
+		common.DistributionStateMinting,
+		common.DistributionStateStalled:
+		return true
+	default:
+		return false
+	}
+}
+
+// SetStalled sets the status to "stalled" if preceding state was valid
+func (dist *Distribution) SetStalled(reason string) error {
+	if dist.State != common.DistributionStateSettling && dist.State != common.DistributionStateMinting {
+		return fmt.Errorf("distribution can not be set to '%s' from '%s'", common.DistributionStateStalled, dist.State)
+	}
+
+	dist.State = common.DistributionStateStalled
+	dist.StalledReason = reason
+
+	return nil
+}
+
 // SetInvalid sets the status to "invalid" if preceding state was valid
 func (dist *Distribution) SetInvalid() error {
 	if dist.State == common.DistributionStateComplete {
@@ -194,6 +348,42 @@ func (dist *Distribution) SetInvalid() error {
 	return nil
 }
 
+// SetInfo sets the title and metadata of the distribution as read from chain.
+func (dist *Distribution) SetInfo(title string, metadata map[string]string) {
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	dist.Title = title
+	dist.Metadata = make([]DistributionMetadata, len(keys))
+	for i, k := range keys {
+		dist.Metadata[i] = DistributionMetadata{Key: k, Value: metadata[k]}
+	}
+}
+
+// MetadataMap returns the metadata of the distribution as a map.
+func (dist Distribution) MetadataMap() map[string]string {
+	res := make(map[string]string, len(dist.Metadata))
+	for _, m := range dist.Metadata {
+		res[m.Key] = m.Value
+	}
+	return res
+}
+
+// IsSettled tells if the collectibles of the distribution have been moved to escrow.
+func (dist Distribution) IsSettled() bool {
+	switch dist.State {
+	case common.DistributionStateSettled,
+		common.DistributionStateMinting,
+		common.DistributionStateComplete:
+		return true
+	default:
+		return false
+	}
+}
+
 func (d Distribution) TemplateCollectibleCount() (int, error) {
 	packSlotCount, err := d.PackTemplate.PackSlotCount()
 	if err != nil {
@@ -203,15 +393,15 @@ func (d Distribution) TemplateCollectibleCount() (int, error) {
 }
 
 // PackSlotCount returns the number of slots in each Pack described by PackTemplate
-// (sum of all buckets ColletibleCounts)
+// (sum of ColletibleCounts of all slot groups)
 func (pt PackTemplate) PackSlotCount() (int, error) {
 	if pt.Buckets == nil {
 		return 0, fmt.Errorf("distribution not fully hydrated from database")
 	}
 
 	res := 0
-	for _, bucket := range pt.Buckets {
-		res += int(bucket.CollectibleCount)
+	for _, group := range pt.slotGroups() {
+		res += group.collectibleCount
 	}
 
 	return res, nil
@@ -1,11 +1,16 @@
 package app
 
 import (
+	"errors"
 	"reflect"
 	"testing"
 
 	"github.com/flow-hydraulics/flow-pds/service/common"
+	"github.com/flow-hydraulics/flow-pds/service/transactions"
+	"github.com/google/uuid"
 	"github.com/onflow/flow-go-sdk"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
 )
 
 func makeCollection(size int) []common.FlowID {
@@ -130,3 +135,185 @@ func TestDistributionResolution(t *testing.T) {
 		}
 	}
 }
+
+func TestDuplicateCollectibleValidation(t *testing.T) {
+	pt := makeConstrainedTemplate(10)
+
+	// Same collectible twice in a bucket and in three buckets
+	commons := append([]common.FlowID{}, pt.Buckets[0].CollectibleCollection...)
+	pt.Buckets[0].CollectibleCollection = append(commons, commons[0])
+	rares := append([]common.FlowID{}, pt.Buckets[2].CollectibleCollection...)
+	pt.Buckets[2].CollectibleCollection = append(rares, commons[1])
+	legendaries := append([]common.FlowID{}, pt.Buckets[3].CollectibleCollection...)
+	pt.Buckets[3].CollectibleCollection = append(legendaries, commons[1])
+
+	err := pt.Validate()
+
+	var duplicateErr *DuplicateCollectiblesError
+	if !errors.As(err, &duplicateErr) {
+		t.Fatalf("expected a duplicate collectibles error, got %v", err)
+	}
+
+	expected := []CollectibleConflict{
+		{Collectible: Collectible{ContractReference: pt.Buckets[0].CollectibleReference, FlowID: commons[0]}, Buckets: []int{0, 0}},
+		{Collectible: Collectible{ContractReference: pt.Buckets[0].CollectibleReference, FlowID: commons[1]}, Buckets: []int{0, 2, 3}},
+	}
+	if !reflect.DeepEqual(duplicateErr.Conflicts, expected) {
+		t.Fatalf("expected conflicts %v, got %v", expected, duplicateErr.Conflicts)
+	}
+
+	// Same ID in another contract is not a duplicate
+	other := makeConstrainedTemplate(10)
+	other.Buckets[1].CollectibleReference.Name = "OtherCollectibleNFT"
+	other.Buckets[1].CollectibleCollection = other.Buckets[0].CollectibleCollection[:10]
+
+	if err := other.Validate(); err != nil {
+		t.Errorf("didn't expect an error, got %s", err)
+	}
+
+	reserved := other.reservedCollectibles(other.Buckets[1].distributionCollectibles())
+	if len(reserved) != 10 {
+		t.Fatalf("expected 10 reserved collectibles, got %d", len(reserved))
+	}
+	for _, c := range reserved {
+		if c.Buckets[0] != 1 {
+			t.Fatalf("expected only collectibles of bucket 1 to be reserved, got %v", c)
+		}
+	}
+}
+
+func TestListReservedCollectibles(t *testing.T) {
+	db := newTestDB(t)
+
+	live := Distribution{
+		State:        common.DistributionStateInit,
+		FlowID:       common.FlowID{Int64: int64(1), Valid: true},
+		Issuer:       common.FlowAddress(flow.HexToAddress("0x1")),
+		PackTemplate: makeConstrainedTemplate(4),
+	}
+	complete := Distribution{
+		State:        common.DistributionStateInit,
+		FlowID:       common.FlowID{Int64: int64(2), Valid: true},
+		Issuer:       common.FlowAddress(flow.HexToAddress("0x1")),
+		PackTemplate: makeConstrainedTemplate(4),
+	}
+
+	states := map[*Distribution]common.DistributionState{
+		&live:     common.DistributionStateSettling,
+		&complete: common.DistributionStateComplete,
+	}
+	for d, state := range states {
+		if err := d.Resolve(); err != nil {
+			t.Fatal(err)
+		}
+		d.State = state
+		if err := InsertDistribution(db, d, 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bucket := live.PackTemplate.Buckets[2]
+	ids := append([]common.FlowID{{Int64: 1000, Valid: true}}, bucket.CollectibleCollection...)
+
+	reserved, err := ListReservedCollectibles(db, uuid.Nil, bucket.CollectibleReference, ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reserved) != len(bucket.CollectibleCollection) {
+		t.Fatalf("expected %d reserved collectibles, got %d", len(bucket.CollectibleCollection), len(reserved))
+	}
+	for _, c := range reserved {
+		if c.DistributionID != live.ID {
+			t.Errorf("expected collectible %v to be reserved by %s, got %s", c.FlowID, live.ID, c.DistributionID)
+		}
+	}
+
+	// The distribution itself does not reserve its collectibles
+	if reserved, err := ListReservedCollectibles(db, live.ID, bucket.CollectibleReference, ids); err != nil {
+		t.Fatal(err)
+	} else if len(reserved) != 0 {
+		t.Errorf("expected no reserved collectibles, got %d", len(reserved))
+	}
+
+	// Same IDs in another contract are not reserved
+	other := AddressLocation{Name: "OtherCollectibleNFT", Address: bucket.CollectibleReference.Address}
+	if reserved, err := ListReservedCollectibles(db, uuid.Nil, other, ids); err != nil {
+		t.Fatal(err)
+	} else if len(reserved) != 0 {
+		t.Errorf("expected no reserved collectibles, got %d", len(reserved))
+	}
+}
+
+func TestOnchainState(t *testing.T) {
+	cases := map[common.DistributionState]common.OnchainDistState{
+		common.DistributionStateInit:     common.OnchainDistStateInitialized,
+		common.DistributionStateResolved: common.OnchainDistStateInitialized,
+		common.DistributionStateSettling: common.OnchainDistStateInitialized,
+		common.DistributionStateMinting:  common.OnchainDistStateInitialized,
+		common.DistributionStateInvalid:  common.OnchainDistStateInvalid,
+		common.DistributionStateComplete: common.OnchainDistStateComplete,
+	}
+
+	for state, expected := range cases {
+		if got := state.OnchainState(); got != expected {
+			t.Errorf("expected %s to map to %s, got %s", state, expected, got)
+		}
+	}
+
+	r := StateReconciliation{
+		State:                common.DistributionStateComplete,
+		ExpectedOnchainState: common.OnchainDistStateComplete,
+		OnchainState:         common.OnchainDistStateInitialized,
+		PendingUpdate:        true,
+	}
+	if r.InSync() || r.Mismatch() {
+		t.Error("expected pending update to not be a mismatch")
+	}
+
+	r.PendingUpdate = false
+	if !r.Mismatch() {
+		t.Error("expected a mismatch")
+	}
+}
+
+func TestRefundingState(t *testing.T) {
+	for state, mayHoldEscrow := range map[common.DistributionState]bool{
+		common.DistributionStateSetup:    false,
+		common.DistributionStateSettling: true,
+		common.DistributionStateSettled:  true,
+		common.DistributionStateMinting:  true,
+		common.DistributionStateComplete: false,
+	} {
+		d := Distribution{State: state}
+		err := d.SetRefunding()
+		if mayHoldEscrow && err != nil {
+			t.Errorf("expected %s to be refundable, got %v", state, err)
+		}
+		if !mayHoldEscrow && err == nil {
+			t.Errorf("expected %s to not be refundable", state)
+		}
+	}
+
+	d := Distribution{State: common.DistributionStateRefunding}
+	if err := d.SetInvalid(); err != nil {
+		t.Fatal(err)
+	}
+	if d.State.OnchainState() != common.OnchainDistStateInvalid {
+		t.Errorf("expected invalid onchain state, got %s", d.State.OnchainState())
+	}
+}
+
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := transactions.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
@@ -0,0 +1,127 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/onflow/flow-go-sdk"
+	"gorm.io/gorm"
+)
+
+// EventCursor tracks up to which block the events of a contract have been
+// handled for the object owning the cursor (e.g. a Settlement or a
+// CirculatingPackContract).
+type EventCursor struct {
+	gorm.Model
+	ID      uuid.UUID `gorm:"column:id;primary_key;type:uuid;"`
+	OwnerID uuid.UUID `gorm:"column:owner_id;uniqueIndex:idx_event_cursor"`
+
+	Contract   AddressLocation `gorm:"embedded;embeddedPrefix:contract_ref_;uniqueIndex:idx_event_cursor"`
+	EventNames string          `gorm:"column:event_names;uniqueIndex:idx_event_cursor"` // Comma separated, in handling order
+
+	LastBlock uint64 `gorm:"column:last_block"` // Last block whose events have all been handled
+	RangeSize uint64 `gorm:"column:range_size"` // Amount of blocks to query at once
+}
+
+// ProcessedEvent marks an event as handled for a cursor.
+type ProcessedEvent struct {
+	gorm.Model
+	ID            uuid.UUID `gorm:"column:id;primary_key;type:uuid;"`
+	CursorID      uuid.UUID `gorm:"column:cursor_id;uniqueIndex:idx_processed_event"`
+	TransactionID string    `gorm:"column:transaction_id;uniqueIndex:idx_processed_event"`
+	EventIndex    uint      `gorm:"column:event_index;uniqueIndex:idx_processed_event"`
+}
+
+// DeadLetterEvent is an event which could not be handled, stored along with
+// the error so it can be inspected without blocking the cursor.
+type DeadLetterEvent struct {
+	gorm.Model
+	ID            uuid.UUID `gorm:"column:id;primary_key;type:uuid;"`
+	CursorID      uuid.UUID `gorm:"column:cursor_id;uniqueIndex:idx_dead_letter_event"`
+	TransactionID string    `gorm:"column:transaction_id;uniqueIndex:idx_dead_letter_event"`
+	EventIndex    uint      `gorm:"column:event_index;uniqueIndex:idx_dead_letter_event"`
+
+	EventType   string `gorm:"column:event_type"`
+	BlockHeight uint64 `gorm:"column:block_height"`
+	Payload     string `gorm:"column:payload"`
+	Error       string `gorm:"column:error"`
+}
+
+// cursorEvent is an event fetched for a cursor.
+type cursorEvent struct {
+	flow.Event
+	Name   string
+	Height uint64
+}
+
+func (EventCursor) TableName() string {
+	return "event_cursors"
+}
+
+func (c *EventCursor) BeforeCreate(tx *gorm.DB) (err error) {
+	c.ID = uuid.New()
+	return nil
+}
+
+func (ProcessedEvent) TableName() string {
+	return "processed_events"
+}
+
+func (e *ProcessedEvent) BeforeCreate(tx *gorm.DB) (err error) {
+	e.ID = uuid.New()
+	return nil
+}
+
+func (DeadLetterEvent) TableName() string {
+	return "dead_letter_events"
+}
+
+func (e *DeadLetterEvent) BeforeCreate(tx *gorm.DB) (err error) {
+	e.ID = uuid.New()
+	return nil
+}
+
+func (c EventCursor) Names() []string {
+	return strings.Split(c.EventNames, ",")
+}
+
+// EventType returns the fully qualified type of an event of the cursors contract.
+func (c EventCursor) EventType(name string) string {
+	return fmt.Sprintf("%s.%s", c.Contract, name)
+}
+
+// sortEvents sorts events in the order they were emitted.
+func sortEvents(events []cursorEvent) {
+	sort.SliceStable(events, func(i, j int) bool {
+		a, b := events[i], events[j]
+		if a.Height != b.Height {
+			return a.Height < b.Height
+		}
+		if a.TransactionIndex != b.TransactionIndex {
+			return a.TransactionIndex < b.TransactionIndex
+		}
+		return a.EventIndex < b.EventIndex
+	})
+}
+
+// poisonEventError is returned by an event handler for an event which can not
+// be handled no matter how many times it is retried, e.g. an event of an
+// unknown pack or an event not allowed in the current state of a pack.
+type poisonEventError struct {
+	Err error
+}
+
+func (e *poisonEventError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *poisonEventError) Unwrap() error {
+	return e.Err
+}
+
+// poisonEvent marks an event handling error as permanent.
+func poisonEvent(err error) error {
+	return &poisonEventError{Err: err}
+}
@@ -0,0 +1,212 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/flow-hydraulics/flow-pds/service/common"
+	"github.com/google/uuid"
+	"github.com/onflow/cadence"
+	"github.com/onflow/flow-go-sdk"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+func TestEventCursor(t *testing.T) {
+	db := newTestDB(t)
+
+	ownerID := uuid.New()
+	contract := AddressLocation{Name: "PackNFT", Address: common.FlowAddress(flow.HexToAddress("0x01"))}
+	names := []string{REVEAL_REQUEST, REVEALED, OPEN_REQUEST, OPENED}
+
+	cursor, err := GetOrCreateEventCursor(db, ownerID, contract, names, 100, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor.LastBlock != 100 || cursor.RangeSize != 50 {
+		t.Errorf("expected cursor at block 100 with range 50, got %d and %d", cursor.LastBlock, cursor.RangeSize)
+	}
+	if !reflect.DeepEqual(cursor.Names(), names) {
+		t.Errorf("expected event names %v, got %v", names, cursor.Names())
+	}
+	if cursor.EventType(OPENED) != "A.0000000000000001.PackNFT.Opened" {
+		t.Errorf("unexpected event type %s", cursor.EventType(OPENED))
+	}
+
+	cursor.LastBlock = 200
+	if err := UpdateEventCursor(db, cursor); err != nil {
+		t.Fatal(err)
+	}
+
+	existing, err := GetOrCreateEventCursor(db, ownerID, contract, names, 150, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if existing.ID != cursor.ID || existing.LastBlock != 200 {
+		t.Errorf("expected the existing cursor at block 200, got %s at %d", existing.ID, existing.LastBlock)
+	}
+
+	other, err := GetOrCreateEventCursor(db, ownerID, contract, []string{MINT}, 150, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.ID == cursor.ID {
+		t.Error("expected a separate cursor for other events")
+	}
+
+	if err := RewindEventCursors(db, ownerID, 170); err != nil {
+		t.Fatal(err)
+	}
+	rewound, err := GetOrCreateEventCursor(db, ownerID, contract, names, 0, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rewound.LastBlock != 170 {
+		t.Errorf("expected cursor to be rewound to block 170, got %d", rewound.LastBlock)
+	}
+	notRewound, err := GetOrCreateEventCursor(db, ownerID, contract, []string{MINT}, 0, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notRewound.LastBlock != 150 {
+		t.Errorf("expected cursor behind the rewind block to stay at 150, got %d", notRewound.LastBlock)
+	}
+
+	if processed, err := IsEventProcessed(db, cursor.ID, "abc", 1); err != nil || processed {
+		t.Fatalf("expected event not to be processed, got %v, %v", processed, err)
+	}
+	if err := InsertProcessedEvent(db, &ProcessedEvent{CursorID: cursor.ID, TransactionID: "abc", EventIndex: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if processed, err := IsEventProcessed(db, cursor.ID, "abc", 1); err != nil || !processed {
+		t.Fatalf("expected event to be processed, got %v, %v", processed, err)
+	}
+	if processed, err := IsEventProcessed(db, other.ID, "abc", 1); err != nil || processed {
+		t.Fatalf("expected event not to be processed for another cursor, got %v, %v", processed, err)
+	}
+	if err := InsertProcessedEvent(db, &ProcessedEvent{CursorID: cursor.ID, TransactionID: "abc", EventIndex: 1}); err == nil {
+		t.Error("expected an error when processing an event twice")
+	}
+}
+
+func TestSortEvents(t *testing.T) {
+	events := []cursorEvent{
+		{Name: OPENED, Height: 2, Event: flow.Event{TransactionIndex: 0, EventIndex: 1}},
+		{Name: REVEALED, Height: 2, Event: flow.Event{TransactionIndex: 0, EventIndex: 0}},
+		{Name: OPEN_REQUEST, Height: 1, Event: flow.Event{TransactionIndex: 1, EventIndex: 0}},
+		{Name: REVEAL_REQUEST, Height: 1, Event: flow.Event{TransactionIndex: 0, EventIndex: 3}},
+	}
+
+	sortEvents(events)
+
+	expected := []string{REVEAL_REQUEST, OPEN_REQUEST, REVEALED, OPENED}
+	for i, e := range events {
+		if e.Name != expected[i] {
+			t.Errorf("expected %s at index %d, got %s", expected[i], i, e.Name)
+		}
+	}
+}
+
+func TestHandleEvents(t *testing.T) {
+	db := newTestDB(t)
+
+	contract := AddressLocation{Name: "PackNFT", Address: common.FlowAddress(flow.HexToAddress("0x01"))}
+	cursor, err := GetOrCreateEventCursor(db, uuid.New(), contract, []string{REVEAL_REQUEST, OPEN_REQUEST}, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := func(name, transactionID string, index int) flow.Event {
+		value := cadence.NewEvent([]cadence.Value{cadence.UInt64(1)}).WithType(&cadence.EventType{
+			QualifiedIdentifier: "PackNFT." + name,
+			Fields:              []cadence.Field{{Identifier: "id", Type: cadence.UInt64Type{}}},
+		})
+		return flow.Event{Type: cursor.EventType(name), TransactionID: flow.HexToID(transactionID), EventIndex: index, Value: value}
+	}
+
+	events := []cursorEvent{
+		{Name: REVEAL_REQUEST, Height: 1, Event: event(REVEAL_REQUEST, "01", 0)},
+		{Name: OPEN_REQUEST, Height: 1, Event: event(OPEN_REQUEST, "01", 1)},
+		{Name: OPEN_REQUEST, Height: 2, Event: event(OPEN_REQUEST, "02", 0)},
+	}
+
+	transient := fmt.Errorf("access node unavailable")
+	failTransient := true
+	handled := []string{}
+
+	// Writes of an event handler, rolled back along with a failed event
+	write := func(db *gorm.DB, e flow.Event) error {
+		return InsertDeadLetterEvent(db, &DeadLetterEvent{CursorID: uuid.New(), TransactionID: e.TransactionID.Hex(), EventIndex: uint(e.EventIndex)})
+	}
+
+	handle := func(db *gorm.DB, e flow.Event, eventName string) error {
+		if err := write(db, e); err != nil {
+			return err
+		}
+		switch {
+		case eventName == OPEN_REQUEST && e.EventIndex == 1:
+			return poisonEvent(fmt.Errorf("pack not revealed"))
+		case eventName == OPEN_REQUEST && failTransient:
+			return transient
+		}
+		handled = append(handled, e.TransactionID.Hex())
+		return nil
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		return handleEvents(tx, cursor, events, log.NewEntry(log.StandardLogger()), handle)
+	})
+	if !errors.Is(err, transient) {
+		t.Fatalf("expected the transient error, got %v", err)
+	}
+
+	// Nothing is kept when the range fails
+	if processed, _ := IsEventProcessed(db, cursor.ID, events[0].TransactionID.Hex(), 0); processed {
+		t.Error("expected no events to be processed after a failed range")
+	}
+
+	handled = []string{}
+	failTransient = false
+
+	for i := 0; i < 2; i++ {
+		err = db.Transaction(func(tx *gorm.DB) error {
+			return handleEvents(tx, cursor, events, log.NewEntry(log.StandardLogger()), handle)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Events are handled once, replays skip them
+	if len(handled) != 2 {
+		t.Errorf("expected 2 handled events, got %v", handled)
+	}
+
+	for _, e := range events {
+		if processed, err := IsEventProcessed(db, cursor.ID, e.TransactionID.Hex(), uint(e.EventIndex)); err != nil || !processed {
+			t.Errorf("expected event %s/%d to be processed, got %v, %v", e.TransactionID, e.EventIndex, processed, err)
+		}
+	}
+
+	deadLetters, err := ListDeadLetterEvents(db, cursor.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deadLetters) != 1 {
+		t.Fatalf("expected 1 dead letter, got %d", len(deadLetters))
+	}
+	if deadLetters[0].EventIndex != 1 || deadLetters[0].Error != "pack not revealed" {
+		t.Errorf("unexpected dead letter %+v", deadLetters[0])
+	}
+
+	// Writes of the poison event are rolled back, writes of handled events are kept
+	var writes int64
+	if err := db.Model(&DeadLetterEvent{}).Where("cursor_id != ?", cursor.ID).Count(&writes).Error; err != nil {
+		t.Fatal(err)
+	}
+	if writes != 2 {
+		t.Errorf("expected 2 handler writes, got %d", writes)
+	}
+}
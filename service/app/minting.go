@@ -15,6 +15,8 @@ type Minting struct {
 	CurrentCount uint   `gorm:"column:current_count"`
 	TotalCount   uint   `gorm:"column:total_count"`
 	StartAtBlock uint64 `gorm:"column:start_at_block"`
+
+	PhaseDeadline
 }
 
 func (Minting) TableName() string {
@@ -12,6 +12,23 @@ import (
 const SALT_LENGTH_IN_BYTES = 32 // 256-bit
 const HASH_DELIM = ","
 
+// Packs in these states have had their contents revealed onchain
+var revealedPackStates = []common.PackState{
+	common.PackStateRevealed,
+	common.PackStateOpenRequestHandled,
+	common.PackStateOpened,
+}
+
+// IsRevealed tells if the contents of the pack have been revealed onchain.
+func (p Pack) IsRevealed() bool {
+	for _, s := range revealedPackStates {
+		if p.State == s {
+			return true
+		}
+	}
+	return false
+}
+
 // SetCommitmentHash should
 // - validate the pack
 // - decide on a random salt value
@@ -46,14 +63,18 @@ func (p *Pack) SetCommitmentHash() error {
 // We also use the full reference (address and name) of a collectible to make
 // it more difficult to fiddle with the types of collectibles inside a pack.
 func (p *Pack) Hash() []byte {
+	hash := sha256.Sum256([]byte(p.HashInput()))
+	return hash[:]
+}
+
+// HashInput returns the pre-image of the 'commitmentHash' of a pack.
+func (p *Pack) HashInput() string {
 	inputs := make([]string, 1+len(p.Collectibles))
 	inputs[0] = hex.EncodeToString(p.Salt)
 	for i, c := range p.Collectibles {
 		inputs[i+1] = c.HashString()
 	}
-	input := strings.Join(inputs, HASH_DELIM)
-	hash := sha256.Sum256([]byte(input))
-	return hash[:]
+	return strings.Join(inputs, HASH_DELIM)
 }
 
 // Seal should set the FlowID of the pack and set it as sealed
@@ -30,12 +30,14 @@ func poller(app *App) {
 		case <-ticker.C:
 			log.Trace("Poll start")
 
+			logPollerRun("handleInit", handleInit(ctx, app))
 			logPollerRun("handleResolved", handleResolved(ctx, app))
 			logPollerRun("handleSetup", handleSetup(ctx, app))
 			logPollerRun("handleSettling", handleSettling(ctx, app))
 			logPollerRun("handleSettled", handleSettled(ctx, app))
 			logPollerRun("handleMinting", handleMinting(ctx, app))
 			logPollerRun("handleComplete", handleComplete(ctx, app))
+			logPollerRun("handleRefunding", handleRefunding(ctx, app))
 
 			logPollerRun("pollCirculatingPackContractEvents", pollCirculatingPackContractEvents(ctx, app))
 
@@ -87,6 +89,36 @@ func listCirculatingPackContracts(db *gorm.DB) ([]CirculatingPackContract, error
 		Find(&list).Error
 }
 
+// handleInit resolves each initialized distribution in a database transaction
+// of its own so a distribution failing to resolve does not block the others.
+func handleInit(ctx context.Context, app *App) error {
+	initialized, err := listDistributionsByState(app.db, common.DistributionStateInit)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+
+	for _, dist := range initialized {
+		err := app.db.Transaction(func(tx *gorm.DB) error {
+			return app.service.ResolveDistribution(ctx, tx, &dist)
+		})
+		if err != nil {
+			log.WithFields(log.Fields{
+				"distID": dist.ID,
+				"error":  err,
+			}).Warn("Error while resolving distribution")
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d distributions could not be resolved", failed, len(initialized))
+	}
+
+	return nil
+}
+
 func handleResolved(ctx context.Context, app *App) error {
 	return app.db.Transaction(func(tx *gorm.DB) error {
 		resolved, err := listDistributionsByState(tx, common.DistributionStateResolved)
@@ -198,6 +230,32 @@ func handleComplete(ctx context.Context, app *App) error {
 	})
 }
 
+// handleRefunding starts the refund of aborted distributions once their
+// transactions are no longer in flight and tracks the refunds progress.
+func handleRefunding(ctx context.Context, app *App) error {
+	return app.db.Transaction(func(tx *gorm.DB) error {
+		refunding, err := listDistributionsByState(tx, common.DistributionStateRefunding)
+		if err != nil {
+			return err
+		}
+
+		for _, dist := range refunding {
+			_, err := GetDistributionRefund(tx, dist.ID)
+			switch {
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				err = app.service.StartRefund(ctx, tx, &dist)
+			case err == nil:
+				err = app.service.UpdateRefundStatus(ctx, tx, &dist)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
 func pollCirculatingPackContractEvents(ctx context.Context, app *App) error {
 	return app.db.Transaction(func(tx *gorm.DB) error {
 		cc, err := listCirculatingPackContracts(tx)
@@ -261,8 +319,15 @@ func handleSendableTransactions(ctx context.Context, app *App, rateLimiter ratel
 			if err = app.service.flowClient.SendTransaction(ctx, *tx); err != nil {
 				err = fmt.Errorf("error while sending transaction: %w", err)
 
-				t.State = common.TransactionStateFailed
-				t.Error = err.Error()
+				if flow_helpers.IsSendTimeoutError(err) {
+					// The transaction may have been received regardless, keep it
+					// sent so its status is checked before it is sent again
+					t.Error = err.Error()
+					t.ErrorClass = flow_helpers.ErrorClassTransient
+				} else {
+					// Failed or retried later depending on the error
+					t.SetError(err, app.service.retryPolicies(), time.Now())
+				}
 
 				if err = t.Save(dbtx); err != nil {
 					err = fmt.Errorf("error while saving transaction: %w", err)
@@ -326,11 +391,34 @@ func handleSentTransactions(ctx context.Context, app *App) error {
 				return
 			}
 
-			if err = t.HandleResult(ctx, app.service.flowClient); err != nil {
+			if err = t.HandleResult(ctx, app.service.flowClient, app.service.retryPolicies()); err != nil {
 				err = fmt.Errorf("error while handling transaction result: %w", err)
 				return
 			}
 
+			if t.State == common.TransactionStateComplete {
+				if err = app.service.learnBatchSize(dbtx, t, false); err != nil {
+					err = fmt.Errorf("error while updating batch size: %w", err)
+					return
+				}
+			}
+
+			if t.State == common.TransactionStateFailed && t.ErrorClass == flow_helpers.ErrorClassOutOfGas {
+				if err = app.service.learnBatchSize(dbtx, t, true); err != nil {
+					err = fmt.Errorf("error while updating batch size: %w", err)
+					return
+				}
+
+				split, splitErr := app.service.splitTransaction(dbtx, t)
+				if splitErr != nil {
+					err = fmt.Errorf("error while splitting transaction: %w", splitErr)
+					return
+				}
+				if split {
+					return nil
+				}
+			}
+
 			log.WithFields(log.Fields{
 				"function":       "handleSentTransactions",
 				"ID":             t.ID,
@@ -0,0 +1,86 @@
+package app
+
+import (
+	"fmt"
+
+	"github.com/flow-hydraulics/flow-pds/service/common"
+)
+
+// DistributionPreview is the result of a dry-run of creating a distribution.
+type DistributionPreview struct {
+	Valid                    bool
+	Errors                   []error
+	Warnings                 []string
+	SlotCount                int // Collectibles per pack
+	RequiredCollectibleCount int // Collectibles needed to fill all packs
+	SuppliedCollectibleCount int // Collectibles in all buckets
+	Buckets                  []BucketPreview
+}
+
+// BucketPreview describes the supply of a bucket in a dry-run resolution.
+type BucketPreview struct {
+	SuppliedCount  int     // Collectibles in the bucket
+	DrawnPackCount int     // Packs drawing from the bucket
+	UsedCount      int     // Collectibles picked into packs
+	LeftoverCount  int     // Collectibles left unused
+	ExpectedOdds   float64 // Probability of a pack drawing from the bucket based on the weights
+	EffectiveOdds  float64 // Same with the 'exact-packs' and 'every-nth' constraints applied
+}
+
+// Preview validates and resolves a copy of the distribution without
+// modifying it. Validation and resolution errors are reported in the preview
+// as returned, e.g. a *DuplicateCollectiblesError for duplicate collectibles.
+// For weighted slot groups the leftover counts are those of one sample resolution.
+func (dist Distribution) Preview() DistributionPreview {
+	preview := DistributionPreview{
+		Errors:   []error{},
+		Warnings: []string{},
+		Buckets:  make([]BucketPreview, len(dist.PackTemplate.Buckets)),
+	}
+
+	odds := dist.PackTemplate.Odds()
+	for i, bucket := range dist.PackTemplate.Buckets {
+		preview.Buckets[i].SuppliedCount = len(bucket.CollectibleCollection)
+		preview.Buckets[i].ExpectedOdds = odds[i].Expected
+		preview.Buckets[i].EffectiveOdds = odds[i].Effective
+		preview.SuppliedCollectibleCount += len(bucket.CollectibleCollection)
+	}
+
+	if slotCount, err := dist.PackTemplate.PackSlotCount(); err == nil {
+		preview.SlotCount = slotCount
+		preview.RequiredCollectibleCount = slotCount * int(dist.PackTemplate.PackCount)
+	}
+
+	// Resolve a copy, a verifiable distribution can only be resolved once
+	// its entropy block is sealed so use randomness from crypto/rand instead
+	d := dist
+	d.State = common.DistributionStateInit
+	d.ShuffleSeed = nil
+	d.Packs = nil
+	d.PackTemplate.Buckets = make([]Bucket, len(dist.PackTemplate.Buckets))
+	copy(d.PackTemplate.Buckets, dist.PackTemplate.Buckets)
+	if d.ShuffleMode == ShuffleModeVerifiable {
+		d.ShuffleMode = ShuffleModeRandom
+	}
+
+	if err := d.Resolve(); err != nil {
+		preview.Errors = append(preview.Errors, err)
+		return preview
+	}
+
+	for i, bucket := range d.PackTemplate.Buckets {
+		b := &preview.Buckets[i]
+		b.DrawnPackCount = int(bucket.DrawnPackCount)
+		b.UsedCount = int(bucket.DrawnPackCount * bucket.CollectibleCount)
+		b.LeftoverCount = b.SuppliedCount - b.UsedCount
+		if b.LeftoverCount > 0 {
+			preview.Warnings = append(preview.Warnings, fmt.Sprintf(
+				"bucket %d has %d unused collectibles", i, b.LeftoverCount,
+			))
+		}
+	}
+
+	preview.Valid = true
+
+	return preview
+}
@@ -0,0 +1,98 @@
+package app
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/flow-hydraulics/flow-pds/service/common"
+	"github.com/onflow/flow-go-sdk"
+)
+
+func TestDistributionPreview(t *testing.T) {
+	pt := makeConstrainedTemplate(10)
+
+	// Extra collectible, copy the collection as it shares an array with the other buckets
+	extra := append([]common.FlowID{}, pt.Buckets[0].CollectibleCollection...)
+	pt.Buckets[0].CollectibleCollection = append(extra, common.FlowID{Int64: 1000, Valid: true})
+	pt.Constraints = PackConstraints{{Type: PackConstraintExactPacks, Tier: "legendary", Count: 2}}
+
+	d := Distribution{
+		State:        common.DistributionStateInit,
+		FlowID:       common.FlowID{Int64: int64(1), Valid: true},
+		Issuer:       common.FlowAddress(flow.HexToAddress("0x1")),
+		ShuffleMode:  ShuffleModeVerifiable,
+		PackTemplate: pt,
+	}
+
+	preview := d.Preview()
+
+	if !preview.Valid {
+		t.Fatalf("expected preview to be valid, got errors %v", preview.Errors)
+	}
+
+	if d.State != common.DistributionStateInit || len(d.Packs) != 0 || d.PackTemplate.Buckets[0].DrawnPackCount != 0 {
+		t.Fatal("expected preview to not modify the distribution")
+	}
+
+	if preview.SlotCount != 3 || preview.RequiredCollectibleCount != 30 {
+		t.Fatalf("unexpected slot count %d or required count %d", preview.SlotCount, preview.RequiredCollectibleCount)
+	}
+
+	if preview.SuppliedCollectibleCount != 51 {
+		t.Fatalf("expected 51 supplied collectibles, got %d", preview.SuppliedCollectibleCount)
+	}
+
+	if preview.Buckets[0].UsedCount != 20 || preview.Buckets[0].LeftoverCount != 1 {
+		t.Fatalf("unexpected used %d or leftover %d count", preview.Buckets[0].UsedCount, preview.Buckets[0].LeftoverCount)
+	}
+
+	// Both the configured and the effective odds are reported
+	if legendary := preview.Buckets[3]; legendary.ExpectedOdds != 1.0/11 || legendary.EffectiveOdds != 0.2 {
+		t.Fatalf("unexpected expected %f or effective %f odds", legendary.ExpectedOdds, legendary.EffectiveOdds)
+	}
+
+	used := 0
+	for _, b := range preview.Buckets {
+		used += b.UsedCount
+	}
+	if used != preview.RequiredCollectibleCount {
+		t.Fatalf("expected used count %d to match required count %d", used, preview.RequiredCollectibleCount)
+	}
+
+	unused := false
+	for _, w := range preview.Warnings {
+		if strings.Contains(w, "bucket 0 has 1 unused") {
+			unused = true
+		}
+	}
+	if !unused {
+		t.Errorf("expected a warning for an unused collectible, got %v", preview.Warnings)
+	}
+
+	d.PackTemplate.PackCount = 0
+
+	if preview := d.Preview(); preview.Valid || len(preview.Errors) == 0 {
+		t.Error("expected preview to be invalid")
+	}
+
+	// Duplicates are reported as a structured error
+	d.PackTemplate.PackCount = 10
+	commons := append([]common.FlowID{}, d.PackTemplate.Buckets[0].CollectibleCollection...)
+	d.PackTemplate.Buckets[0].CollectibleCollection = append(commons, commons[0])
+
+	preview = d.Preview()
+
+	if preview.Valid {
+		t.Fatal("expected preview with duplicate collectibles to be invalid")
+	}
+
+	var duplicateErr *DuplicateCollectiblesError
+	if len(preview.Errors) != 1 || !errors.As(preview.Errors[0], &duplicateErr) {
+		t.Fatalf("expected a DuplicateCollectiblesError, got %v", preview.Errors)
+	}
+
+	if len(duplicateErr.Conflicts) != 1 || duplicateErr.Conflicts[0].Collectible.FlowID != commons[0] {
+		t.Fatalf("unexpected conflicts %v", duplicateErr.Conflicts)
+	}
+}
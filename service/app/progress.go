@@ -0,0 +1,115 @@
+package app
+
+import (
+	"errors"
+
+	"github.com/flow-hydraulics/flow-pds/service/common"
+	"github.com/flow-hydraulics/flow-pds/service/transactions"
+	"gorm.io/gorm"
+)
+
+// DistributionProgress summarizes how far a distribution has progressed.
+type DistributionProgress struct {
+	State common.DistributionState
+
+	SettledCount    uint    // Collectibles moved to escrow
+	SettlementTotal uint    // Collectibles to move to escrow
+	SettledPercent  float64 // 0-100
+
+	MintedCount  uint // Packs minted
+	MintingTotal uint // Packs to mint
+
+	RefundedCount uint // Escrowed collectibles returned to the issuer of an aborted distribution
+	RefundTotal   uint // Escrowed collectibles to return
+
+	PackStates map[common.PackState]int64 // Pack counts by state
+
+	// Last block scanned for settlement, minting or refund events, zero if none is in progress
+	LastScannedBlock uint64
+
+	PendingTransactions int64 // Transactions not yet sent or sealed
+	FailedTransactions  int64
+}
+
+// GetDistributionProgress collects the progress of a distribution from database.
+// Settlement and minting counters are removed once a distribution is complete,
+// so the progress is derived from the distribution state and packs when they are missing.
+func GetDistributionProgress(db *gorm.DB, dist *Distribution) (*DistributionProgress, error) {
+	progress := DistributionProgress{
+		State:        dist.State,
+		MintingTotal: dist.PackTemplate.PackCount,
+	}
+
+	if total, err := dist.TemplateCollectibleCount(); err == nil {
+		progress.SettlementTotal = uint(total)
+	}
+
+	settlement, err := GetDistributionSettlement(db, dist.ID)
+	switch {
+	case err == nil:
+		progress.SettledCount = settlement.CurrentCount
+		progress.SettlementTotal = settlement.TotalCount
+		if dist.State == common.DistributionStateSettling {
+			progress.LastScannedBlock = settlement.StartAtBlock
+		}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		if dist.IsSettled() {
+			progress.SettledCount = progress.SettlementTotal
+		}
+	default:
+		return nil, err
+	}
+
+	if progress.SettlementTotal > 0 {
+		progress.SettledPercent = 100 * float64(progress.SettledCount) / float64(progress.SettlementTotal)
+	}
+
+	minting, err := GetDistributionMinting(db, dist.ID)
+	switch {
+	case err == nil:
+		progress.MintingTotal = minting.TotalCount
+		if dist.State == common.DistributionStateMinting {
+			progress.LastScannedBlock = minting.StartAtBlock
+		}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+	default:
+		return nil, err
+	}
+
+	refund, err := GetDistributionRefund(db, dist.ID)
+	switch {
+	case err == nil:
+		progress.RefundedCount = refund.CurrentCount
+		progress.RefundTotal = refund.TotalCount
+		if dist.State == common.DistributionStateRefunding {
+			progress.LastScannedBlock = refund.StartAtBlock
+		}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+	default:
+		return nil, err
+	}
+
+	progress.PackStates, err = CountDistributionPacksByState(db, dist.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Packs leave the init state once they have been minted
+	for state, count := range progress.PackStates {
+		if state != common.PackStateInit {
+			progress.MintedCount += uint(count)
+		}
+	}
+
+	txStates, err := transactions.CountByState(db, dist.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	progress.PendingTransactions = txStates[common.TransactionStateInit] +
+		txStates[common.TransactionStateRetry] +
+		txStates[common.TransactionStateSent]
+	progress.FailedTransactions = txStates[common.TransactionStateFailed]
+
+	return &progress, nil
+}
@@ -0,0 +1,120 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/flow-hydraulics/flow-pds/service/common"
+	"github.com/flow-hydraulics/flow-pds/service/transactions"
+	"github.com/google/uuid"
+	"github.com/onflow/flow-go-sdk"
+	"gorm.io/gorm"
+)
+
+func TestDistributionProgress(t *testing.T) {
+	db := newTestDB(t)
+
+	d := Distribution{
+		State:        common.DistributionStateInit,
+		FlowID:       common.FlowID{Int64: int64(1), Valid: true},
+		Issuer:       common.FlowAddress(flow.HexToAddress("0x1")),
+		PackTemplate: makeConstrainedTemplate(4),
+	}
+
+	if err := d.Resolve(); err != nil {
+		t.Fatal(err)
+	}
+
+	d.State = common.DistributionStateMinting
+	d.Packs[0].State = common.PackStateSealed
+	d.Packs[1].State = common.PackStateRevealed
+
+	if err := InsertDistribution(db, &d, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := InsertMinting(db, &Minting{DistributionID: d.ID, CurrentCount: 2, TotalCount: 4, StartAtBlock: 42}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, state := range []common.TransactionState{common.TransactionStateSent, common.TransactionStateFailed, common.TransactionStateComplete} {
+		tx, err := transactions.NewTransactionWithDistributionID("test", []byte{}, nil, d.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx.State = state
+		if err := tx.Save(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	progress, err := GetDistributionProgress(db, &d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Settlement counters have been removed, the distribution is past settlement
+	if progress.SettledCount != 12 || progress.SettlementTotal != 12 || progress.SettledPercent != 100 {
+		t.Errorf("unexpected settlement progress %d/%d (%f%%)", progress.SettledCount, progress.SettlementTotal, progress.SettledPercent)
+	}
+
+	if progress.MintedCount != 2 || progress.MintingTotal != 4 {
+		t.Errorf("unexpected minting progress %d/%d", progress.MintedCount, progress.MintingTotal)
+	}
+
+	expectedStates := map[common.PackState]int64{
+		common.PackStateInit:     2,
+		common.PackStateSealed:   1,
+		common.PackStateRevealed: 1,
+	}
+	if !reflect.DeepEqual(progress.PackStates, expectedStates) {
+		t.Errorf("expected pack states %v, got %v", expectedStates, progress.PackStates)
+	}
+
+	if progress.LastScannedBlock != 42 {
+		t.Errorf("expected last scanned block 42, got %d", progress.LastScannedBlock)
+	}
+
+	if progress.PendingTransactions != 1 || progress.FailedTransactions != 1 {
+		t.Errorf("unexpected pending %d or failed %d transaction count", progress.PendingTransactions, progress.FailedTransactions)
+	}
+}
+
+func TestGetMintingPack(t *testing.T) {
+	db := newTestDB(t)
+
+	d := Distribution{
+		State:        common.DistributionStateInit,
+		FlowID:       common.FlowID{Int64: int64(1), Valid: true},
+		Issuer:       common.FlowAddress(flow.HexToAddress("0x1")),
+		PackTemplate: makeConstrainedTemplate(4),
+	}
+
+	if err := d.Resolve(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Packs[1].Seal(common.FlowID{Int64: int64(7), Valid: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := InsertDistribution(db, &d, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	pack, err := GetMintingPack(db, d.ID, d.Packs[0].CommitmentHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pack.ID != d.Packs[0].ID {
+		t.Errorf("expected pack %s, got %s", d.Packs[0].ID, pack.ID)
+	}
+
+	if _, err := GetMintingPack(db, d.ID, d.Packs[1].CommitmentHash); err != gorm.ErrRecordNotFound {
+		t.Errorf("expected sealed pack to not be found, got %v", err)
+	}
+
+	if _, err := GetMintingPack(db, uuid.New(), d.Packs[0].CommitmentHash); err != gorm.ErrRecordNotFound {
+		t.Errorf("expected pack of another distribution to not be found, got %v", err)
+	}
+}
@@ -0,0 +1,26 @@
+package app
+
+import (
+	"github.com/flow-hydraulics/flow-pds/service/common"
+	"github.com/google/uuid"
+)
+
+// StateReconciliation compares the offchain state of a distribution to its onchain state.
+type StateReconciliation struct {
+	DistributionID       uuid.UUID
+	DistFlowID           common.FlowID
+	State                common.DistributionState
+	ExpectedOnchainState common.OnchainDistState
+	OnchainState         common.OnchainDistState
+	PendingUpdate        bool // A state update transaction has not been sealed yet
+}
+
+// InSync reports whether the onchain state matches the offchain state.
+func (r StateReconciliation) InSync() bool {
+	return r.OnchainState == r.ExpectedOnchainState
+}
+
+// Mismatch reports whether the states disagree with no pending update to fix it.
+func (r StateReconciliation) Mismatch() bool {
+	return !r.InSync() && !r.PendingUpdate
+}
@@ -0,0 +1,94 @@
+package app
+
+import (
+	"fmt"
+
+	"github.com/flow-hydraulics/flow-pds/service/common"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Refund represents the status of returning the escrowed collectibles of an
+// aborted distribution to its issuer.
+type Refund struct {
+	gorm.Model
+	ID             uuid.UUID    `gorm:"column:id;primary_key;type:uuid;"`
+	DistributionID uuid.UUID    `gorm:"unique"`
+	Distribution   Distribution `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+
+	CurrentCount uint   `gorm:"column:current_count"`
+	TotalCount   uint   `gorm:"column:total_count"`
+	StartAtBlock uint64 `gorm:"column:start_at_block"`
+
+	IssuerAddress common.FlowAddress  `gorm:"column:issuer_address"`
+	Collectibles  []RefundCollectible `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
+
+type RefundCollectible struct {
+	gorm.Model
+	RefundID uuid.UUID
+	ID       uuid.UUID `gorm:"column:id;primary_key;type:uuid;"`
+
+	FlowID            common.FlowID   `gorm:"column:flow_id;"`                       // ID of the collectible NFT
+	ContractReference AddressLocation `gorm:"embedded;embeddedPrefix:contract_ref_"` // Reference to the collectible NFT contract
+	IsRefunded        bool            `gorm:"column:is_refunded"`
+}
+
+type RefundCollectibles []RefundCollectible
+
+func (Refund) TableName() string {
+	return "refunds"
+}
+
+func (r *Refund) BeforeCreate(tx *gorm.DB) (err error) {
+	r.ID = uuid.New()
+	return nil
+}
+
+func (r *Refund) IsComplete() bool {
+	return r.CurrentCount >= r.TotalCount
+}
+
+func (r *Refund) IncrementCount() {
+	r.CurrentCount++
+}
+
+func (RefundCollectible) TableName() string {
+	return "refund_collectibles"
+}
+
+func (r *RefundCollectible) BeforeCreate(tx *gorm.DB) (err error) {
+	r.ID = uuid.New()
+	return nil
+}
+
+func (r *RefundCollectible) SetRefunded() (err error) {
+	if r.IsRefunded {
+		return fmt.Errorf("refund collectible already refunded")
+	}
+
+	r.IsRefunded = true
+
+	return nil
+}
+
+func (cc RefundCollectibles) ContainsID(id common.FlowID) (int, bool) {
+	for i, v := range cc {
+		if v.FlowID == id {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+func (cc RefundCollectibles) GroupByContract() map[AddressLocation]RefundCollectibles {
+	res := make(map[AddressLocation]RefundCollectibles)
+	for _, c := range cc {
+		key := c.ContractReference
+		if _, ok := res[key]; !ok {
+			res[key] = RefundCollectibles{}
+		}
+		res[key] = append(res[key], c)
+	}
+	return res
+}
@@ -0,0 +1,287 @@
+package app
+
+import (
+	"fmt"
+	"io"
+)
+
+// resolution is the result of distributing collectibles into packs.
+type resolution struct {
+	packs           []Collectibles
+	drawnPackCounts []uint  // Indexes match PackTemplate.Buckets
+	sources         [][]int // Bucket of each slot of each pack
+	conflicts       uint    // Unique constraint conflicts resolved
+}
+
+// slotGroup is a set of buckets filling the same slots of a pack.
+type slotGroup struct {
+	name             string
+	buckets          []int // Indexes to PackTemplate.Buckets
+	collectibleCount int
+	totalWeight      uint
+}
+
+// BucketOdds describes the probability of a pack getting collectibles from a bucket.
+type BucketOdds struct {
+	Expected  float64 // Based on the bucket weights
+	Effective float64 // Based on the bucket weights and the 'exact-packs' and 'every-nth' constraints
+	Realized  float64 // Based on the resolved packs
+}
+
+// slotGroups returns the slot groups of the template in order of first appearance.
+// Each bucket without a SlotGroup forms a group of its own.
+func (pt PackTemplate) slotGroups() []slotGroup {
+	groups := make([]slotGroup, 0, len(pt.Buckets))
+	named := make(map[string]int)
+
+	for i, bucket := range pt.Buckets {
+		if bucket.SlotGroup != "" {
+			if g, ok := named[bucket.SlotGroup]; ok {
+				groups[g].buckets = append(groups[g].buckets, i)
+				groups[g].totalWeight += bucket.Weight
+				continue
+			}
+			named[bucket.SlotGroup] = len(groups)
+		}
+
+		groups = append(groups, slotGroup{
+			name:             bucket.SlotGroup,
+			buckets:          []int{i},
+			collectibleCount: int(bucket.CollectibleCount),
+			totalWeight:      bucket.Weight,
+		})
+	}
+
+	return groups
+}
+
+// draw picks a bucket from the group reading randomness from 'rnd'.
+// A group with a single bucket does not consume randomness.
+func (g slotGroup) draw(buckets []Bucket, rnd io.Reader) (int, error) {
+	if len(g.buckets) == 1 {
+		return g.buckets[0], nil
+	}
+
+	r, err := randomIndex(rnd, int(g.totalWeight))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, i := range g.buckets {
+		if r < int(buckets[i].Weight) {
+			return i, nil
+		}
+		r -= int(buckets[i].Weight)
+	}
+
+	return 0, fmt.Errorf("unable to draw a bucket from slot group '%s'", g.name)
+}
+
+// Odds returns the expected, effective and realized odds of each bucket.
+// Realized odds are available once the distribution has been resolved.
+func (pt PackTemplate) Odds() []BucketOdds {
+	odds := make([]BucketOdds, len(pt.Buckets))
+
+	groups := pt.slotGroups()
+
+	// Share of packs containing each constrained tier. 'exact-packs' fixes
+	// the share, 'every-nth' fixes the required packs while the other packs
+	// draw the tier by its weight.
+	fixed := make(map[string]float64)
+	for _, c := range pt.tierConstraints() {
+		if pt.PackCount == 0 {
+			continue
+		}
+		switch c.Type {
+		case PackConstraintExactPacks:
+			fixed[c.Tier] = float64(c.Count) / float64(pt.PackCount)
+		case PackConstraintEveryNth:
+			if _, ok := fixed[c.Tier]; ok || pt.tierIsMandatory(groups, c.Tier) {
+				continue
+			}
+			required := float64(pt.requiredPacks(c.Tier))
+			drawn := pt.tierWeightShare(groups, c.Tier)
+			fixed[c.Tier] = (required + (float64(pt.PackCount)-required)*drawn) / float64(pt.PackCount)
+		}
+	}
+
+	for _, group := range groups {
+		effective := group.effectiveOdds(pt.Buckets, fixed)
+
+		for _, i := range group.buckets {
+			expected := 1.0
+			if len(group.buckets) > 1 && group.totalWeight > 0 {
+				expected = float64(pt.Buckets[i].Weight) / float64(group.totalWeight)
+			}
+
+			realized := 0.0
+			if pt.PackCount > 0 {
+				realized = float64(pt.Buckets[i].DrawnPackCount) / float64(pt.PackCount)
+			}
+
+			odds[i] = BucketOdds{Expected: expected, Effective: effective[i], Realized: realized}
+		}
+	}
+
+	return odds
+}
+
+// tierWeightShare returns the share of the weight of the slot group 'tier'
+// is drawn in (see PackConstraint.Validate) which belongs to buckets of 'tier'.
+func (pt PackTemplate) tierWeightShare(groups []slotGroup, tier string) float64 {
+	for _, group := range groups {
+		tierWeight := uint(0)
+		for _, b := range group.buckets {
+			if pt.Buckets[b].Tier == tier {
+				tierWeight += pt.Buckets[b].Weight
+			}
+		}
+		if tierWeight > 0 && group.totalWeight > 0 {
+			return float64(tierWeight) / float64(group.totalWeight)
+		}
+	}
+	return 0
+}
+
+// effectiveOdds returns the odds of each bucket of the group (by bucket index)
+// once tier constraints have overridden the draws. A constrained tier
+// is drawn by the share of packs fixed for it ('fixed'), the remaining share
+// is split between the other buckets of the group by their weights.
+// Tiers with constraints are drawn in a single slot group (see PackConstraint.Validate).
+func (g slotGroup) effectiveOdds(buckets []Bucket, fixed map[string]float64) map[int]float64 {
+	res := make(map[int]float64, len(g.buckets))
+
+	if len(g.buckets) == 1 {
+		res[g.buckets[0]] = 1.0
+		return res
+	}
+
+	// Buckets of each constrained tier and of no constrained tier
+	tiers := make(map[string][]int)
+	free := []int{}
+	for _, b := range g.buckets {
+		if _, ok := fixed[buckets[b].Tier]; ok {
+			tiers[buckets[b].Tier] = append(tiers[buckets[b].Tier], b)
+		} else {
+			free = append(free, b)
+		}
+	}
+
+	remaining := 1.0
+	for tier, bb := range tiers {
+		share := fixed[tier]
+		remaining -= share
+		splitByWeight(res, buckets, bb, share)
+	}
+
+	if remaining < 0 {
+		remaining = 0
+	}
+	splitByWeight(res, buckets, free, remaining)
+
+	return res
+}
+
+// splitByWeight splits 'share' between the buckets 'bb' by their weights.
+func splitByWeight(res map[int]float64, buckets []Bucket, bb []int, share float64) {
+	total := uint(0)
+	for _, b := range bb {
+		total += buckets[b].Weight
+	}
+	for _, b := range bb {
+		if total > 0 {
+			res[b] = share * float64(buckets[b].Weight) / float64(total)
+		} else {
+			res[b] = share / float64(len(bb))
+		}
+	}
+}
+
+// resolveCollectibles distributes the collectibles of each bucket into packs
+// reading randomness from 'rnd'.
+// The result is deterministic for a deterministic 'rnd' which allows a
+// verifier to repeat the resolution of a seeded distribution.
+func (pt PackTemplate) resolveCollectibles(rnd io.Reader) (*resolution, error) {
+	packCount := int(pt.PackCount)
+	groups := pt.slotGroups()
+
+	// Generate a slice of random indexes to CollectibleCollection of each bucket
+	perms := make([][]int, len(pt.Buckets))
+	for i, bucket := range pt.Buckets {
+		perm, err := permutation(rnd, len(bucket.CollectibleCollection))
+		if err != nil {
+			return nil, fmt.Errorf("error while shuffling bucket %d: %w", i, err)
+		}
+		perms[i] = perm
+	}
+
+	// Draw the bucket of each slot group for each pack
+	draws := make([][]int, packCount)
+	for p := range draws {
+		draws[p] = make([]int, len(groups))
+		for g, group := range groups {
+			b, err := group.draw(pt.Buckets, rnd)
+			if err != nil {
+				return nil, err
+			}
+			draws[p][g] = b
+		}
+	}
+
+	if err := pt.applyTierConstraints(groups, draws, rnd); err != nil {
+		return nil, err
+	}
+
+	return pt.assign(groups, perms, draws)
+}
+
+// assign fills the slots of each pack with collectibles from the drawn buckets.
+// Collectibles are picked from each bucket in the order of the buckets permutation.
+func (pt PackTemplate) assign(groups []slotGroup, perms [][]int, draws [][]int) (*resolution, error) {
+	packSlotCount, err := pt.PackSlotCount()
+	if err != nil {
+		return nil, err
+	}
+
+	res := &resolution{
+		packs:           make([]Collectibles, len(draws)),
+		drawnPackCounts: make([]uint, len(pt.Buckets)),
+		sources:         make([][]int, len(draws)),
+	}
+
+	// How many collectibles have been picked from each bucket
+	cursors := make([]int, len(pt.Buckets))
+
+	for p := range draws {
+		res.packs[p] = make(Collectibles, 0, packSlotCount)
+		res.sources[p] = make([]int, 0, packSlotCount)
+
+		for g, group := range groups {
+			b := draws[p][g]
+			bucket := pt.Buckets[b]
+
+			if cursors[b]+group.collectibleCount > len(perms[b]) {
+				return nil, fmt.Errorf("bucket %d ran out of collectibles", b)
+			}
+
+			for _, randomIndex := range perms[b][cursors[b] : cursors[b]+group.collectibleCount] {
+				res.packs[p] = append(res.packs[p], Collectible{
+					ContractReference: bucket.CollectibleReference,
+					FlowID:            bucket.CollectibleCollection[randomIndex],
+				})
+				res.sources[p] = append(res.sources[p], b)
+			}
+
+			cursors[b] += group.collectibleCount
+			res.drawnPackCounts[b]++
+		}
+	}
+
+	conflicts, err := pt.applyUniqueConstraints(res, perms, cursors)
+	if err != nil {
+		return nil, err
+	}
+	res.conflicts = conflicts
+
+	return res, nil
+}
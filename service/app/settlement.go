@@ -2,6 +2,7 @@ package app
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/flow-hydraulics/flow-pds/service/common"
 	"github.com/google/uuid"
@@ -19,7 +20,10 @@ type Settlement struct {
 	TotalCount   uint   `gorm:"column:total_count"`
 	StartAtBlock uint64 `gorm:"column:start_at_block"`
 
+	PhaseDeadline
+
 	EscrowAddress common.FlowAddress      `gorm:"column:escrow_address"`
+	ReconciledAt  time.Time               `gorm:"column:reconciled_at"` // When the escrow collections were last read
 	Collectibles  []SettlementCollectible `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
 }
 
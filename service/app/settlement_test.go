@@ -0,0 +1,82 @@
+package app
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/flow-hydraulics/flow-pds/service/common"
+	"github.com/flow-hydraulics/flow-pds/service/transactions"
+	"github.com/google/uuid"
+	"github.com/onflow/cadence"
+	"github.com/onflow/flow-go-sdk"
+	"gorm.io/gorm"
+)
+
+func TestSettleTransactionCollectibles(t *testing.T) {
+	contract := AddressLocation{Name: "ExampleNFT", Address: common.FlowAddress(flow.HexToAddress("0x1"))}
+
+	arguments := []cadence.Value{
+		cadence.UInt64(1),
+		cadence.String(contract.String()),
+		cadence.NewArray([]cadence.Value{cadence.UInt64(5), cadence.UInt64(7)}),
+	}
+
+	tx, err := transactions.NewTransactionWithDistributionID(SETTLE_SCRIPT, []byte{}, arguments, uuid.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	collectibles, err := settleTransactionCollectibles(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Collectibles{
+		{ContractReference: contract, FlowID: common.FlowID{Int64: 5, Valid: true}},
+		{ContractReference: contract, FlowID: common.FlowID{Int64: 7, Valid: true}},
+	}
+
+	if !reflect.DeepEqual(collectibles, expected) {
+		t.Errorf("expected %v, got %v", expected, collectibles)
+	}
+}
+
+func TestSettlementContracts(t *testing.T) {
+	db := newTestDB(t)
+
+	contractA := AddressLocation{Name: "A", Address: common.FlowAddress(flow.HexToAddress("0x01"))}
+	contractB := AddressLocation{Name: "B", Address: common.FlowAddress(flow.HexToAddress("0x01"))}
+
+	settlement := Settlement{
+		DistributionID: uuid.New(),
+		Collectibles: []SettlementCollectible{
+			{FlowID: common.FlowID{Int64: 1, Valid: true}, ContractReference: contractA},
+			{FlowID: common.FlowID{Int64: 2, Valid: true}, ContractReference: contractA},
+			{FlowID: common.FlowID{Int64: 1, Valid: true}, ContractReference: contractB, IsSettled: true},
+		},
+	}
+	if err := db.Create(&settlement).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	contracts, err := SettlementContracts(db, settlement.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(contracts, []AddressLocation{contractA}) {
+		t.Errorf("expected only contract A, got %v", contracts)
+	}
+
+	collectible, err := GetNotSettledCollectible(db, settlement.ID, contractA, common.FlowID{Int64: 2, Valid: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if collectible.FlowID.Int64 != 2 || collectible.ContractReference != contractA {
+		t.Errorf("unexpected collectible %v", collectible)
+	}
+
+	if _, err := GetNotSettledCollectible(db, settlement.ID, contractB, common.FlowID{Int64: 1, Valid: true}); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected settled collectible not to be found, got %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/flow-hydraulics/flow-pds/service/common"
+)
+
+const SHUFFLE_SEED_LENGTH_IN_BYTES = 32 // 256-bit
+
+// ShuffleMode defines where the randomness used to resolve a distribution comes from.
+type ShuffleMode string
+
+const (
+	// ShuffleModeRandom draws every permutation directly from crypto/rand.
+	ShuffleModeRandom ShuffleMode = "random"
+	// ShuffleModeSeeded derives every permutation from a secret seed and
+	// stores a commitment (sha256) of the seed with the distribution.
+	// Once the seed is published anyone can repeat the shuffle.
+	ShuffleModeSeeded ShuffleMode = "seeded"
+	// ShuffleModeVerifiable commits to a secret seed when the distribution is
+	// created and mixes in the ID of a later Flow block as public entropy.
+	// The distribution is resolved only after the entropy block is sealed,
+	// so the service can not choose the outcome of the shuffle.
+	ShuffleModeVerifiable ShuffleMode = "verifiable"
+)
+
+func (m ShuffleMode) Validate() error {
+	switch m {
+	case "", ShuffleModeRandom, ShuffleModeSeeded, ShuffleModeVerifiable:
+		return nil
+	default:
+		return fmt.Errorf("unknown shuffle mode '%s'", m)
+	}
+}
+
+// ShuffleSeedCommitment returns the commitment (sha256) of a shuffle seed.
+func ShuffleSeedCommitment(seed []byte) common.BinaryValue {
+	hash := sha256.Sum256(seed)
+	return hash[:]
+}
+
+// VerifiableShuffleSeed combines the committed seed with the public entropy
+// (a Flow block ID) into the seed used to shuffle a verifiable distribution:
+// sha256(seed || blockID).
+func VerifiableShuffleSeed(seed, blockID []byte) []byte {
+	input := make([]byte, 0, len(seed)+len(blockID))
+	input = append(input, seed...)
+	input = append(input, blockID...)
+	hash := sha256.Sum256(input)
+	return hash[:]
+}
+
+// seededReader is a deterministic stream of bytes derived from a seed.
+// Block n of the stream is sha256(seed || n) where n is a big endian uint64.
+// The construction is intentionally simple so that a third party can
+// reimplement it to verify a shuffle.
+type seededReader struct {
+	seed    []byte
+	counter uint64
+	buf     []byte
+}
+
+func newSeededReader(seed []byte) *seededReader {
+	return &seededReader{seed: seed}
+}
+
+func (r *seededReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(r.buf) == 0 {
+			input := make([]byte, len(r.seed)+8)
+			copy(input, r.seed)
+			binary.BigEndian.PutUint64(input[len(r.seed):], r.counter)
+			block := sha256.Sum256(input)
+			r.buf = block[:]
+			r.counter++
+		}
+		c := copy(p[n:], r.buf)
+		r.buf = r.buf[c:]
+		n += c
+	}
+	return n, nil
+}
+
+// randomIndex returns a uniformly distributed integer in [0, n) read from 'rnd'.
+// It reads 8 bytes at a time as a big endian uint64 and uses rejection
+// sampling to avoid modulo bias.
+func randomIndex(rnd io.Reader, n int) (int, error) {
+	if n <= 0 {
+		return 0, fmt.Errorf("invalid range for random index: %d", n)
+	}
+
+	max := uint64(n)
+	limit := math.MaxUint64 - math.MaxUint64%max
+	b := make([]byte, 8)
+
+	for {
+		if _, err := io.ReadFull(rnd, b); err != nil {
+			return 0, fmt.Errorf("error while reading randomness: %w", err)
+		}
+		v := binary.BigEndian.Uint64(b)
+		if v < limit {
+			return int(v % max), nil
+		}
+	}
+}
+
+// permutation returns a random permutation of [0, n) using Fisher-Yates
+// shuffle with randomness read from 'rnd'.
+func permutation(rnd io.Reader, n int) ([]int, error) {
+	perm := make([]int, n)
+	for i := range perm {
+		perm[i] = i
+	}
+
+	for i := n - 1; i > 0; i-- {
+		j, err := randomIndex(rnd, i+1)
+		if err != nil {
+			return nil, err
+		}
+		perm[i], perm[j] = perm[j], perm[i]
+	}
+
+	return perm, nil
+}
+
+// CommitShuffleSeed generates a new shuffle seed (unless already set) and
+// stores its commitment in the distribution.
+func (dist *Distribution) CommitShuffleSeed() error {
+	if dist.ShuffleSeed.IsEmpty() {
+		seed, err := common.GenerateRandomBytes(SHUFFLE_SEED_LENGTH_IN_BYTES)
+		if err != nil {
+			return err
+		}
+		dist.ShuffleSeed = seed
+	}
+
+	dist.ShuffleSeedCommitment = ShuffleSeedCommitment(dist.ShuffleSeed)
+
+	return nil
+}
+
+// shuffleSource returns the source of randomness for resolving the distribution.
+// For ShuffleModeSeeded a new seed is generated (unless already set) and its
+// commitment stored in the distribution.
+// For ShuffleModeVerifiable the seed must already be committed and the entropy
+// block set.
+func (dist *Distribution) shuffleSource() (io.Reader, error) {
+	switch dist.ShuffleMode {
+	case ShuffleModeSeeded:
+		if err := dist.CommitShuffleSeed(); err != nil {
+			return nil, err
+		}
+		return newSeededReader(dist.ShuffleSeed), nil
+	case ShuffleModeVerifiable:
+		if dist.ShuffleSeed.IsEmpty() || dist.ShuffleSeedCommitment.IsEmpty() {
+			return nil, fmt.Errorf("shuffle seed not committed")
+		}
+		if dist.EntropyBlockID.IsEmpty() {
+			return nil, fmt.Errorf("entropy block not set")
+		}
+		return newSeededReader(VerifiableShuffleSeed(dist.ShuffleSeed, dist.EntropyBlockID)), nil
+	default:
+		return rand.Reader, nil
+	}
+}
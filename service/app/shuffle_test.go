@@ -0,0 +1,233 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/flow-hydraulics/flow-pds/service/common"
+	"github.com/onflow/flow-go-sdk"
+)
+
+func TestSeededDistributionResolution(t *testing.T) {
+	collection := makeCollection(100)
+
+	collectibleRef := AddressLocation{
+		Name:    "TestCollectibleNFT",
+		Address: common.FlowAddress(flow.HexToAddress("0x2")),
+	}
+
+	makeDist := func(seed common.BinaryValue) Distribution {
+		return Distribution{
+			State:       common.DistributionStateInit,
+			FlowID:      common.FlowID{Int64: int64(1), Valid: true},
+			Issuer:      common.FlowAddress(flow.HexToAddress("0x1")),
+			ShuffleMode: ShuffleModeSeeded,
+			ShuffleSeed: seed,
+			PackTemplate: PackTemplate{
+				PackReference: AddressLocation{
+					Name:    "TestPackNFT",
+					Address: common.FlowAddress(flow.HexToAddress("0x2")),
+				},
+				PackCount: 4,
+				Buckets: []Bucket{
+					{
+						CollectibleReference:  collectibleRef,
+						CollectibleCount:      5,
+						CollectibleCollection: collection,
+					},
+				},
+			},
+		}
+	}
+
+	d1 := makeDist(nil)
+	if err := d1.Resolve(); err != nil {
+		t.Fatalf("didn't expect an error, got %s", err)
+	}
+
+	if d1.ShuffleSeed.IsEmpty() {
+		t.Fatal("expected a shuffle seed to be generated")
+	}
+
+	if !reflect.DeepEqual(d1.ShuffleSeedCommitment, ShuffleSeedCommitment(d1.ShuffleSeed)) {
+		t.Fatal("expected shuffle seed commitment to match the seed")
+	}
+
+	if d1.RevealedShuffleSeed(0) != nil {
+		t.Fatal("expected shuffle seed to stay private before the distribution is complete")
+	}
+
+	d1.State = common.DistributionStateComplete
+	if d1.RevealedShuffleSeed(1) != nil {
+		t.Fatal("expected shuffle seed to stay private while a pack is unrevealed")
+	}
+	if !reflect.DeepEqual(d1.RevealedShuffleSeed(0), d1.ShuffleSeed) {
+		t.Fatal("expected shuffle seed to be published once every pack is revealed")
+	}
+
+	// Resolving again with the same seed should yield the same packs
+	d2 := makeDist(d1.ShuffleSeed)
+	if err := d2.Resolve(); err != nil {
+		t.Fatalf("didn't expect an error, got %s", err)
+	}
+
+	for i := range d1.Packs {
+		if !reflect.DeepEqual(d1.Packs[i].Collectibles, d2.Packs[i].Collectibles) {
+			t.Fatalf("expected pack %d to have equal collectibles with the same seed", i)
+		}
+	}
+}
+
+func TestPermutation(t *testing.T) {
+	n := 50
+
+	perm, err := permutation(newSeededReader([]byte("seed")), n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[int]bool, n)
+	for _, v := range perm {
+		if v < 0 || v >= n || seen[v] {
+			t.Fatalf("not a permutation: %v", perm)
+		}
+		seen[v] = true
+	}
+}
+
+func TestVerifiableDistributionResolution(t *testing.T) {
+	collection := makeCollection(100)
+
+	collectibleRef := AddressLocation{
+		Name:    "TestCollectibleNFT",
+		Address: common.FlowAddress(flow.HexToAddress("0x2")),
+	}
+
+	d := Distribution{
+		State:       common.DistributionStateInit,
+		FlowID:      common.FlowID{Int64: int64(1), Valid: true},
+		Issuer:      common.FlowAddress(flow.HexToAddress("0x1")),
+		ShuffleMode: ShuffleModeVerifiable,
+		PackTemplate: PackTemplate{
+			PackReference: AddressLocation{
+				Name:    "TestPackNFT",
+				Address: common.FlowAddress(flow.HexToAddress("0x2")),
+			},
+			PackCount: 4,
+			Buckets: []Bucket{
+				{
+					CollectibleReference:  collectibleRef,
+					CollectibleCount:      3,
+					CollectibleCollection: collection[:50],
+				},
+				{
+					CollectibleReference:  collectibleRef,
+					CollectibleCount:      2,
+					CollectibleCollection: collection[50:],
+				},
+			},
+		},
+	}
+
+	if err := d.Resolve(); err == nil {
+		t.Fatal("expected an error when resolving without a committed seed")
+	}
+
+	if err := d.CommitShuffle(); err != nil {
+		t.Fatalf("didn't expect an error, got %s", err)
+	}
+
+	onchain := &ShuffleCommitment{
+		DistFlowID:         d.FlowID,
+		Commitment:         d.ShuffleSeedCommitment,
+		BlockHeight:        100,
+		EntropyBlockHeight: 110,
+	}
+
+	if err := d.FixEntropyBlock("commitment tx", onchain.EntropyBlockHeight); err != nil {
+		t.Fatalf("didn't expect an error, got %s", err)
+	}
+
+	if err := d.FixEntropyBlock("commitment tx", 120); err == nil {
+		t.Fatal("expected the entropy block to stay fixed")
+	}
+
+	// ID of the sealed block at the entropy block height, as read from chain by the verifier
+	entropyBlockID := common.BinaryValue("entropy block id")
+
+	if err := d.ResolveWithEntropyBlock(entropyBlockID); err != nil {
+		t.Fatalf("didn't expect an error, got %s", err)
+	}
+
+	d.State = common.DistributionStateComplete
+
+	revealed := []Pack{d.Packs[1], d.Packs[3]}
+
+	hidden, err := ShuffleProofFromDistribution(&d, revealed, int64(len(d.Packs)-len(revealed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hidden.ShuffleSeed.IsEmpty() {
+		t.Fatal("expected shuffle seed to stay private while packs are unrevealed")
+	}
+	if len(hidden.RevealedPacks) != 0 {
+		t.Fatal("expected pack indexes to stay private while packs are unrevealed")
+	}
+
+	proof, err := ShuffleProofFromDistribution(&d, revealed, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := VerifyShuffle(*proof, onchain, entropyBlockID); err != nil {
+		t.Fatalf("expected shuffle to verify, got %s", err)
+	}
+
+	// The commitment has to match the one published onchain
+	if _, err := VerifyShuffle(*proof, nil, entropyBlockID); err == nil {
+		t.Fatal("expected verification to fail without the onchain commitment")
+	}
+	other := *onchain
+	other.Commitment = ShuffleSeedCommitment([]byte("other seed"))
+	if _, err := VerifyShuffle(*proof, &other, entropyBlockID); err == nil {
+		t.Fatal("expected verification to fail with a different onchain commitment")
+	}
+
+	// The entropy block has to be the one fixed onchain with the commitment
+	later := *onchain
+	later.EntropyBlockHeight = onchain.EntropyBlockHeight + 1
+	if _, err := VerifyShuffle(*proof, &later, entropyBlockID); err == nil {
+		t.Fatal("expected verification to fail with an entropy block not fixed onchain")
+	}
+	early := *onchain
+	early.BlockHeight = onchain.EntropyBlockHeight
+	if _, err := VerifyShuffle(*proof, &early, entropyBlockID); err == nil {
+		t.Fatal("expected verification to fail with an entropy block preceding the commitment")
+	}
+
+	// Tampering with the entropy should break verification
+	tampered := *proof
+	tampered.EntropyBlockID = common.BinaryValue("another block id")
+	if _, err := VerifyShuffle(tampered, onchain, entropyBlockID); err == nil {
+		t.Fatal("expected verification to fail with different entropy")
+	}
+
+	// The entropy block ID of the proof has to match the block read from chain
+	if _, err := VerifyShuffle(*proof, onchain, nil); err == nil {
+		t.Fatal("expected verification to fail without the onchain entropy block ID")
+	}
+	if _, err := VerifyShuffle(*proof, onchain, common.BinaryValue("another block id")); err == nil {
+		t.Fatal("expected verification to fail with an entropy block ID not matching the chain")
+	}
+	if _, err := VerifyShuffle(tampered, onchain, tampered.EntropyBlockID); err == nil {
+		t.Fatal("expected verification to fail with an entropy block ID the packs were not resolved with")
+	}
+
+	// Tampering with a pack should break verification
+	tampered = *proof
+	tampered.RevealedPacks = []RevealedPack{proof.RevealedPacks[0]}
+	tampered.RevealedPacks[0].Index = 0
+	if _, err := VerifyShuffle(tampered, onchain, entropyBlockID); err == nil {
+		t.Fatal("expected verification to fail for a pack at the wrong index")
+	}
+}
@@ -20,3 +20,8 @@ func ParseListOptions(limit, offset int) ListOptions {
 	}
 	return ListOptions{Limit: limit, Offset: offset}
 }
+
+// DistributionFilter narrows down a list of distributions.
+type DistributionFilter struct {
+	Metadata map[string]string // Only distributions with all of these metadata key-value pairs
+}
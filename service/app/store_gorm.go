@@ -1,6 +1,9 @@
 package app
 
 import (
+	"errors"
+	"strings"
+
 	"github.com/flow-hydraulics/flow-pds/service/common"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
@@ -8,21 +11,53 @@ import (
 )
 
 func Migrate(db *gorm.DB) error {
-	if err := db.AutoMigrate(&Distribution{}, &Bucket{}, &Pack{}); err != nil {
+	backfillCollectibles := !db.Migrator().HasTable(&DistributionCollectible{})
+	if err := db.AutoMigrate(&Distribution{}, &Bucket{}, &Pack{}, &DistributionMetadata{}, &DistributionCollectible{}); err != nil {
 		return err
 	}
+	if backfillCollectibles {
+		if err := backfillDistributionCollectibles(db); err != nil {
+			return err
+		}
+	}
 	if err := db.AutoMigrate(&Settlement{}, &SettlementCollectible{}); err != nil {
 		return err
 	}
 	if err := db.AutoMigrate(&Minting{}); err != nil {
 		return err
 	}
+	if err := db.AutoMigrate(&Refund{}, &RefundCollectible{}); err != nil {
+		return err
+	}
+	if err := db.AutoMigrate(&EventCursor{}, &ProcessedEvent{}, &DeadLetterEvent{}); err != nil {
+		return err
+	}
+	if err := db.AutoMigrate(&BatchSize{}); err != nil {
+		return err
+	}
 	if err := db.AutoMigrate(&CirculatingPackContract{}); err != nil {
 		return err
 	}
 	return nil
 }
 
+// Collectibles of distributions registered before they were stored in a table
+// of their own are read from the buckets once
+func backfillDistributionCollectibles(db *gorm.DB) error {
+	buckets := []Bucket{}
+	return db.Omit(clause.Associations).FindInBatches(&buckets, 100, func(tx *gorm.DB, batch int) error {
+		for _, b := range buckets {
+			if len(b.CollectibleCollection) == 0 {
+				continue
+			}
+			if err := db.CreateInBatches(b.distributionCollectibles(), 1000).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
+
 // Insert distribution
 func InsertDistribution(db *gorm.DB, d *Distribution, batchSize int) error {
 	return db.Transaction(func(tx *gorm.DB) error {
@@ -50,11 +85,50 @@ func InsertDistribution(db *gorm.DB, d *Distribution, batchSize int) error {
 			return err
 		}
 
+		// Store collectibles in batches
+		for _, b := range d.PackTemplate.Buckets {
+			if len(b.CollectibleCollection) == 0 {
+				continue
+			}
+			if err := tx.CreateInBatches(b.distributionCollectibles(), batchSize).Error; err != nil {
+				return err
+			}
+		}
+
+		// Store metadata
+		if len(d.Metadata) > 0 {
+			for i := range d.Metadata {
+				d.Metadata[i].DistributionID = d.ID
+			}
+			if err := tx.Omit(clause.Associations).Create(d.Metadata).Error; err != nil {
+				return err
+			}
+		}
+
 		// Commit
 		return nil
 	})
 }
 
+// Insert the packs of a distribution, used when a distribution is resolved
+// after it has been stored
+func InsertDistributionPacks(db *gorm.DB, d *Distribution, batchSize int) error {
+	for i := range d.Packs {
+		d.Packs[i].DistributionID = d.ID
+	}
+	return db.Omit(clause.Associations).CreateInBatches(d.Packs, batchSize).Error
+}
+
+// Update the buckets of a distribution
+func UpdateDistributionBuckets(db *gorm.DB, d *Distribution) error {
+	for i := range d.PackTemplate.Buckets {
+		if err := db.Omit(clause.Associations).Save(&d.PackTemplate.Buckets[i]).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Update distribution
 // Note: this will not update nested objects (Buckets, Packs)
 func UpdateDistribution(db *gorm.DB, d *Distribution) error {
@@ -64,9 +138,14 @@ func UpdateDistribution(db *gorm.DB, d *Distribution) error {
 }
 
 // List distributions
-func ListDistributions(db *gorm.DB, opt ListOptions) ([]Distribution, error) {
+func ListDistributions(db *gorm.DB, opt ListOptions, filter DistributionFilter) ([]Distribution, error) {
 	list := []Distribution{}
-	if err := db.Omit(clause.Associations).Order("created_at desc").Limit(opt.Limit).Offset(opt.Offset).Find(&list).Error; err != nil {
+	q := db.Omit(clause.Associations).Preload("Metadata")
+	for k, v := range filter.Metadata {
+		matching := db.Model(&DistributionMetadata{}).Select("distribution_id").Where(&DistributionMetadata{Key: k, Value: v})
+		q = q.Where("id IN (?)", matching)
+	}
+	if err := q.Order("created_at desc").Limit(opt.Limit).Offset(opt.Offset).Find(&list).Error; err != nil {
 		return nil, err
 	}
 	return list, nil
@@ -81,6 +160,15 @@ func GetDistributionBig(db *gorm.DB, id uuid.UUID) (*Distribution, error) {
 	return &distribution, nil
 }
 
+// Get distribution by its onchain ID
+func GetDistributionByFlowID(db *gorm.DB, flowID common.FlowID) (*Distribution, error) {
+	distribution := Distribution{}
+	if err := db.Omit(clause.Associations).Where(&Distribution{FlowID: flowID}).First(&distribution).Error; err != nil {
+		return nil, err
+	}
+	return &distribution, nil
+}
+
 func GetDistributionSmall(db *gorm.DB, id uuid.UUID) (*Distribution, error) {
 	distribution := Distribution{}
 	if err := db.Omit(clause.Associations).First(&distribution, id).Error; err != nil {
@@ -102,6 +190,22 @@ func GetDistributionBucketsSmall(db *gorm.DB, distributionID uuid.UUID) ([]Bucke
 	return list, nil
 }
 
+// List the collectibles of a contract with the given IDs which are included in
+// distributions that still reserve them, meaning they have not completed or been invalidated
+func ListReservedCollectibles(db *gorm.DB, excludeDistributionID uuid.UUID, contract AddressLocation, ids []common.FlowID) ([]DistributionCollectible, error) {
+	live := db.Model(&Distribution{}).Select("id").Where("state NOT IN ?", []common.DistributionState{
+		common.DistributionStateComplete,
+		common.DistributionStateInvalid,
+	})
+	list := []DistributionCollectible{}
+	if err := db.Where(&DistributionCollectible{ContractReference: contract}).
+		Where("flow_id IN ? AND distribution_id IN (?) AND distribution_id <> ?", ids, live, excludeDistributionID).
+		Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
 // Get pack
 func GetPack(db *gorm.DB, id uuid.UUID) (*Pack, error) {
 	pack := Pack{}
@@ -111,6 +215,62 @@ func GetPack(db *gorm.DB, id uuid.UUID) (*Pack, error) {
 	return &pack, nil
 }
 
+// List packs of a distribution, optionally only packs in the given state.
+// Ordered by Flow ID and random ID, the pack index and insertion order would
+// leak the resolution order.
+func ListDistributionPacks(db *gorm.DB, distributionID uuid.UUID, state common.PackState, opt ListOptions) ([]Pack, error) {
+	list := []Pack{}
+	q := db.Omit(clause.Associations).Where(&Pack{DistributionID: distributionID})
+	if state != "" {
+		q = q.Where(&Pack{State: state})
+	}
+	if err := q.Order("flow_id asc").Order("id asc").Limit(opt.Limit).Offset(opt.Offset).Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Count the packs of a distribution by state
+func CountDistributionPacksByState(db *gorm.DB, distributionID uuid.UUID) (map[common.PackState]int64, error) {
+	rows := []struct {
+		State common.PackState
+		Count int64
+	}{}
+	if err := db.Model(&Pack{}).
+		Select("state, count(*) as count").
+		Where(&Pack{DistributionID: distributionID}).
+		Group("state").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	res := make(map[common.PackState]int64, len(rows))
+	for _, r := range rows {
+		res[r.State] = r.Count
+	}
+	return res, nil
+}
+
+// List packs of a distribution which have been revealed onchain
+func ListRevealedPacks(db *gorm.DB, distributionID uuid.UUID) ([]Pack, error) {
+	list := []Pack{}
+	if err := db.Omit(clause.Associations).
+		Where("distribution_id = ? AND state IN ?", distributionID, revealedPackStates).
+		Order("flow_id asc").
+		Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Count packs of a distribution which have not been revealed onchain
+func CountUnrevealedPacks(db *gorm.DB, distributionID uuid.UUID) (int64, error) {
+	var count int64
+	err := db.Model(&Pack{}).
+		Where("distribution_id = ? AND state NOT IN ?", distributionID, revealedPackStates).
+		Count(&count).Error
+	return count, err
+}
+
 // Get Packs for a Distribution and process in batches of 'batchSize'
 func DistributionPacksInBatches(db *gorm.DB, distributionID uuid.UUID, batchSize int, processBatch func(tx *gorm.DB, batchNumber int, batch []Pack) error) error {
 	batch := []Pack{}
@@ -122,10 +282,23 @@ func DistributionPacksInBatches(db *gorm.DB, distributionID uuid.UUID, batchSize
 		}).Error
 }
 
-// GetMintingPack returns a pack which has no FlowID by its commitmentHash (therefore it should still be minting)
-func GetMintingPack(db *gorm.DB, commitmentHash common.BinaryValue) (*Pack, error) {
+// Get Packs of a distribution which have not been minted (have no FlowID) and process in batches of 'batchSize'
+func NotMintedPacksInBatches(db *gorm.DB, distributionID uuid.UUID, batchSize int, processBatch func(tx *gorm.DB, batchNumber int, batch []Pack) error) error {
+	batch := []Pack{}
+	return db.
+		Omit(clause.Associations).
+		Where(&Pack{DistributionID: distributionID}).
+		Where("flow_id IS NULL").
+		FindInBatches(&batch, batchSize, func(tx *gorm.DB, batchNumber int) error {
+			return processBatch(tx, batchNumber, batch)
+		}).Error
+}
+
+// GetMintingPack returns a pack of a distribution which has no FlowID by its commitmentHash (therefore it should still be minting)
+func GetMintingPack(db *gorm.DB, distributionID uuid.UUID, commitmentHash common.BinaryValue) (*Pack, error) {
 	pack := Pack{}
-	if err := db.Where(&Pack{CommitmentHash: commitmentHash, FlowID: common.FlowID{Valid: false}}).First(&pack).Error; err != nil {
+	// Zero values are ignored in struct conditions, so the FlowID condition has to be explicit
+	if err := db.Where(&Pack{DistributionID: distributionID, CommitmentHash: commitmentHash}).Where("flow_id IS NULL").First(&pack).Error; err != nil {
 		return nil, err
 	}
 	return &pack, nil
@@ -236,3 +409,157 @@ func GetDistributionMinting(db *gorm.DB, distributionID uuid.UUID) (*Minting, er
 func UpdateMinting(db *gorm.DB, d *Minting) error {
 	return db.Omit(clause.Associations).Save(d).Error
 }
+
+// Insert Refund
+func InsertRefund(db *gorm.DB, d *Refund) error {
+	return db.Omit(clause.Associations).Create(d).Error
+}
+
+func InsertRefundCollectibles(db *gorm.DB, cc []RefundCollectible, batchSize int) error {
+	return db.Omit(clause.Associations).CreateInBatches(cc, batchSize).Error
+}
+
+// Get Refund
+func GetDistributionRefund(db *gorm.DB, distributionID uuid.UUID) (*Refund, error) {
+	refund := Refund{}
+	if err := db.Omit(clause.Associations).Where(&Refund{DistributionID: distributionID}).First(&refund).Error; err != nil {
+		return nil, err
+	}
+	return &refund, nil
+}
+
+// Update Refund
+func UpdateRefund(db *gorm.DB, d *Refund) error {
+	return db.Omit(clause.Associations).Save(d).Error
+}
+
+// Update Refund collectible
+func UpdateRefundCollectible(db *gorm.DB, d *RefundCollectible) error {
+	return db.Omit(clause.Associations).Save(d).Error
+}
+
+// Get RefundCollectibles that have not been refunded for a Refund and process in batches of 'batchSize'
+func NotRefundedCollectiblesInBatches(db *gorm.DB, refundId uuid.UUID, batchSize int, processBatch func(tx *gorm.DB, batchNumber int, batch RefundCollectibles) error) error {
+	batch := RefundCollectibles{}
+	return db.
+		Omit(clause.Associations).
+		Where(&RefundCollectible{RefundID: refundId, IsRefunded: false}).
+		FindInBatches(&batch, batchSize, func(tx *gorm.DB, batchNumber int) error {
+			return processBatch(tx, batchNumber, batch)
+		}).Error
+}
+
+// GetOrCreateEventCursor returns the cursor of an owner for the given events
+// of a contract, creating it at 'startAtBlock' if it does not exist.
+func GetOrCreateEventCursor(db *gorm.DB, ownerID uuid.UUID, contract AddressLocation, eventNames []string, startAtBlock, rangeSize uint64) (*EventCursor, error) {
+	cursor := EventCursor{}
+	err := db.
+		Where(&EventCursor{OwnerID: ownerID, Contract: contract, EventNames: strings.Join(eventNames, ",")}).
+		First(&cursor).Error
+	if err == nil {
+		return &cursor, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	cursor = EventCursor{
+		OwnerID:    ownerID,
+		Contract:   contract,
+		EventNames: strings.Join(eventNames, ","),
+		LastBlock:  startAtBlock,
+		RangeSize:  rangeSize,
+	}
+
+	return &cursor, db.Create(&cursor).Error
+}
+
+// Update EventCursor
+func UpdateEventCursor(db *gorm.DB, c *EventCursor) error {
+	return db.Omit(clause.Associations).Save(c).Error
+}
+
+// RewindEventCursors moves the cursors of an owner back to 'lastBlock' if
+// they are ahead of it. Events already handled are skipped when replayed.
+func RewindEventCursors(db *gorm.DB, ownerID uuid.UUID, lastBlock uint64) error {
+	return db.Model(&EventCursor{}).
+		Where(&EventCursor{OwnerID: ownerID}).
+		Where("last_block > ?", lastBlock).
+		Update("last_block", lastBlock).Error
+}
+
+// IsEventProcessed tells whether an event has already been handled for a cursor.
+func IsEventProcessed(db *gorm.DB, cursorID uuid.UUID, transactionID string, eventIndex uint) (bool, error) {
+	var count int64
+	err := db.Model(&ProcessedEvent{}).
+		Where(&ProcessedEvent{CursorID: cursorID, TransactionID: transactionID, EventIndex: eventIndex}).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// Insert ProcessedEvent
+func InsertProcessedEvent(db *gorm.DB, e *ProcessedEvent) error {
+	return db.Omit(clause.Associations).Create(e).Error
+}
+
+// Insert DeadLetterEvent
+func InsertDeadLetterEvent(db *gorm.DB, e *DeadLetterEvent) error {
+	return db.Omit(clause.Associations).Create(e).Error
+}
+
+// ListDeadLetterEvents lists the events of a cursor which could not be handled.
+func ListDeadLetterEvents(db *gorm.DB, cursorID uuid.UUID) ([]DeadLetterEvent, error) {
+	list := []DeadLetterEvent{}
+	return list, db.Where(&DeadLetterEvent{CursorID: cursorID}).Order("block_height, id").Find(&list).Error
+}
+
+// SettlementContracts lists the contracts of the collectibles of a settlement which have not been settled.
+func SettlementContracts(db *gorm.DB, settlementID uuid.UUID) ([]AddressLocation, error) {
+	list := []AddressLocation{}
+	return list, db.Model(&SettlementCollectible{}).
+		Select("DISTINCT contract_ref_name AS name, contract_ref_address AS address").
+		Where(&SettlementCollectible{SettlementID: settlementID}).
+		Where("is_settled = ?", false).
+		Find(&list).Error
+}
+
+// GetNotSettledCollectible returns a collectible of a settlement which has not been settled.
+func GetNotSettledCollectible(db *gorm.DB, settlementID uuid.UUID, contract AddressLocation, flowID common.FlowID) (*SettlementCollectible, error) {
+	c := SettlementCollectible{}
+	err := db.
+		Where(&SettlementCollectible{SettlementID: settlementID, ContractReference: contract, FlowID: flowID}).
+		Where("is_settled = ?", false).
+		First(&c).Error
+	return &c, err
+}
+
+// RefundContracts lists the contracts of the collectibles of a refund which have not been refunded.
+func RefundContracts(db *gorm.DB, refundID uuid.UUID) ([]AddressLocation, error) {
+	list := []AddressLocation{}
+	return list, db.Model(&RefundCollectible{}).
+		Select("DISTINCT contract_ref_name AS name, contract_ref_address AS address").
+		Where(&RefundCollectible{RefundID: refundID}).
+		Where("is_refunded = ?", false).
+		Find(&list).Error
+}
+
+// GetNotRefundedCollectible returns a collectible of a refund which has not been refunded.
+func GetNotRefundedCollectible(db *gorm.DB, refundID uuid.UUID, contract AddressLocation, flowID common.FlowID) (*RefundCollectible, error) {
+	c := RefundCollectible{}
+	err := db.
+		Where(&RefundCollectible{RefundID: refundID, ContractReference: contract, FlowID: flowID}).
+		Where("is_refunded = ?", false).
+		First(&c).Error
+	return &c, err
+}
+
+// GetBatchSize returns the batch size learned for a transaction template and contract.
+func GetBatchSize(db *gorm.DB, name string, contract AddressLocation) (*BatchSize, error) {
+	b := BatchSize{}
+	return &b, db.Where(&BatchSize{Name: name, Contract: contract}).First(&b).Error
+}
+
+// Insert or update BatchSize
+func SaveBatchSize(db *gorm.DB, b *BatchSize) error {
+	return db.Omit(clause.Associations).Save(b).Error
+}
@@ -3,6 +3,7 @@ package app
 import (
 	"fmt"
 
+	"github.com/flow-hydraulics/flow-pds/service/common"
 	"github.com/onflow/flow-go-sdk"
 )
 
@@ -15,6 +16,10 @@ func (dist Distribution) Validate() error {
 		return fmt.Errorf("distribution issuer must be defined")
 	}
 
+	if err := dist.ShuffleMode.Validate(); err != nil {
+		return err
+	}
+
 	if err := dist.PackTemplate.Validate(); err != nil {
 		return fmt.Errorf("error while validating pack template: %w", err)
 	}
@@ -40,6 +45,7 @@ func (pt PackTemplate) Validate() error {
 			return fmt.Errorf("error in bucket %d: %w", i, err)
 		}
 
+		// Worst case: every pack draws from this bucket
 		requiredCount := int(pt.PackCount * bucket.CollectibleCount)
 		allocatedCount := len(bucket.CollectibleCollection)
 		if requiredCount > allocatedCount {
@@ -50,6 +56,36 @@ func (pt PackTemplate) Validate() error {
 		}
 	}
 
+	if duplicates := pt.duplicateCollectibles(); len(duplicates) > 0 {
+		return &DuplicateCollectiblesError{Conflicts: duplicates}
+	}
+
+	groups := pt.slotGroups()
+
+	for _, group := range groups {
+		if len(group.buckets) < 2 {
+			continue
+		}
+		for _, i := range group.buckets {
+			bucket := pt.Buckets[i]
+			if bucket.Weight == 0 {
+				return fmt.Errorf("weight of bucket %d in slot group '%s' can not be zero", i, group.name)
+			}
+			if int(bucket.CollectibleCount) != group.collectibleCount {
+				return fmt.Errorf(
+					"collectible count of bucket %d does not match slot group '%s', required %d got %d",
+					i, group.name, group.collectibleCount, bucket.CollectibleCount,
+				)
+			}
+		}
+	}
+
+	for i, c := range pt.Constraints {
+		if err := c.Validate(pt, groups); err != nil {
+			return fmt.Errorf("error in constraint %d: %w", i, err)
+		}
+	}
+
 	return nil
 }
 
@@ -73,6 +109,22 @@ func (bucket Bucket) Validate() error {
 		)
 	}
 
+	if len(bucket.CollectibleMetadataKeys) > 0 {
+		collection := make(map[common.FlowID]bool, len(bucket.CollectibleCollection))
+		for _, id := range bucket.CollectibleCollection {
+			collection[id] = true
+		}
+		for id := range bucket.CollectibleMetadataKeys {
+			flowID, err := common.FlowIDFromString(id)
+			if err != nil {
+				return fmt.Errorf("invalid metadata key collectible '%s': %w", id, err)
+			}
+			if !collection[flowID] {
+				return fmt.Errorf("metadata key collectible %s not in collection", id)
+			}
+		}
+	}
+
 	return nil
 }
 
@@ -0,0 +1,137 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+
+	"github.com/flow-hydraulics/flow-pds/service/common"
+	"github.com/google/uuid"
+)
+
+// ShuffleProof contains everything needed to recompute the resolution of a
+// seeded or verifiable distribution.
+type ShuffleProof struct {
+	DistributionID        uuid.UUID
+	ShuffleMode           ShuffleMode
+	ShuffleSeedCommitment common.BinaryValue
+	ShuffleSeed           common.BinaryValue // nil until the seed is revealed
+	EntropyBlockHeight    uint64
+	EntropyBlockID        common.BinaryValue
+	CommitmentTxID        string // Flow transaction publishing the seed commitment (verifiable)
+	PackTemplate          PackTemplate
+	RevealedPacks         []RevealedPack // Empty until the seed is revealed
+}
+
+// ShuffleCommitment is the shuffle seed commitment of a verifiable
+// distribution as stored onchain by PDS.
+type ShuffleCommitment struct {
+	DistFlowID         common.FlowID
+	Commitment         common.BinaryValue
+	BlockHeight        uint64 // Height of the block the commitment was published in
+	EntropyBlockHeight uint64 // Height of the block used as public entropy
+}
+
+// RevealedPack is a pack whose contents have been made public onchain.
+type RevealedPack struct {
+	Index        uint
+	FlowID       common.FlowID
+	Collectibles Collectibles
+}
+
+// ShuffleProofFromDistribution returns the ShuffleProof of a distribution.
+// The distribution should be fully hydrated (buckets with collections).
+// 'unrevealed' is the number of packs of the distribution not revealed yet.
+func ShuffleProofFromDistribution(dist *Distribution, revealed []Pack, unrevealed int64) (*ShuffleProof, error) {
+	if dist.ShuffleMode != ShuffleModeSeeded && dist.ShuffleMode != ShuffleModeVerifiable {
+		return nil, fmt.Errorf("distribution shuffle mode '%s' is not verifiable", dist.ShuffleMode)
+	}
+
+	seed := dist.RevealedShuffleSeed(unrevealed)
+
+	revealedPacks := []RevealedPack{}
+	if !seed.IsEmpty() {
+		for _, p := range revealed {
+			revealedPacks = append(revealedPacks, RevealedPack{
+				Index:        p.Index,
+				FlowID:       p.FlowID,
+				Collectibles: p.Collectibles,
+			})
+		}
+	}
+
+	return &ShuffleProof{
+		DistributionID:        dist.ID,
+		ShuffleMode:           dist.ShuffleMode,
+		ShuffleSeedCommitment: dist.ShuffleSeedCommitment,
+		ShuffleSeed:           seed,
+		EntropyBlockHeight:    dist.EntropyBlockHeight,
+		EntropyBlockID:        dist.EntropyBlockID,
+		CommitmentTxID:        dist.CommitmentTxID,
+		PackTemplate:          dist.PackTemplate,
+		RevealedPacks:         revealedPacks,
+	}, nil
+}
+
+// VerifyShuffle recomputes the full assignment of collectibles into packs from
+// the revealed seed and checks that
+// - the seed matches the commitment made when the distribution was created
+// - the commitment matches the one published onchain ('onchain', verifiable)
+// - the entropy block is the one fixed onchain with the commitment (verifiable)
+// - the entropy block ID matches the sealed block at that height ('entropyBlockID', verifiable)
+// - every revealed pack matches the recomputed pack at the same index
+// It returns the recomputed assignment. The onchain commitment and the entropy
+// block ID should be read from chain by the verifier, see
+// ContractService.GetShuffleCommitment and ContractService.GetEntropyBlockID.
+func VerifyShuffle(proof ShuffleProof, onchain *ShuffleCommitment, entropyBlockID common.BinaryValue) ([]Collectibles, error) {
+	if proof.ShuffleSeed.IsEmpty() {
+		return nil, fmt.Errorf("shuffle seed has not been revealed yet")
+	}
+
+	if !bytes.Equal(ShuffleSeedCommitment(proof.ShuffleSeed), proof.ShuffleSeedCommitment) {
+		return nil, fmt.Errorf("shuffle seed does not match the commitment")
+	}
+
+	seed := []byte(proof.ShuffleSeed)
+
+	switch proof.ShuffleMode {
+	case ShuffleModeSeeded:
+	case ShuffleModeVerifiable:
+		if onchain == nil {
+			return nil, fmt.Errorf("onchain shuffle seed commitment required")
+		}
+		if !bytes.Equal(onchain.Commitment, proof.ShuffleSeedCommitment) {
+			return nil, fmt.Errorf("shuffle seed commitment does not match the one published onchain for distribution %s", onchain.DistFlowID)
+		}
+		if onchain.EntropyBlockHeight <= onchain.BlockHeight || proof.EntropyBlockHeight != onchain.EntropyBlockHeight {
+			return nil, fmt.Errorf("entropy block %d does not match the onchain entropy block %d", proof.EntropyBlockHeight, onchain.EntropyBlockHeight)
+		}
+		if entropyBlockID.IsEmpty() {
+			return nil, fmt.Errorf("onchain entropy block ID required")
+		}
+		if !bytes.Equal(entropyBlockID, proof.EntropyBlockID) {
+			return nil, fmt.Errorf("entropy block ID does not match the sealed block %d onchain", proof.EntropyBlockHeight)
+		}
+		seed = VerifiableShuffleSeed(proof.ShuffleSeed, entropyBlockID)
+	default:
+		return nil, fmt.Errorf("shuffle mode '%s' is not verifiable", proof.ShuffleMode)
+	}
+
+	res, err := proof.PackTemplate.resolveCollectibles(newSeededReader(seed))
+	if err != nil {
+		return nil, fmt.Errorf("error while resolving collectibles: %w", err)
+	}
+
+	resolved := res.packs
+
+	for _, p := range proof.RevealedPacks {
+		if int(p.Index) >= len(resolved) {
+			return nil, fmt.Errorf("revealed pack %s index %d out of range", p.FlowID, p.Index)
+		}
+		if !reflect.DeepEqual(resolved[p.Index], p.Collectibles) {
+			return nil, fmt.Errorf("revealed pack %s does not match the recomputed pack at index %d", p.FlowID, p.Index)
+		}
+	}
+
+	return resolved, nil
+}
@@ -3,6 +3,7 @@ package common
 import (
 	"database/sql/driver"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 
 	"github.com/onflow/cadence"
@@ -22,6 +23,19 @@ func (b BinaryValue) MarshalJSON() ([]byte, error) {
 	return []byte(fmt.Sprintf("\"%s\"", b.String())), nil
 }
 
+func (b *BinaryValue) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("error while unmarshaling BinaryValue from 'data []byte': %w", err)
+	}
+	v, err := BinaryValueFromHexString(s)
+	if err != nil {
+		return err
+	}
+	*b = v
+	return nil
+}
+
 func (b BinaryValue) String() string {
 	return hex.EncodeToString(b)
 }
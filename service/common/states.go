@@ -1,18 +1,22 @@
 package common
 
+import "fmt"
+
 type DistributionState string
 type PackState string
 type TransactionState string
 
 const (
-	DistributionStateInit     DistributionState = "init"
-	DistributionStateInvalid  DistributionState = "invalid"
-	DistributionStateResolved DistributionState = "resolved"
-	DistributionStateSetup    DistributionState = "setup"
-	DistributionStateSettling DistributionState = "settling"
-	DistributionStateSettled  DistributionState = "settled"
-	DistributionStateMinting  DistributionState = "minting"
-	DistributionStateComplete DistributionState = "complete"
+	DistributionStateInit      DistributionState = "init"
+	DistributionStateInvalid   DistributionState = "invalid"
+	DistributionStateResolved  DistributionState = "resolved"
+	DistributionStateSetup     DistributionState = "setup"
+	DistributionStateSettling  DistributionState = "settling"
+	DistributionStateSettled   DistributionState = "settled"
+	DistributionStateMinting   DistributionState = "minting"
+	DistributionStateComplete  DistributionState = "complete"
+	DistributionStateRefunding DistributionState = "refunding"
+	DistributionStateStalled   DistributionState = "stalled"
 )
 
 const (
@@ -26,9 +30,50 @@ const (
 )
 
 const (
-	TransactionStateInit     TransactionState = "init"
-	TransactionStateRetry    TransactionState = "retry"
-	TransactionStateSent     TransactionState = "sent"
-	TransactionStateFailed   TransactionState = "failed"
-	TransactionStateComplete TransactionState = "complete"
+	TransactionStateInit      TransactionState = "init"
+	TransactionStateRetry     TransactionState = "retry"
+	TransactionStateSent      TransactionState = "sent"
+	TransactionStateFailed    TransactionState = "failed"
+	TransactionStateComplete  TransactionState = "complete"
+	TransactionStateCancelled TransactionState = "cancelled"
+	TransactionStateSplit     TransactionState = "split" // Replaced by transactions of half the batch each
+)
+
+// OnchainDistState mirrors the DistState enum of the PDS contract.
+type OnchainDistState uint8
+
+const (
+	OnchainDistStateInitialized OnchainDistState = 0
+	OnchainDistStateInvalid     OnchainDistState = 1
+	OnchainDistStateComplete    OnchainDistState = 2
 )
+
+// OnchainState returns the onchain state a distribution should have while
+// in the given offchain state.
+func (s DistributionState) OnchainState() OnchainDistState {
+	switch s {
+	case DistributionStateInvalid, DistributionStateRefunding:
+		return OnchainDistStateInvalid
+	case DistributionStateComplete:
+		return OnchainDistStateComplete
+	default:
+		return OnchainDistStateInitialized
+	}
+}
+
+func (s OnchainDistState) String() string {
+	switch s {
+	case OnchainDistStateInitialized:
+		return "initialized"
+	case OnchainDistStateInvalid:
+		return "invalid"
+	case OnchainDistStateComplete:
+		return "complete"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(s))
+	}
+}
+
+func (s OnchainDistState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"time"
+
 	"github.com/caarlos0/env/v6"
 	"github.com/joho/godotenv"
 	log "github.com/sirupsen/logrus"
@@ -35,7 +37,9 @@ type Config struct {
 	// How many transactions to send per second at max
 	TransactionSendRate int    `env:"FLOW_PDS_SEND_RATE" envDefault:"10"`
 	TransactionGasLimit uint64 `env:"FLOW_PDS_GAS_LIMIT" envDefault:"9999"`
-	// Going much above 40 will cause the transactions to use more than 9999 gas
+	// Going much above 40 will cause the transactions to use more than 9999 gas,
+	// batches running out of gas are split in half and the batch size is lowered
+	// for the contract in question.
 	SettlementBatchSize int `env:"FLOW_PDS_SETTLEMENT_BATCH_SIZE" envDefault:"40"`
 	MintingBatchSize    int `env:"FLOW_PDS_MINTING_BATCH_SIZE" envDefault:"40"`
 
@@ -43,8 +47,45 @@ type Config struct {
 	BatchInsertSize  int `env:"FLOW_PDS_BATCH_INSERT_SIZE" envDefault:"1000"`
 	BatchProcessSize int `env:"FLOW_PDS_BATCH_PROCESS_SIZE" envDefault:"1000"`
 
-	// Maximum number of blocks to query for when fetching events from Flow gateway
+	// Maximum number of blocks to query for when fetching events from Flow gateway,
+	// the range is halved each time the gateway rejects it and grown back on success
 	MaxBlocksPerCheck uint64 `env:"FLOW_PDS_MAX_BLOCKS_PER_CHECK" envDefault:"10"`
+	// How many block ranges to fetch per poll at max when behind the chain
+	MaxRangesPerCheck int `env:"FLOW_PDS_MAX_RANGES_PER_CHECK" envDefault:"20"`
+
+	// How often the escrow collections are read to find settled collectibles
+	// whose Deposit events were missed, zero disables the check
+	EscrowReconcileInterval time.Duration `env:"FLOW_PDS_ESCROW_RECONCILE_INTERVAL" envDefault:"1m"`
+
+	// How many blocks after the block the seed commitment is published in the ID
+	// of a block is used as public entropy when resolving a 'verifiable' distribution,
+	// the height of the entropy block is stored onchain with the commitment
+	EntropyBlockDelay uint64 `env:"FLOW_PDS_ENTROPY_BLOCK_DELAY" envDefault:"10"`
+
+	// -- Deadlines --
+
+	// How long settlement or minting may take after its batches have been queued
+	// before the missing batches are re-queued, zero disables the deadline.
+	// Batches are re-queued right away if any of their transactions have failed.
+	SettlementTimeout time.Duration `env:"FLOW_PDS_SETTLEMENT_TIMEOUT" envDefault:"30m"`
+	MintingTimeout    time.Duration `env:"FLOW_PDS_MINTING_TIMEOUT" envDefault:"30m"`
+	// How many times the missing batches are re-queued before a distribution is set to 'stalled'
+	MaxPhaseRetries int `env:"FLOW_PDS_MAX_PHASE_RETRIES" envDefault:"3"`
+
+	// -- Transaction retries --
+
+	// How many times transactions failing for a transient reason (expired,
+	// rate limited, access node unavailable) are retried and how long to wait
+	// before the first retry, the wait is doubled for each retry after it.
+	// Invalid sequence numbers are retried right away, within the same limit.
+	TransientMaxRetries uint          `env:"FLOW_PDS_TRANSIENT_MAX_RETRIES" envDefault:"10"`
+	TransientRetryDelay time.Duration `env:"FLOW_PDS_TRANSIENT_RETRY_DELAY" envDefault:"5s"`
+	// Same for transactions failing deterministically in Cadence, these
+	// usually fail again so they are not retried by default
+	CadenceMaxRetries uint          `env:"FLOW_PDS_CADENCE_MAX_RETRIES" envDefault:"0"`
+	CadenceRetryDelay time.Duration `env:"FLOW_PDS_CADENCE_RETRY_DELAY" envDefault:"1m"`
+	// Maximum wait between retries
+	MaxRetryDelay time.Duration `env:"FLOW_PDS_MAX_RETRY_DELAY" envDefault:"10m"`
 
 	// -- Testing --
 
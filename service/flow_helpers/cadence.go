@@ -17,6 +17,14 @@ type CadenceTemplateVars struct {
 	PackNFTAddress        string
 	CollectibleNFTName    string
 	CollectibleNFTAddress string
+	CollectibleNFTs       []CadenceContract // For templates handling more than one collectible contract
+}
+
+// CadenceContract is a contract to import in a template.
+type CadenceContract struct {
+	Name       string
+	Address    string
+	Identifier string // Type identifier of the contract, e.g. "A.01cf0e2f2f715450.ExampleNFT"
 }
 
 func ParseCadenceTemplate(templatePath string, vars *CadenceTemplateVars) ([]byte, error) {
@@ -1,9 +1,13 @@
 package flow_helpers
 
 import (
+	"context"
+	"errors"
 	"strings"
 
 	fvm_errors "github.com/onflow/flow-go/fvm/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 var InvalidProposalSeqNumberErrorString = fvm_errors.ErrCodeInvalidProposalSeqNumberError.String()
@@ -11,3 +15,96 @@ var InvalidProposalSeqNumberErrorString = fvm_errors.ErrCodeInvalidProposalSeqNu
 func IsInvalidProposalSeqNumberError(err error) bool {
 	return strings.Contains(err.Error(), InvalidProposalSeqNumberErrorString)
 }
+
+// IsRangeRejectedError tells whether an access node refused to serve a block
+// range, e.g. because the range was too large or the response would have been.
+func IsRangeRejectedError(err error) bool {
+	switch grpcCode(err) {
+	case codes.InvalidArgument, codes.ResourceExhausted, codes.OutOfRange:
+		return true
+	}
+	return false
+}
+
+// IsSendTimeoutError tells whether sending a transaction failed without
+// knowing if the access node received it, so it may still be executed.
+func IsSendTimeoutError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	switch grpcCode(err) {
+	case codes.DeadlineExceeded, codes.Unavailable:
+		return true
+	}
+	return false
+}
+
+// IsNotFoundError tells whether an access node does not know the requested
+// entity, e.g. a transaction which never reached it.
+func IsNotFoundError(err error) bool {
+	return grpcCode(err) == codes.NotFound
+}
+
+// ErrorClass tells how a failed transaction should be handled.
+type ErrorClass string
+
+const (
+	// Likely to succeed when retried as is: expired transactions, invalid
+	// sequence numbers, rate limiting or an unavailable access node
+	ErrorClassTransient ErrorClass = "transient"
+	// Deterministic failure while executing the transaction, e.g. a panic in Cadence
+	ErrorClassCadence ErrorClass = "cadence"
+	// The transaction exceeded its gas, event or ledger interaction limits,
+	// a smaller batch may succeed
+	ErrorClassOutOfGas ErrorClass = "out-of-gas"
+)
+
+var transientErrorCodes = []fvm_errors.ErrorCode{
+	fvm_errors.ErrCodeInvalidReferenceBlockError,
+	fvm_errors.ErrCodeExpiredTransactionError,
+	fvm_errors.ErrCodeInvalidProposalSeqNumberError,
+}
+
+var outOfGasErrorCodes = []fvm_errors.ErrorCode{
+	fvm_errors.ErrCodeGasLimitExceededError,
+	fvm_errors.ErrCodeEventLimitExceededError,
+	fvm_errors.ErrCodeLedgerIntractionLimitExceededError,
+}
+
+// ClassifyError classifies an error of sending or executing a transaction.
+// Errors not known to be transient or caused by exceeding limits are
+// considered deterministic Cadence failures.
+func ClassifyError(err error) ErrorClass {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrorClassTransient
+	}
+
+	switch grpcCode(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.DeadlineExceeded, codes.Aborted:
+		return ErrorClassTransient
+	}
+
+	msg := err.Error()
+	for _, code := range transientErrorCodes {
+		if strings.Contains(msg, code.String()) {
+			return ErrorClassTransient
+		}
+	}
+	for _, code := range outOfGasErrorCodes {
+		if strings.Contains(msg, code.String()) {
+			return ErrorClassOutOfGas
+		}
+	}
+
+	return ErrorClassCadence
+}
+
+// grpcCode returns the gRPC status code of an error returned by an access
+// node, or codes.Unknown.
+func grpcCode(err error) codes.Code {
+	var grpcErr interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &grpcErr) {
+		return grpcErr.GRPCStatus().Code()
+	}
+	return codes.Unknown
+}
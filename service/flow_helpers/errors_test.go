@@ -0,0 +1,73 @@
+package flow_helpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/onflow/flow-go-sdk/client"
+	fvm_errors "github.com/onflow/flow-go/fvm/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestClassifyError(t *testing.T) {
+	rpcError := func(code codes.Code) error {
+		err := client.RPCError{GRPCErr: status.Error(code, "test")}
+		return fmt.Errorf("error while sending transaction: %w", err)
+	}
+
+	executionError := func(code fvm_errors.ErrorCode) error {
+		return fmt.Errorf("Execution failed:\n%s test error", code)
+	}
+
+	cases := []struct {
+		err      error
+		expected ErrorClass
+	}{
+		{rpcError(codes.Unavailable), ErrorClassTransient},
+		{rpcError(codes.ResourceExhausted), ErrorClassTransient},
+		{rpcError(codes.InvalidArgument), ErrorClassCadence},
+		{context.DeadlineExceeded, ErrorClassTransient},
+		{executionError(fvm_errors.ErrCodeExpiredTransactionError), ErrorClassTransient},
+		{executionError(fvm_errors.ErrCodeInvalidProposalSeqNumberError), ErrorClassTransient},
+		{executionError(fvm_errors.ErrCodeGasLimitExceededError), ErrorClassOutOfGas},
+		{executionError(fvm_errors.ErrCodeLedgerIntractionLimitExceededError), ErrorClassOutOfGas},
+		{executionError(fvm_errors.ErrCodeCadenceRunTimeError), ErrorClassCadence},
+		{errors.New("panic: pds does not have Dist manager"), ErrorClassCadence},
+	}
+
+	for _, c := range cases {
+		if got := ClassifyError(c.err); got != c.expected {
+			t.Errorf("expected %q to be %s, got %s", c.err, c.expected, got)
+		}
+	}
+}
+
+func TestIsRangeRejectedError(t *testing.T) {
+	if !IsRangeRejectedError(client.RPCError{GRPCErr: status.Error(codes.ResourceExhausted, "too many events")}) {
+		t.Error("expected a resource exhausted error to reject the range")
+	}
+	if IsRangeRejectedError(client.RPCError{GRPCErr: status.Error(codes.Unavailable, "unavailable")}) {
+		t.Error("expected an unavailable error not to reject the range")
+	}
+	if IsRangeRejectedError(errors.New("test")) {
+		t.Error("expected a plain error not to reject the range")
+	}
+}
+
+func TestIsSendTimeoutError(t *testing.T) {
+	if !IsSendTimeoutError(fmt.Errorf("error while sending transaction: %w", context.DeadlineExceeded)) {
+		t.Error("expected an exceeded deadline to be a send timeout")
+	}
+	if !IsSendTimeoutError(client.RPCError{GRPCErr: status.Error(codes.Unavailable, "unavailable")}) {
+		t.Error("expected an unavailable access node to be a send timeout")
+	}
+	if IsSendTimeoutError(client.RPCError{GRPCErr: status.Error(codes.InvalidArgument, "invalid")}) {
+		t.Error("expected an invalid argument not to be a send timeout")
+	}
+	if !IsNotFoundError(client.RPCError{GRPCErr: status.Error(codes.NotFound, "not found")}) {
+		t.Error("expected a not found error")
+	}
+}
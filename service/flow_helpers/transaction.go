@@ -9,6 +9,10 @@ import (
 	"github.com/onflow/flow-go-sdk/client"
 )
 
+// TransactionExpiry is the number of blocks after its reference block a
+// transaction can still be included in.
+const TransactionExpiry = 600
+
 func SignProposeAndPayAs(ctx context.Context, flowClient *client.Client, account *Account, tx *flow.Transaction) (UnlockKeyFunc, error) {
 
 	signer, err := account.GetSigner()
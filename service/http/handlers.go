@@ -2,10 +2,14 @@ package http
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/flow-hydraulics/flow-pds/service/app"
+	"github.com/flow-hydraulics/flow-pds/service/common"
+	"github.com/flow-hydraulics/flow-pds/service/transactions"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
@@ -71,6 +75,32 @@ func HandleCreateDistribution(logger *log.Logger, app *app.App) http.HandlerFunc
 	}
 }
 
+// Validate a distribution without creating it
+func HandleValidateDistribution(logger *log.Logger, app *app.App) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		// Check body is not empty
+		if err := checkNonEmptyBody(r); err != nil {
+			handleError(rw, logger, err)
+			return
+		}
+
+		var reqDist ReqCreateDistribution
+
+		// Decode JSON
+		if err := json.NewDecoder(r.Body).Decode(&reqDist); err != nil {
+			handleError(rw, logger, err)
+			return
+		}
+
+		appDist := reqDist.ToApp()
+		preview := app.PreviewDistribution(r.Context(), &appDist)
+
+		res := ResDistributionPreviewFromApp(preview)
+
+		handleJsonResponse(rw, http.StatusOK, res)
+	}
+}
+
 // List distributions
 func HandleListDistributions(logger *log.Logger, app *app.App) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
@@ -84,7 +114,13 @@ func HandleListDistributions(logger *log.Logger, app *app.App) http.HandlerFunc
 			offset = 0
 		}
 
-		list, err := app.ListDistributions(r.Context(), limit, offset)
+		filter, err := parseDistributionFilter(r)
+		if err != nil {
+			handleError(rw, logger, err)
+			return
+		}
+
+		list, err := app.ListDistributions(r.Context(), limit, offset, filter)
 		if err != nil {
 			handleError(rw, logger, err)
 			return
@@ -96,6 +132,20 @@ func HandleListDistributions(logger *log.Logger, app *app.App) http.HandlerFunc
 	}
 }
 
+// parseDistributionFilter parses metadata filters given as 'metadata=key:value'.
+// A distribution must match all given filters.
+func parseDistributionFilter(r *http.Request) (app.DistributionFilter, error) {
+	filter := app.DistributionFilter{Metadata: make(map[string]string)}
+	for _, kv := range r.Form["metadata"] {
+		split := strings.SplitN(kv, ":", 2)
+		if len(split) != 2 {
+			return filter, fmt.Errorf("invalid metadata filter '%s', expected 'key:value'", kv)
+		}
+		filter.Metadata[split[0]] = split[1]
+	}
+	return filter, nil
+}
+
 // Get distribution details
 func HandleGetDistribution(logger *log.Logger, app *app.App) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
@@ -113,7 +163,84 @@ func HandleGetDistribution(logger *log.Logger, app *app.App) http.HandlerFunc {
 			return
 		}
 
-		res := ResGetDistributionFromApp(dist)
+		seed, err := app.GetRevealedShuffleSeed(r.Context(), dist)
+		if err != nil {
+			handleError(rw, logger, err)
+			return
+		}
+
+		res := ResGetDistributionFromApp(dist, seed)
+
+		handleJsonResponse(rw, http.StatusOK, res)
+	}
+}
+
+// Get the data needed to verify the shuffle of a distribution
+func HandleGetDistributionShuffleProof(logger *log.Logger, app *app.App) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		id, err := uuid.Parse(vars["id"])
+		if err != nil {
+			handleError(rw, logger, err)
+			return
+		}
+
+		proof, err := app.GetShuffleProof(r.Context(), id)
+		if err != nil {
+			handleError(rw, logger, err)
+			return
+		}
+
+		res := ResShuffleProofFromApp(proof)
+
+		handleJsonResponse(rw, http.StatusOK, res)
+	}
+}
+
+// Get settlement and minting progress of a distribution
+func HandleGetDistributionProgress(logger *log.Logger, app *app.App) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		id, err := uuid.Parse(vars["id"])
+		if err != nil {
+			handleError(rw, logger, err)
+			return
+		}
+
+		progress, err := app.GetDistributionProgress(r.Context(), id)
+		if err != nil {
+			handleError(rw, logger, err)
+			return
+		}
+
+		res := ResDistributionProgressFromApp(progress)
+
+		handleJsonResponse(rw, http.StatusOK, res)
+	}
+}
+
+// List distributions whose offchain and onchain states disagree
+func HandleReconcileDistributions(logger *log.Logger, app *app.App) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		limit, err := strconv.Atoi(r.FormValue("limit"))
+		if err != nil {
+			limit = 0
+		}
+
+		offset, err := strconv.Atoi(r.FormValue("offset"))
+		if err != nil {
+			offset = 0
+		}
+
+		list, err := app.ReconcileDistributions(r.Context(), limit, offset)
+		if err != nil {
+			handleError(rw, logger, err)
+			return
+		}
+
+		res := ResStateReconciliationListFromApp(list)
 
 		handleJsonResponse(rw, http.StatusOK, res)
 	}
@@ -139,6 +266,277 @@ func HandleAbortDistribution(logger *log.Logger, app *app.App) http.HandlerFunc
 	}
 }
 
+// Re-queue settle transactions for the collectibles of a distribution which have not been settled
+func HandleRetrySettlement(logger *log.Logger, app *app.App) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		id, err := uuid.Parse(vars["id"])
+		if err != nil {
+			handleError(rw, logger, err)
+			return
+		}
+
+		count, err := app.RetrySettlement(r.Context(), id)
+		if err != nil {
+			handleError(rw, logger, err)
+			return
+		}
+
+		res := ResSettlementRetry{QueuedCount: count}
+
+		handleJsonResponse(rw, http.StatusOK, res)
+	}
+}
+
+// List packs of a distribution
+func HandleListPacks(logger *log.Logger, app *app.App) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		id, err := uuid.Parse(vars["id"])
+		if err != nil {
+			handleError(rw, logger, err)
+			return
+		}
+
+		limit, err := strconv.Atoi(r.FormValue("limit"))
+		if err != nil {
+			limit = 0
+		}
+
+		offset, err := strconv.Atoi(r.FormValue("offset"))
+		if err != nil {
+			offset = 0
+		}
+
+		state := common.PackState(r.FormValue("state"))
+
+		list, err := app.ListPacks(r.Context(), id, state, limit, offset)
+		if err != nil {
+			handleError(rw, logger, err)
+			return
+		}
+
+		res := ResPackListFromApp(list)
+
+		handleJsonResponse(rw, http.StatusOK, res)
+	}
+}
+
+// Get pack details
+func HandleGetPack(logger *log.Logger, app *app.App) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		id, err := uuid.Parse(vars["id"])
+		if err != nil {
+			handleError(rw, logger, err)
+			return
+		}
+
+		pack, err := app.GetPack(r.Context(), id)
+		if err != nil {
+			handleError(rw, logger, err)
+			return
+		}
+
+		res := ResPackFromApp(pack)
+
+		handleJsonResponse(rw, http.StatusOK, res)
+	}
+}
+
+// Get the data needed to verify the contents of a revealed pack
+func HandleGetPackProof(logger *log.Logger, app *app.App) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		id, err := uuid.Parse(vars["id"])
+		if err != nil {
+			handleError(rw, logger, err)
+			return
+		}
+
+		pack, err := app.GetPackProof(r.Context(), id)
+		if err != nil {
+			handleError(rw, logger, err)
+			return
+		}
+
+		res := ResPackProofFromApp(pack)
+
+		handleJsonResponse(rw, http.StatusOK, res)
+	}
+}
+
+// Get pack details by pack NFT contract and onchain ID
+func HandleGetPackByFlowID(logger *log.Logger, app *app.App) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		contract, err := parseAddressLocation(vars["contract"])
+		if err != nil {
+			handleError(rw, logger, err)
+			return
+		}
+
+		flowID, err := common.FlowIDFromString(vars["flowID"])
+		if err != nil {
+			handleError(rw, logger, err)
+			return
+		}
+
+		pack, err := app.GetPackByFlowID(r.Context(), contract, flowID)
+		if err != nil {
+			handleError(rw, logger, err)
+			return
+		}
+
+		res := ResPackFromApp(pack)
+
+		handleJsonResponse(rw, http.StatusOK, res)
+	}
+}
+
+// List Flow transactions
+func HandleListTransactions(logger *log.Logger, app *app.App) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		limit, err := strconv.Atoi(r.FormValue("limit"))
+		if err != nil {
+			limit = 0
+		}
+
+		offset, err := strconv.Atoi(r.FormValue("offset"))
+		if err != nil {
+			offset = 0
+		}
+
+		filter, err := parseTransactionFilter(r)
+		if err != nil {
+			handleError(rw, logger, err)
+			return
+		}
+
+		list, err := app.ListTransactions(r.Context(), filter, limit, offset)
+		if err != nil {
+			handleError(rw, logger, err)
+			return
+		}
+
+		res := ResTransactionListFromApp(list)
+
+		handleJsonResponse(rw, http.StatusOK, res)
+	}
+}
+
+// parseTransactionFilter parses the 'state', 'name', 'distID' and 'parentID'
+// filters of a transaction listing.
+func parseTransactionFilter(r *http.Request) (transactions.ListFilter, error) {
+	filter := transactions.ListFilter{
+		State: common.TransactionState(r.FormValue("state")),
+		Name:  r.FormValue("name"),
+	}
+	if distID := r.FormValue("distID"); distID != "" {
+		id, err := uuid.Parse(distID)
+		if err != nil {
+			return filter, fmt.Errorf("invalid distID filter '%s': %w", distID, err)
+		}
+		filter.DistributionID = id
+	}
+	if parentID := r.FormValue("parentID"); parentID != "" {
+		id, err := uuid.Parse(parentID)
+		if err != nil {
+			return filter, fmt.Errorf("invalid parentID filter '%s': %w", parentID, err)
+		}
+		filter.ParentID = id
+	}
+	return filter, nil
+}
+
+// Get Flow transaction details
+func HandleGetTransaction(logger *log.Logger, app *app.App) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		id, err := uuid.Parse(vars["id"])
+		if err != nil {
+			handleError(rw, logger, err)
+			return
+		}
+
+		t, err := app.GetTransaction(r.Context(), id)
+		if err != nil {
+			handleError(rw, logger, err)
+			return
+		}
+
+		var children []transactions.StorableTransaction
+		if t.State == common.TransactionStateSplit {
+			children, err = app.ListTransactions(r.Context(), transactions.ListFilter{ParentID: t.ID}, 0, 0)
+			if err != nil {
+				handleError(rw, logger, err)
+				return
+			}
+		}
+
+		res := ResGetTransactionFromApp(t, children)
+
+		handleJsonResponse(rw, http.StatusOK, res)
+	}
+}
+
+// Re-queue a failed Flow transaction
+func HandleRetryTransaction(logger *log.Logger, app *app.App) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		id, err := uuid.Parse(vars["id"])
+		if err != nil {
+			handleError(rw, logger, err)
+			return
+		}
+
+		t, err := app.RetryTransaction(r.Context(), id)
+		if err != nil {
+			handleError(rw, logger, err)
+			return
+		}
+
+		res := ResTransactionFromApp(t)
+
+		handleJsonResponse(rw, http.StatusOK, res)
+	}
+}
+
+// Cancel a Flow transaction which has not been sent or has failed
+func HandleCancelTransaction(logger *log.Logger, app *app.App) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		id, err := uuid.Parse(vars["id"])
+		if err != nil {
+			handleError(rw, logger, err)
+			return
+		}
+
+		t, err := app.CancelTransaction(r.Context(), id)
+		if err != nil {
+			handleError(rw, logger, err)
+			return
+		}
+
+		res := ResTransactionFromApp(t)
+
+		handleJsonResponse(rw, http.StatusOK, res)
+	}
+}
+
+// parseAddressLocation parses a contract reference, e.g. "A.01cf0e2f2f715450.PackNFT".
+func parseAddressLocation(s string) (app.AddressLocation, error) {
+	return app.AddressLocationFromString(s)
+}
+
 func HandleHealthReady() http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		rw.WriteHeader(http.StatusOK)
@@ -7,6 +7,7 @@ import (
 	"io"
 	"net/http"
 
+	"github.com/flow-hydraulics/flow-pds/service/app"
 	gorilla "github.com/gorilla/handlers"
 	log "github.com/sirupsen/logrus"
 	"gorm.io/gorm"
@@ -41,6 +42,24 @@ func handleError(rw http.ResponseWriter, logger *log.Logger, err error) {
 		return
 	}
 
+	if errors.Is(err, app.ErrDistributionRegistered) || errors.Is(err, app.ErrSettlementInFlight) {
+		http.Error(rw, err.Error(), http.StatusConflict)
+		return
+	}
+
+	// Check for conflicting collectibles, report them in a structured form
+	var duplicateErr *app.DuplicateCollectiblesError
+	if errors.As(err, &duplicateErr) {
+		handleJsonResponse(rw, http.StatusBadRequest, ResCollectibleConflictsErrorFromApp(err, duplicateErr.Conflicts))
+		return
+	}
+
+	var reservedErr *app.ReservedCollectiblesError
+	if errors.As(err, &reservedErr) {
+		handleJsonResponse(rw, http.StatusConflict, ResCollectibleConflictsErrorFromApp(err, reservedErr.Conflicts))
+		return
+	}
+
 	http.Error(rw, err.Error(), http.StatusBadRequest)
 }
 
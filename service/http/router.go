@@ -21,9 +21,24 @@ func NewRouter(app *app.App) http.Handler {
 	rv.HandleFunc("/set-dist-cap", HandleSetDistCap(requestLogger, app)).Methods(http.MethodPost)
 
 	rv.HandleFunc("/distributions", HandleCreateDistribution(requestLogger, app)).Methods(http.MethodPost)
+	rv.HandleFunc("/distributions:validate", HandleValidateDistribution(requestLogger, app)).Methods(http.MethodPost)
 	rv.HandleFunc("/distributions", HandleListDistributions(requestLogger, app)).Methods(http.MethodGet)
+	rv.HandleFunc("/distributions:reconcile", HandleReconcileDistributions(requestLogger, app)).Methods(http.MethodGet)
 	rv.HandleFunc("/distributions/{id}", HandleGetDistribution(requestLogger, app)).Methods(http.MethodGet)
+	rv.HandleFunc("/distributions/{id}/progress", HandleGetDistributionProgress(requestLogger, app)).Methods(http.MethodGet)
+	rv.HandleFunc("/distributions/{id}/verification", HandleGetDistributionShuffleProof(requestLogger, app)).Methods(http.MethodGet)
 	rv.HandleFunc("/distributions/{id}/abort", HandleAbortDistribution(requestLogger, app)).Methods(http.MethodPost)
+	rv.HandleFunc("/distributions/{id}/settlement/retry", HandleRetrySettlement(requestLogger, app)).Methods(http.MethodPost)
+	rv.HandleFunc("/distributions/{id}/packs", HandleListPacks(requestLogger, app)).Methods(http.MethodGet)
+
+	rv.HandleFunc("/packs/{id}", HandleGetPack(requestLogger, app)).Methods(http.MethodGet)
+	rv.HandleFunc("/packs/{id}/proof", HandleGetPackProof(requestLogger, app)).Methods(http.MethodGet)
+	rv.HandleFunc("/packs/by-flow-id/{contract}/{flowID}", HandleGetPackByFlowID(requestLogger, app)).Methods(http.MethodGet)
+
+	rv.HandleFunc("/transactions", HandleListTransactions(requestLogger, app)).Methods(http.MethodGet)
+	rv.HandleFunc("/transactions/{id}", HandleGetTransaction(requestLogger, app)).Methods(http.MethodGet)
+	rv.HandleFunc("/transactions/{id}/retry", HandleRetryTransaction(requestLogger, app)).Methods(http.MethodPost)
+	rv.HandleFunc("/transactions/{id}/cancel", HandleCancelTransaction(requestLogger, app)).Methods(http.MethodPost)
 
 	// Use middleware
 	h := UseCors(r)
@@ -1,10 +1,14 @@
 package http
 
 import (
+	"encoding/json"
+	"errors"
 	"time"
 
 	"github.com/flow-hydraulics/flow-pds/service/app"
 	"github.com/flow-hydraulics/flow-pds/service/common"
+	"github.com/flow-hydraulics/flow-pds/service/flow_helpers"
+	"github.com/flow-hydraulics/flow-pds/service/transactions"
 	"github.com/google/uuid"
 )
 
@@ -16,24 +20,27 @@ type ReqCreateDistribution struct {
 	FlowID       common.FlowID      `json:"distFlowID"`
 	Issuer       common.FlowAddress `json:"issuer"`
 	PackTemplate ReqPackTemplate    `json:"packTemplate"`
+	ShuffleMode  app.ShuffleMode    `json:"shuffleMode"`
 }
 
 type ReqPackTemplate struct {
-	PackReference AddressLocation `json:"packReference"`
-	PackCount     uint            `json:"packCount"`
-	Buckets       []ReqBucket     `json:"buckets"`
+	PackReference AddressLocation     `json:"packReference"`
+	PackCount     uint                `json:"packCount"`
+	Buckets       []ReqBucket         `json:"buckets"`
+	Constraints   app.PackConstraints `json:"constraints,omitempty"`
 
-	// This is here to provide compatibility between backend and onchain contracts.
-	// Backend handles CollectibleReferences per bucket but onchain contracts
-	// currently handle CollectibleReferences per distribution.
+	// Default CollectibleReference for buckets which do not define their own.
 	CollectibleReference AddressLocation `json:"collectibleReference"`
 }
 
 type ReqBucket struct {
-	// NOTE: read about compatibility above
-	// CollectibleReference  AddressLocation   `json:"collectibleReference"`
-	CollectibleCount      uint              `json:"collectibleCount"`
-	CollectibleCollection common.FlowIDList `json:"collectibleCollection"`
+	CollectibleReference    *AddressLocation    `json:"collectibleReference,omitempty"`
+	CollectibleCount        uint                `json:"collectibleCount"`
+	CollectibleCollection   common.FlowIDList   `json:"collectibleCollection"`
+	SlotGroup               string              `json:"slotGroup,omitempty"`
+	Weight                  uint                `json:"weight,omitempty"`
+	Tier                    string              `json:"tier,omitempty"`
+	CollectibleMetadataKeys app.CollectibleKeys `json:"collectibleMetadataKeys,omitempty"`
 }
 
 type ResCreateDistribution struct {
@@ -48,7 +55,21 @@ type ResGetDistribution struct {
 	UpdatedAt    time.Time                `json:"updatedAt"`
 	Issuer       common.FlowAddress       `json:"issuer"`
 	State        common.DistributionState `json:"state"`
+	Title        string                   `json:"title"`
+	Metadata     map[string]string        `json:"metadata"`
 	PackTemplate ResPackTemplate          `json:"packTemplate"`
+
+	ShuffleMode           app.ShuffleMode    `json:"shuffleMode,omitempty"`
+	ShuffleSeedCommitment common.BinaryValue `json:"shuffleSeedCommitment,omitempty"`
+	ShuffleSeed           common.BinaryValue `json:"shuffleSeed,omitempty"`
+	EntropyBlockHeight    uint64             `json:"entropyBlockHeight,omitempty"`
+	EntropyBlockID        common.BinaryValue `json:"entropyBlockID,omitempty"`
+	CommitmentTxID        string             `json:"commitmentTxID,omitempty"`
+	ResolutionConflicts   uint               `json:"resolutionConflicts"`
+
+	InvalidReason       string   `json:"invalidReason,omitempty"`
+	MissingCollectibles []string `json:"missingCollectibles,omitempty"`
+	StalledReason       string   `json:"stalledReason,omitempty"`
 }
 
 type ResListDistribution struct {
@@ -58,17 +79,183 @@ type ResListDistribution struct {
 	UpdatedAt time.Time                `json:"updatedAt"`
 	Issuer    common.FlowAddress       `json:"issuer"`
 	State     common.DistributionState `json:"state"`
+	Title     string                   `json:"title"`
+	Metadata  map[string]string        `json:"metadata"`
 }
 
 type ResPackTemplate struct {
-	PackReference AddressLocation `json:"packReference"`
-	PackCount     uint            `json:"packCount"`
-	Buckets       []ResBucket     `json:"buckets"`
+	PackReference AddressLocation     `json:"packReference"`
+	PackCount     uint                `json:"packCount"`
+	Buckets       []ResBucket         `json:"buckets"`
+	Constraints   app.PackConstraints `json:"constraints,omitempty"`
 }
 
 type ResBucket struct {
 	CollectibleReference AddressLocation `json:"collectibleReference"`
 	CollectibleCount     uint            `json:"collectibleCount"`
+	SlotGroup            string          `json:"slotGroup,omitempty"`
+	Weight               uint            `json:"weight,omitempty"`
+	Tier                 string          `json:"tier,omitempty"`
+	DrawnPackCount       uint            `json:"drawnPackCount"`
+	ExpectedOdds         float64         `json:"expectedOdds"`
+	EffectiveOdds        float64         `json:"effectiveOdds"`
+	RealizedOdds         float64         `json:"realizedOdds"`
+}
+
+type ResShuffleProof struct {
+	ID                    uuid.UUID               `json:"distID"`
+	ShuffleMode           app.ShuffleMode         `json:"shuffleMode"`
+	ShuffleSeedCommitment common.BinaryValue      `json:"shuffleSeedCommitment"`
+	ShuffleSeed           common.BinaryValue      `json:"shuffleSeed,omitempty"`
+	EntropyBlockHeight    uint64                  `json:"entropyBlockHeight,omitempty"`
+	EntropyBlockID        common.BinaryValue      `json:"entropyBlockID,omitempty"`
+	CommitmentTxID        string                  `json:"commitmentTxID,omitempty"`
+	PackTemplate          ResShuffleProofTemplate `json:"packTemplate"`
+	RevealedPacks         []ResShuffleProofPack   `json:"revealedPacks"`
+}
+
+type ResShuffleProofTemplate struct {
+	PackReference AddressLocation         `json:"packReference"`
+	PackCount     uint                    `json:"packCount"`
+	Buckets       []ResShuffleProofBucket `json:"buckets"`
+	Constraints   app.PackConstraints     `json:"constraints,omitempty"`
+}
+
+type ResShuffleProofBucket struct {
+	CollectibleReference    AddressLocation     `json:"collectibleReference"`
+	CollectibleCount        uint                `json:"collectibleCount"`
+	CollectibleCollection   common.FlowIDList   `json:"collectibleCollection"`
+	SlotGroup               string              `json:"slotGroup,omitempty"`
+	Weight                  uint                `json:"weight,omitempty"`
+	Tier                    string              `json:"tier,omitempty"`
+	CollectibleMetadataKeys app.CollectibleKeys `json:"collectibleMetadataKeys,omitempty"`
+}
+
+type ResShuffleProofPack struct {
+	Index        uint          `json:"index"`
+	FlowID       common.FlowID `json:"flowID"`
+	Collectibles []string      `json:"collectibles"`
+}
+
+type ResDistributionPreview struct {
+	Valid                    bool                     `json:"valid"`
+	Errors                   []string                 `json:"errors"`
+	Conflicts                []ResCollectibleConflict `json:"conflicts"`
+	Warnings                 []string                 `json:"warnings"`
+	SlotCount                int                      `json:"slotCount"`
+	RequiredCollectibleCount int                      `json:"requiredCollectibleCount"`
+	SuppliedCollectibleCount int                      `json:"suppliedCollectibleCount"`
+	Buckets                  []ResBucketPreview       `json:"buckets"`
+}
+
+type ResBucketPreview struct {
+	SuppliedCount  int     `json:"suppliedCount"`
+	DrawnPackCount int     `json:"drawnPackCount"`
+	UsedCount      int     `json:"usedCount"`
+	LeftoverCount  int     `json:"leftoverCount"`
+	ExpectedOdds   float64 `json:"expectedOdds"`
+	EffectiveOdds  float64 `json:"effectiveOdds"`
+}
+
+type ResDistributionProgress struct {
+	State common.DistributionState `json:"state"`
+
+	SettledCount    uint    `json:"settledCount"`
+	SettlementTotal uint    `json:"settlementTotal"`
+	SettledPercent  float64 `json:"settledPercent"`
+
+	MintedCount  uint `json:"mintedCount"`
+	MintingTotal uint `json:"mintingTotal"`
+
+	RefundedCount uint `json:"refundedCount"`
+	RefundTotal   uint `json:"refundTotal"`
+
+	PackStates map[common.PackState]int64 `json:"packStates"`
+
+	LastScannedBlock uint64 `json:"lastScannedBlock,omitempty"`
+
+	PendingTransactions int64 `json:"pendingTransactions"`
+	FailedTransactions  int64 `json:"failedTransactions"`
+}
+
+type ResStateReconciliation struct {
+	DistributionID       uuid.UUID                `json:"distID"`
+	DistFlowID           common.FlowID            `json:"distFlowID"`
+	State                common.DistributionState `json:"state"`
+	ExpectedOnchainState common.OnchainDistState  `json:"expectedOnchainState"`
+	OnchainState         common.OnchainDistState  `json:"onchainState"`
+	PendingUpdate        bool                     `json:"pendingUpdate"`
+}
+
+type ResSettlementRetry struct {
+	QueuedCount int `json:"queuedCount"`
+}
+
+type ResTransaction struct {
+	ID             uuid.UUID               `json:"txID"`
+	CreatedAt      time.Time               `json:"createdAt"`
+	UpdatedAt      time.Time               `json:"updatedAt"`
+	DistributionID uuid.UUID               `json:"distID"`
+	Name           string                  `json:"name"`
+	State          common.TransactionState `json:"state"`
+	Error          string                  `json:"error,omitempty"`
+	ErrorClass     flow_helpers.ErrorClass `json:"errorClass,omitempty"`
+	RetryCount     uint                    `json:"retryCount"`
+	NextAttemptAt  *time.Time              `json:"nextAttemptAt,omitempty"`
+	FlowTxID       string                  `json:"flowTxID,omitempty"`
+	ParentID       *uuid.UUID              `json:"parentID,omitempty"` // Set for halves of a split batch
+}
+
+type ResGetTransaction struct {
+	ResTransaction
+	Script    string                     `json:"script"`
+	Arguments []json.RawMessage          `json:"arguments"` // JSON-Cadence encoded
+	Audit     []ResTransactionAuditEntry `json:"audit"`
+	Children  []ResTransaction           `json:"children,omitempty"` // Halves of a split batch
+}
+
+type ResTransactionAuditEntry struct {
+	CreatedAt        time.Time                `json:"createdAt"`
+	Action           transactions.AuditAction `json:"action"`
+	PreviousState    common.TransactionState  `json:"previousState"`
+	PreviousError    string                   `json:"previousError,omitempty"`
+	PreviousFlowTxID string                   `json:"previousFlowTxID,omitempty"`
+	RetryCount       uint                     `json:"retryCount"`
+}
+
+type ResPack struct {
+	ID                uuid.UUID          `json:"packID"`
+	DistributionID    uuid.UUID          `json:"distID"`
+	CreatedAt         time.Time          `json:"createdAt"`
+	UpdatedAt         time.Time          `json:"updatedAt"`
+	ContractReference AddressLocation    `json:"packReference"`
+	FlowID            common.FlowID      `json:"flowID"`
+	State             common.PackState   `json:"state"`
+	CommitmentHash    common.BinaryValue `json:"commitmentHash"`
+
+	// Only set once the pack has been revealed
+	Salt         common.BinaryValue `json:"salt,omitempty"`
+	Collectibles []string           `json:"collectibles,omitempty"`
+}
+
+type ResPackProof struct {
+	ID             uuid.UUID          `json:"packID"`
+	FlowID         common.FlowID      `json:"flowID"`
+	Salt           common.BinaryValue `json:"salt"`
+	Collectibles   []string           `json:"collectibles"`
+	HashInput      string             `json:"hashInput"`
+	CommitmentHash common.BinaryValue `json:"commitmentHash"`
+}
+
+type ResCollectibleConflictsError struct {
+	Error     string                   `json:"error"`
+	Conflicts []ResCollectibleConflict `json:"conflicts"`
+}
+
+type ResCollectibleConflict struct {
+	Collectible    string     `json:"collectible"`
+	Buckets        []int      `json:"buckets"`
+	DistributionID *uuid.UUID `json:"distributionId,omitempty"`
 }
 
 type AddressLocation struct {
@@ -76,7 +263,12 @@ type AddressLocation struct {
 	Address common.FlowAddress `json:"address"`
 }
 
-func ResGetDistributionFromApp(d *app.Distribution) ResGetDistribution {
+func ResGetDistributionFromApp(d *app.Distribution, seed common.BinaryValue) ResGetDistribution {
+	missing := make([]string, len(d.MissingCollectibles))
+	for i, c := range d.MissingCollectibles {
+		missing[i] = c.String()
+	}
+
 	return ResGetDistribution{
 		ID:           d.ID,
 		FlowID:       d.FlowID,
@@ -84,10 +276,295 @@ func ResGetDistributionFromApp(d *app.Distribution) ResGetDistribution {
 		UpdatedAt:    d.UpdatedAt,
 		Issuer:       d.Issuer,
 		State:        d.State,
+		Title:        d.Title,
+		Metadata:     d.MetadataMap(),
 		PackTemplate: ResPackTemplateFromApp(d.PackTemplate),
+
+		ShuffleMode:           d.ShuffleMode,
+		ShuffleSeedCommitment: d.ShuffleSeedCommitment,
+		ShuffleSeed:           seed,
+		EntropyBlockHeight:    d.EntropyBlockHeight,
+		EntropyBlockID:        d.EntropyBlockID,
+		CommitmentTxID:        d.CommitmentTxID,
+		ResolutionConflicts:   d.ResolutionConflicts,
+
+		InvalidReason:       d.InvalidReason,
+		MissingCollectibles: missing,
+		StalledReason:       d.StalledReason,
 	}
 }
 
+func ResShuffleProofFromApp(p *app.ShuffleProof) ResShuffleProof {
+	buckets := make([]ResShuffleProofBucket, len(p.PackTemplate.Buckets))
+	for i, b := range p.PackTemplate.Buckets {
+		buckets[i] = ResShuffleProofBucket{
+			CollectibleReference:    AddressLocation(b.CollectibleReference),
+			CollectibleCount:        b.CollectibleCount,
+			CollectibleCollection:   b.CollectibleCollection,
+			SlotGroup:               b.SlotGroup,
+			Weight:                  b.Weight,
+			Tier:                    b.Tier,
+			CollectibleMetadataKeys: b.CollectibleMetadataKeys,
+		}
+	}
+
+	packs := make([]ResShuffleProofPack, len(p.RevealedPacks))
+	for i, rp := range p.RevealedPacks {
+		collectibles := make([]string, len(rp.Collectibles))
+		for j, c := range rp.Collectibles {
+			collectibles[j] = c.String()
+		}
+		packs[i] = ResShuffleProofPack{
+			Index:        rp.Index,
+			FlowID:       rp.FlowID,
+			Collectibles: collectibles,
+		}
+	}
+
+	return ResShuffleProof{
+		ID:                    p.DistributionID,
+		ShuffleMode:           p.ShuffleMode,
+		ShuffleSeedCommitment: p.ShuffleSeedCommitment,
+		ShuffleSeed:           p.ShuffleSeed,
+		EntropyBlockHeight:    p.EntropyBlockHeight,
+		EntropyBlockID:        p.EntropyBlockID,
+		CommitmentTxID:        p.CommitmentTxID,
+		PackTemplate: ResShuffleProofTemplate{
+			PackReference: AddressLocation(p.PackTemplate.PackReference),
+			PackCount:     p.PackTemplate.PackCount,
+			Buckets:       buckets,
+			Constraints:   p.PackTemplate.Constraints,
+		},
+		RevealedPacks: packs,
+	}
+}
+
+// ToApp converts a shuffle proof response back to app.ShuffleProof so it
+// can be verified with app.VerifyShuffle.
+func (p ResShuffleProof) ToApp() (app.ShuffleProof, error) {
+	buckets := make([]app.Bucket, len(p.PackTemplate.Buckets))
+	for i, b := range p.PackTemplate.Buckets {
+		buckets[i] = app.Bucket{
+			CollectibleReference:    app.AddressLocation(b.CollectibleReference),
+			CollectibleCount:        b.CollectibleCount,
+			CollectibleCollection:   b.CollectibleCollection,
+			SlotGroup:               b.SlotGroup,
+			Weight:                  b.Weight,
+			Tier:                    b.Tier,
+			CollectibleMetadataKeys: b.CollectibleMetadataKeys,
+		}
+	}
+
+	packs := make([]app.RevealedPack, len(p.RevealedPacks))
+	for i, rp := range p.RevealedPacks {
+		collectibles := make(app.Collectibles, len(rp.Collectibles))
+		for j, s := range rp.Collectibles {
+			c, err := app.CollectibleFromString(s)
+			if err != nil {
+				return app.ShuffleProof{}, err
+			}
+			collectibles[j] = c
+		}
+		packs[i] = app.RevealedPack{
+			Index:        rp.Index,
+			FlowID:       rp.FlowID,
+			Collectibles: collectibles,
+		}
+	}
+
+	return app.ShuffleProof{
+		DistributionID:        p.ID,
+		ShuffleMode:           p.ShuffleMode,
+		ShuffleSeedCommitment: p.ShuffleSeedCommitment,
+		ShuffleSeed:           p.ShuffleSeed,
+		EntropyBlockHeight:    p.EntropyBlockHeight,
+		EntropyBlockID:        p.EntropyBlockID,
+		CommitmentTxID:        p.CommitmentTxID,
+		PackTemplate: app.PackTemplate{
+			PackReference: app.AddressLocation(p.PackTemplate.PackReference),
+			PackCount:     p.PackTemplate.PackCount,
+			Buckets:       buckets,
+			Constraints:   p.PackTemplate.Constraints,
+		},
+		RevealedPacks: packs,
+	}, nil
+}
+
+func ResDistributionPreviewFromApp(p app.DistributionPreview) ResDistributionPreview {
+	buckets := make([]ResBucketPreview, len(p.Buckets))
+	for i, b := range p.Buckets {
+		buckets[i] = ResBucketPreview(b)
+	}
+
+	// Report conflicting collectibles in a structured form as well
+	errs := make([]string, len(p.Errors))
+	conflicts := []ResCollectibleConflict{}
+	for i, err := range p.Errors {
+		errs[i] = err.Error()
+
+		var duplicateErr *app.DuplicateCollectiblesError
+		if errors.As(err, &duplicateErr) {
+			conflicts = append(conflicts, resCollectibleConflictsFromApp(duplicateErr.Conflicts)...)
+		}
+
+		var reservedErr *app.ReservedCollectiblesError
+		if errors.As(err, &reservedErr) {
+			conflicts = append(conflicts, resCollectibleConflictsFromApp(reservedErr.Conflicts)...)
+		}
+	}
+
+	return ResDistributionPreview{
+		Valid:                    p.Valid,
+		Errors:                   errs,
+		Conflicts:                conflicts,
+		Warnings:                 p.Warnings,
+		SlotCount:                p.SlotCount,
+		RequiredCollectibleCount: p.RequiredCollectibleCount,
+		SuppliedCollectibleCount: p.SuppliedCollectibleCount,
+		Buckets:                  buckets,
+	}
+}
+
+func ResDistributionProgressFromApp(p *app.DistributionProgress) ResDistributionProgress {
+	return ResDistributionProgress(*p)
+}
+
+func ResStateReconciliationListFromApp(list []app.StateReconciliation) []ResStateReconciliation {
+	res := make([]ResStateReconciliation, len(list))
+	for i, r := range list {
+		res[i] = ResStateReconciliation(r)
+	}
+	return res
+}
+
+func ResTransactionFromApp(t *transactions.StorableTransaction) ResTransaction {
+	res := ResTransaction{
+		ID:             t.ID,
+		CreatedAt:      t.CreatedAt,
+		UpdatedAt:      t.UpdatedAt,
+		DistributionID: t.DistributionID,
+		Name:           t.Name,
+		State:          t.State,
+		Error:          t.Error,
+		ErrorClass:     t.ErrorClass,
+		RetryCount:     t.RetryCount,
+		FlowTxID:       t.TransactionID,
+	}
+	if t.State == common.TransactionStateRetry && !t.NextAttemptAt.IsZero() {
+		res.NextAttemptAt = &t.NextAttemptAt
+	}
+	if t.ParentID != uuid.Nil {
+		res.ParentID = &t.ParentID
+	}
+	return res
+}
+
+func ResTransactionListFromApp(list []transactions.StorableTransaction) []ResTransaction {
+	res := make([]ResTransaction, len(list))
+	for i := range list {
+		res[i] = ResTransactionFromApp(&list[i])
+	}
+	return res
+}
+
+func ResGetTransactionFromApp(t *transactions.StorableTransaction, children []transactions.StorableTransaction) ResGetTransaction {
+	audit := make([]ResTransactionAuditEntry, len(t.Audit))
+	for i, e := range t.Audit {
+		audit[i] = ResTransactionAuditEntry{
+			CreatedAt:        e.CreatedAt,
+			Action:           e.Action,
+			PreviousState:    e.PreviousState,
+			PreviousError:    e.PreviousError,
+			PreviousFlowTxID: e.PreviousTransactionID,
+			RetryCount:       e.RetryCount,
+		}
+	}
+
+	// Arguments are stored as a list of JSON-Cadence encoded values
+	encoded := [][]byte{}
+	_ = json.Unmarshal(t.Arguments, &encoded)
+	arguments := make([]json.RawMessage, len(encoded))
+	for i, a := range encoded {
+		arguments[i] = json.RawMessage(a)
+	}
+
+	return ResGetTransaction{
+		ResTransaction: ResTransactionFromApp(t),
+		Script:         t.Script,
+		Arguments:      arguments,
+		Audit:          audit,
+		Children:       ResTransactionListFromApp(children),
+	}
+}
+
+func ResPackFromApp(p *app.Pack) ResPack {
+	res := ResPack{
+		ID:                p.ID,
+		DistributionID:    p.DistributionID,
+		CreatedAt:         p.CreatedAt,
+		UpdatedAt:         p.UpdatedAt,
+		ContractReference: AddressLocation(p.ContractReference),
+		FlowID:            p.FlowID,
+		State:             p.State,
+		CommitmentHash:    p.CommitmentHash,
+	}
+
+	if p.IsRevealed() {
+		res.Salt = p.Salt
+		res.Collectibles = make([]string, len(p.Collectibles))
+		for i, c := range p.Collectibles {
+			res.Collectibles[i] = c.String()
+		}
+	}
+
+	return res
+}
+
+func ResPackProofFromApp(p *app.Pack) ResPackProof {
+	collectibles := make([]string, len(p.Collectibles))
+	for i, c := range p.Collectibles {
+		collectibles[i] = c.HashString()
+	}
+	return ResPackProof{
+		ID:             p.ID,
+		FlowID:         p.FlowID,
+		Salt:           p.Salt,
+		Collectibles:   collectibles,
+		HashInput:      p.HashInput(),
+		CommitmentHash: p.CommitmentHash,
+	}
+}
+
+func ResPackListFromApp(pp []app.Pack) []ResPack {
+	res := make([]ResPack, len(pp))
+	for i := range pp {
+		res[i] = ResPackFromApp(&pp[i])
+	}
+	return res
+}
+
+func ResCollectibleConflictsErrorFromApp(err error, cc []app.CollectibleConflict) ResCollectibleConflictsError {
+	return ResCollectibleConflictsError{
+		Error:     err.Error(),
+		Conflicts: resCollectibleConflictsFromApp(cc),
+	}
+}
+
+func resCollectibleConflictsFromApp(cc []app.CollectibleConflict) []ResCollectibleConflict {
+	conflicts := make([]ResCollectibleConflict, len(cc))
+	for i, c := range cc {
+		conflicts[i] = ResCollectibleConflict{
+			Collectible: c.Collectible.String(),
+			Buckets:     c.Buckets,
+		}
+		if c.DistributionID != uuid.Nil {
+			id := c.DistributionID
+			conflicts[i].DistributionID = &id
+		}
+	}
+	return conflicts
+}
+
 func ResDistributionListFromApp(dd []app.Distribution) []ResListDistribution {
 	res := make([]ResListDistribution, len(dd))
 	for i, d := range dd {
@@ -98,6 +575,8 @@ func ResDistributionListFromApp(dd []app.Distribution) []ResListDistribution {
 			UpdatedAt: d.UpdatedAt,
 			Issuer:    d.Issuer,
 			State:     d.State,
+			Title:     d.Title,
+			Metadata:  d.MetadataMap(),
 		}
 	}
 	return res
@@ -108,15 +587,24 @@ func ResPackTemplateFromApp(pt app.PackTemplate) ResPackTemplate {
 		PackReference: AddressLocation(pt.PackReference),
 		PackCount:     pt.PackCount,
 		Buckets:       ResBucketsFromApp(pt),
+		Constraints:   pt.Constraints,
 	}
 }
 
 func ResBucketsFromApp(pt app.PackTemplate) []ResBucket {
+	odds := pt.Odds()
 	buckets := make([]ResBucket, len(pt.Buckets))
 	for i, b := range pt.Buckets {
 		buckets[i] = ResBucket{
 			CollectibleReference: AddressLocation(b.CollectibleReference),
 			CollectibleCount:     b.CollectibleCount,
+			SlotGroup:            b.SlotGroup,
+			Weight:               b.Weight,
+			Tier:                 b.Tier,
+			DrawnPackCount:       b.DrawnPackCount,
+			ExpectedOdds:         odds[i].Expected,
+			EffectiveOdds:        odds[i].Effective,
+			RealizedOdds:         odds[i].Realized,
 		}
 	}
 	return buckets
@@ -128,24 +616,32 @@ func (d ReqCreateDistribution) ToApp() app.Distribution {
 		FlowID:       d.FlowID,
 		Issuer:       d.Issuer,
 		PackTemplate: d.PackTemplate.ToApp(),
+		ShuffleMode:  d.ShuffleMode,
 	}
 }
 
 func (pt ReqPackTemplate) ToApp() app.PackTemplate {
 	buckets := make([]app.Bucket, len(pt.Buckets))
 	for i, b := range pt.Buckets {
-		// ref := b.CollectibleReference
 		ref := pt.CollectibleReference
+		if b.CollectibleReference != nil {
+			ref = *b.CollectibleReference
+		}
 
 		buckets[i] = app.Bucket{
-			CollectibleReference:  app.AddressLocation(ref),
-			CollectibleCount:      b.CollectibleCount,
-			CollectibleCollection: b.CollectibleCollection,
+			CollectibleReference:    app.AddressLocation(ref),
+			CollectibleCount:        b.CollectibleCount,
+			CollectibleCollection:   b.CollectibleCollection,
+			SlotGroup:               b.SlotGroup,
+			Weight:                  b.Weight,
+			Tier:                    b.Tier,
+			CollectibleMetadataKeys: b.CollectibleMetadataKeys,
 		}
 	}
 	return app.PackTemplate{
 		PackReference: app.AddressLocation(pt.PackReference),
 		PackCount:     pt.PackCount,
 		Buckets:       buckets,
+		Constraints:   pt.Constraints,
 	}
 }
@@ -1,6 +1,8 @@
 package transactions
 
 import (
+	"time"
+
 	"github.com/flow-hydraulics/flow-pds/service/common"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
@@ -8,7 +10,7 @@ import (
 )
 
 func Migrate(db *gorm.DB) error {
-	if err := db.AutoMigrate(&StorableTransaction{}); err != nil {
+	if err := db.AutoMigrate(&StorableTransaction{}, &AuditEntry{}); err != nil {
 		return err
 	}
 	return nil
@@ -23,6 +25,15 @@ func (t *StorableTransaction) BeforeCreate(tx *gorm.DB) (err error) {
 	return nil
 }
 
+func (AuditEntry) TableName() string {
+	return "transaction_audit_entries"
+}
+
+func (e *AuditEntry) BeforeCreate(tx *gorm.DB) (err error) {
+	e.ID = uuid.New()
+	return nil
+}
+
 func (t *StorableTransaction) Save(db *gorm.DB) error {
 	return db.Omit(clause.Associations).Save(t).Error
 }
@@ -33,12 +44,51 @@ func GetTransaction(db *gorm.DB, id uuid.UUID) (*StorableTransaction, error) {
 	return &t, db.First(&t, id).Error
 }
 
+// GetTransactionWithAudit returns a StorableTransaction from database along
+// with its audit entries, oldest first.
+func GetTransactionWithAudit(db *gorm.DB, id uuid.UUID) (*StorableTransaction, error) {
+	t := StorableTransaction{}
+	return &t, db.Preload("Audit", func(db *gorm.DB) *gorm.DB {
+		return db.Order("created_at asc")
+	}).First(&t, id).Error
+}
+
+// ListFilter narrows down listed transactions, zero values match any.
+type ListFilter struct {
+	State          common.TransactionState
+	Name           string
+	DistributionID uuid.UUID
+	ParentID       uuid.UUID
+}
+
+// List lists transactions matching the filter, latest first.
+func List(db *gorm.DB, filter ListFilter, limit, offset int) ([]StorableTransaction, error) {
+	list := []StorableTransaction{}
+	err := db.
+		Where(&StorableTransaction{State: filter.State, Name: filter.Name, DistributionID: filter.DistributionID, ParentID: filter.ParentID}).
+		Order("created_at desc").
+		Limit(limit).
+		Offset(offset).
+		Find(&list).Error
+	return list, err
+}
+
+// InsertAuditEntry stores an audit entry of a transaction.
+func InsertAuditEntry(db *gorm.DB, e *AuditEntry) error {
+	return db.Omit(clause.Associations).Create(e).Error
+}
+
+// GetNextSendable returns the transaction waiting to be sent the longest,
+// skipping retries whose backoff has not passed yet.
 func GetNextSendable(db *gorm.DB) (*StorableTransaction, error) {
 	t := StorableTransaction{}
 	err := db.Order("updated_at asc").
 		Clauses(clause.Locking{Strength: "UPDATE SKIP LOCKED"}).
-		Where(map[string]interface{}{"state": common.TransactionStateInit}).
-		Or(map[string]interface{}{"state": common.TransactionStateRetry}).
+		Where("state IN ?", []common.TransactionState{
+			common.TransactionStateInit,
+			common.TransactionStateRetry,
+		}).
+		Where("(next_attempt_at IS NULL OR next_attempt_at <= ?)", time.Now()).
 		First(&t).Error
 	return &t, err
 }
@@ -50,3 +100,108 @@ func GetNextSent(db *gorm.DB) (*StorableTransaction, error) {
 		First(&t).Error
 	return &t, err
 }
+
+// CountByState counts the transactions of a distribution by state.
+func CountByState(db *gorm.DB, distributionID uuid.UUID) (map[common.TransactionState]int64, error) {
+	rows := []struct {
+		State common.TransactionState
+		Count int64
+	}{}
+	if err := db.Model(&StorableTransaction{}).
+		Select("state, count(*) as count").
+		Where(&StorableTransaction{DistributionID: distributionID}).
+		Group("state").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	res := make(map[common.TransactionState]int64, len(rows))
+	for _, r := range rows {
+		res[r.State] = r.Count
+	}
+	return res, nil
+}
+
+var pendingStates = []common.TransactionState{
+	common.TransactionStateInit,
+	common.TransactionStateRetry,
+	common.TransactionStateSent,
+}
+
+// CountPending counts the transactions of a distribution with the given name
+// which have not been sealed or failed yet.
+func CountPending(db *gorm.DB, distributionID uuid.UUID, name string) (int64, error) {
+	var count int64
+	err := db.Model(&StorableTransaction{}).
+		Where(&StorableTransaction{DistributionID: distributionID, Name: name}).
+		Where("state IN ?", pendingStates).
+		Count(&count).Error
+	return count, err
+}
+
+// ListPending lists the transactions of a distribution with the given name
+// which have not been sealed or failed yet.
+func ListPending(db *gorm.DB, distributionID uuid.UUID, name string) ([]StorableTransaction, error) {
+	list := []StorableTransaction{}
+	err := db.
+		Where(&StorableTransaction{DistributionID: distributionID, Name: name}).
+		Where("state IN ?", pendingStates).
+		Order("created_at asc").
+		Find(&list).Error
+	return list, err
+}
+
+// CancelPending cancels the transactions of a distribution which have not
+// been sent yet. It returns the amount of cancelled transactions.
+func CancelPending(db *gorm.DB, distributionID uuid.UUID) (int64, error) {
+	res := db.Model(&StorableTransaction{}).
+		Where(&StorableTransaction{DistributionID: distributionID}).
+		Where("state IN ?", []common.TransactionState{
+			common.TransactionStateInit,
+			common.TransactionStateRetry,
+		}).
+		Update("state", common.TransactionStateCancelled)
+	return res.RowsAffected, res.Error
+}
+
+// CancelFailed cancels the failed transactions of a distribution with the
+// given name, once their work has been re-queued, so they can not be retried
+// and sent twice. The cancellations are audited. It returns the amount of
+// cancelled transactions.
+func CancelFailed(db *gorm.DB, distributionID uuid.UUID, name string) (int, error) {
+	list := []StorableTransaction{}
+	if err := db.
+		Where(&StorableTransaction{DistributionID: distributionID, Name: name, State: common.TransactionStateFailed}).
+		Find(&list).Error; err != nil {
+		return 0, err
+	}
+
+	for i := range list {
+		t := &list[i]
+
+		entry, err := t.Cancel()
+		if err != nil {
+			return 0, err
+		}
+
+		if err := t.Save(db); err != nil {
+			return 0, err
+		}
+
+		if err := InsertAuditEntry(db, entry); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(list), nil
+}
+
+// CountFailedSince counts the failed transactions of a distribution with the
+// given name which were created at or after 'since'.
+func CountFailedSince(db *gorm.DB, distributionID uuid.UUID, name string, since time.Time) (int64, error) {
+	var count int64
+	err := db.Model(&StorableTransaction{}).
+		Where(&StorableTransaction{DistributionID: distributionID, Name: name, State: common.TransactionStateFailed}).
+		Where("created_at >= ?", since).
+		Count(&count).Error
+	return count, err
+}
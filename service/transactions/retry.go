@@ -0,0 +1,32 @@
+package transactions
+
+import (
+	"time"
+
+	"github.com/flow-hydraulics/flow-pds/service/flow_helpers"
+)
+
+// RetryPolicy tells how many times and how often a transaction failing with
+// a class of errors is retried.
+type RetryPolicy struct {
+	MaxRetries uint
+	BaseDelay  time.Duration // Delay before the first retry, doubled for each retry after it
+	MaxDelay   time.Duration
+}
+
+type RetryPolicies map[flow_helpers.ErrorClass]RetryPolicy
+
+// Backoff returns the delay before the given retry, starting from 1.
+func (p RetryPolicy) Backoff(retry uint) time.Duration {
+	delay := p.BaseDelay
+	for i := uint(1); i < retry; i++ {
+		delay *= 2
+		if p.MaxDelay > 0 && delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return delay
+}
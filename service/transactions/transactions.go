@@ -13,6 +13,7 @@ import (
 	c_json "github.com/onflow/cadence/encoding/json"
 	"github.com/onflow/flow-go-sdk"
 	"github.com/onflow/flow-go-sdk/client"
+	fvm_errors "github.com/onflow/flow-go/fvm/errors"
 	log "github.com/sirupsen/logrus"
 	"gorm.io/datatypes"
 	"gorm.io/gorm"
@@ -26,14 +27,44 @@ type StorableTransaction struct {
 
 	State         common.TransactionState `gorm:"column:state;not null;default:null;index"`
 	Error         string                  `gorm:"column:error"`
-	RetryCount    uint                    `gorm:"column:retry_count"` // TODO increment this
+	ErrorClass    flow_helpers.ErrorClass `gorm:"column:error_class"`
+	RetryCount    uint                    `gorm:"column:retry_count"`
+	NextAttemptAt time.Time               `gorm:"column:next_attempt_at"` // Not sent before this
 	TransactionID string                  `gorm:"column:transaction_id"`
+	// Height of the reference block of the sent transaction, it expires
+	// 'flow_helpers.TransactionExpiry' blocks after it
+	ReferenceBlockHeight uint64 `gorm:"column:reference_block_height"`
 
 	Name      string         `gorm:"column:name"` // Just a way to identify a transaction
 	Script    string         `gorm:"column:script"`
 	Arguments datatypes.JSON `gorm:"column:arguments"`
 
 	DistributionID uuid.UUID `gorm:"column:distribution_id;index"` // NOTE: Not a proper foreign key
+	ParentID       uuid.UUID `gorm:"column:parent_id;index"`       // Transaction this one was split from, if any
+
+	Audit []AuditEntry `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
+
+type AuditAction string
+
+const (
+	AuditActionRetry  AuditAction = "retry"
+	AuditActionCancel AuditAction = "cancel"
+)
+
+// AuditEntry records a manual action taken on a transaction, or a
+// cancellation once its work has been re-queued, and the state of the
+// transaction before it.
+type AuditEntry struct {
+	gorm.Model
+	ID                    uuid.UUID `gorm:"column:id;primary_key;type:uuid;"`
+	StorableTransactionID uuid.UUID `gorm:"column:storable_transaction_id;index"`
+
+	Action                AuditAction             `gorm:"column:action"`
+	PreviousState         common.TransactionState `gorm:"column:previous_state"`
+	PreviousError         string                  `gorm:"column:previous_error"`
+	PreviousTransactionID string                  `gorm:"column:previous_transaction_id"`
+	RetryCount            uint                    `gorm:"column:retry_count"` // Retry count after the action
 }
 
 func NewTransaction(name string, script []byte, arguments []cadence.Value) (*StorableTransaction, error) {
@@ -72,6 +103,146 @@ func NewTransactionWithDistributionID(name string, script []byte, arguments []ca
 	return transaction, nil
 }
 
+// Retry resets a failed transaction so it is prepared again, with a fresh
+// reference block, and sent by the poller.
+func (t *StorableTransaction) Retry() (*AuditEntry, error) {
+	if t.State != common.TransactionStateFailed {
+		return nil, fmt.Errorf("only failed transactions can be retried, transaction is %s", t.State)
+	}
+
+	entry := t.auditEntry(AuditActionRetry)
+
+	t.State = common.TransactionStateRetry
+	t.Error = ""
+	t.ErrorClass = ""
+	t.TransactionID = ""
+	t.NextAttemptAt = time.Time{}
+	t.RetryCount++
+
+	entry.RetryCount = t.RetryCount
+
+	return entry, nil
+}
+
+// Cancel cancels a transaction which has not been sent or has failed so it
+// is never sent again.
+func (t *StorableTransaction) Cancel() (*AuditEntry, error) {
+	switch t.State {
+	case common.TransactionStateInit, common.TransactionStateRetry, common.TransactionStateFailed:
+	default:
+		return nil, fmt.Errorf("only transactions which have not been sent or have failed can be cancelled, transaction is %s", t.State)
+	}
+
+	entry := t.auditEntry(AuditActionCancel)
+
+	t.State = common.TransactionStateCancelled
+
+	return entry, nil
+}
+
+// SetError classifies an error of sending or executing the transaction and
+// either schedules a retry according to the policy of the error class or
+// fails the transaction. Invalid sequence numbers are caused by concurrent
+// use of the same key and are retried right away, within the same limit.
+// Transactions exceeding their limits are failed so their batch can be split.
+func (t *StorableTransaction) SetError(err error, policies RetryPolicies, now time.Time) {
+	t.Error = err.Error()
+	t.ErrorClass = flow_helpers.ClassifyError(err)
+
+	if t.ErrorClass == flow_helpers.ErrorClassOutOfGas {
+		t.State = common.TransactionStateFailed
+		return
+	}
+
+	policy := policies[t.ErrorClass]
+	if t.RetryCount >= policy.MaxRetries {
+		t.State = common.TransactionStateFailed
+		return
+	}
+
+	t.RetryCount++
+	t.State = common.TransactionStateRetry
+
+	if flow_helpers.IsInvalidProposalSeqNumberError(err) {
+		t.NextAttemptAt = now
+		return
+	}
+
+	t.NextAttemptAt = now.Add(policy.Backoff(t.RetryCount))
+}
+
+// Split halves the array argument at 'index' into two new child transactions
+// with the same script. It returns nil if the array has less than two values.
+func (t *StorableTransaction) Split(index int) ([]*StorableTransaction, error) {
+	arguments, err := t.ArgumentsAsCadence()
+	if err != nil {
+		return nil, err
+	}
+
+	if index < 0 || index >= len(arguments) {
+		return nil, fmt.Errorf("transaction %s has no argument %d", t.ID, index)
+	}
+
+	batch, ok := arguments[index].(cadence.Array)
+	if !ok {
+		return nil, fmt.Errorf("argument %d of transaction %s is not an array", index, t.ID)
+	}
+
+	if len(batch.Values) < 2 {
+		return nil, nil
+	}
+
+	half := len(batch.Values) / 2
+	res := make([]*StorableTransaction, 0, 2)
+
+	for _, values := range [][]cadence.Value{batch.Values[:half], batch.Values[half:]} {
+		args := make([]cadence.Value, len(arguments))
+		copy(args, arguments)
+		args[index] = cadence.NewArray(values)
+
+		split, err := NewTransactionWithDistributionID(t.Name, []byte(t.Script), args, t.DistributionID)
+		if err != nil {
+			return nil, err
+		}
+
+		split.ParentID = t.ID
+
+		res = append(res, split)
+	}
+
+	return res, nil
+}
+
+// BatchLen returns the length of the array argument at 'index'.
+func (t *StorableTransaction) BatchLen(index int) (int, error) {
+	arguments, err := t.ArgumentsAsCadence()
+	if err != nil {
+		return 0, err
+	}
+
+	if index < 0 || index >= len(arguments) {
+		return 0, fmt.Errorf("transaction %s has no argument %d", t.ID, index)
+	}
+
+	batch, ok := arguments[index].(cadence.Array)
+	if !ok {
+		return 0, fmt.Errorf("argument %d of transaction %s is not an array", index, t.ID)
+	}
+
+	return len(batch.Values), nil
+}
+
+func (t *StorableTransaction) auditEntry(action AuditAction) *AuditEntry {
+	return &AuditEntry{
+		StorableTransactionID: t.ID,
+		Action:                action,
+		PreviousState:         t.State,
+		PreviousError:         t.Error,
+		PreviousTransactionID: t.TransactionID,
+		RetryCount:            t.RetryCount,
+	}
+}
+
 func (t *StorableTransaction) ArgumentsAsCadence() ([]cadence.Value, error) {
 	bytes := [][]byte{}
 	if err := json.Unmarshal(t.Arguments, &bytes); err != nil {
@@ -113,6 +284,7 @@ func (t *StorableTransaction) Prepare(ctx context.Context, flowClient *client.Cl
 	}
 
 	tx.SetReferenceBlockID(latestBlockHeader.ID)
+	t.ReferenceBlockHeight = latestBlockHeader.Height
 
 	unlock, err := flow_helpers.SignProposeAndPayAs(ctx, flowClient, account, tx)
 	if err != nil {
@@ -124,7 +296,11 @@ func (t *StorableTransaction) Prepare(ctx context.Context, flowClient *client.Cl
 
 // HandleResult checks the results of a transaction onchain and updates the
 // StorableTransaction accordingly.
-func (t *StorableTransaction) HandleResult(ctx context.Context, flowClient *client.Client) error {
+// Errors are handled according to the retry policy of their class.
+// A transaction unknown to the access node, e.g. as sending it timed out, is
+// only considered expired once its reference block is too old for it to be
+// executed, so it is never sent twice.
+func (t *StorableTransaction) HandleResult(ctx context.Context, flowClient *client.Client, policies RetryPolicies) error {
 	logger := log.WithFields(log.Fields{
 		"name":           t.Name,
 		"transactionID":  t.TransactionID,
@@ -132,32 +308,54 @@ func (t *StorableTransaction) HandleResult(ctx context.Context, flowClient *clie
 	})
 
 	result, err := flowClient.GetTransactionResult(ctx, flow.HexToID(t.TransactionID))
-	if err != nil {
+	if err != nil && !flow_helpers.IsNotFoundError(err) {
 		return err
 	}
 
+	if err != nil || result.Status == flow.TransactionStatusUnknown {
+		latestBlockHeader, err := flowClient.GetLatestBlockHeader(ctx, true)
+		if err != nil {
+			return err
+		}
+
+		if !t.IsExpired(latestBlockHeader.Height) {
+			logger.Trace("Transaction not found, checking again later")
+			return nil
+		}
+
+		result = &flow.TransactionResult{Status: flow.TransactionStatusExpired}
+	}
+
 	t.Error = ""
+	t.ErrorClass = ""
 
-	if result.Error != nil {
-		loggerWithError := logger.WithFields(log.Fields{"error": result.Error.Error()})
+	resultErr := result.Error
+	if resultErr == nil && result.Status == flow.TransactionStatusExpired {
+		resultErr = fmt.Errorf("transaction expired %s", fvm_errors.ErrCodeExpiredTransactionError)
+	}
+
+	if resultErr != nil {
+		t.SetError(resultErr, policies, time.Now())
 
-		t.Error = result.Error.Error()
+		loggerWithError := logger.WithFields(log.Fields{
+			"error":      t.Error,
+			"errorClass": t.ErrorClass,
+			"retryCount": t.RetryCount,
+		})
 
-		if flow_helpers.IsInvalidProposalSeqNumberError(result.Error) {
-			t.State = common.TransactionStateRetry
+		switch {
+		case t.State == common.TransactionStateRetry && flow_helpers.IsInvalidProposalSeqNumberError(resultErr):
 			// These can be quite numerous so using trace log level here
 			loggerWithError.Trace("Invalid sequence number, retrying later")
-		} else {
-			t.State = common.TransactionStateFailed
+		case t.State == common.TransactionStateRetry:
+			loggerWithError.WithFields(log.Fields{"nextAttemptAt": t.NextAttemptAt}).Info("Error in transaction, retrying later")
+		default:
 			loggerWithError.Warn("Error in transaction")
 		}
 		return nil
 	}
 
 	switch result.Status {
-	case flow.TransactionStatusExpired:
-		// TODO (latenssi): will we ever get here? if status == expired is result.Error set?
-		t.State = common.TransactionStateRetry
 	case flow.TransactionStatusSealed:
 		logger.Debug("Transaction sealed")
 		t.State = common.TransactionStateComplete
@@ -166,6 +364,12 @@ func (t *StorableTransaction) HandleResult(ctx context.Context, flowClient *clie
 	return nil
 }
 
+// IsExpired tells whether the sent transaction can no longer be executed at
+// the given block height.
+func (t *StorableTransaction) IsExpired(height uint64) bool {
+	return height > t.ReferenceBlockHeight+flow_helpers.TransactionExpiry
+}
+
 func (t *StorableTransaction) WaitForFinalize(ctx context.Context, flowClient *client.Client) (*flow.TransactionResult, error) {
 	for ctx.Err() == nil {
 		result, err := flowClient.GetTransactionResult(ctx, flow.HexToID(t.TransactionID))
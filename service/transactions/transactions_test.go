@@ -0,0 +1,397 @@
+package transactions
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/flow-hydraulics/flow-pds/service/common"
+	"github.com/flow-hydraulics/flow-pds/service/flow_helpers"
+	"github.com/google/uuid"
+	"github.com/onflow/cadence"
+	"github.com/onflow/flow-go-sdk/client"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestTransactionRetryAndCancel(t *testing.T) {
+	db := newTestDB(t)
+
+	distID := uuid.New()
+	states := map[string]common.TransactionState{
+		"settle": common.TransactionStateFailed,
+		"mint":   common.TransactionStateSent,
+	}
+
+	for name, state := range states {
+		tx, err := NewTransactionWithDistributionID(name, []byte{}, nil, distID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx.State = state
+		tx.Error = "error"
+		tx.TransactionID = "abc"
+		if err := tx.Save(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	failed, err := List(db, ListFilter{State: common.TransactionStateFailed, DistributionID: distID}, -1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 1 || failed[0].Name != "settle" {
+		t.Fatalf("expected the failed settle transaction, got %v", failed)
+	}
+
+	sent, err := List(db, ListFilter{Name: "mint"}, -1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 1 || sent[0].State != common.TransactionStateSent {
+		t.Fatalf("expected the sent mint transaction, got %v", sent)
+	}
+
+	if _, err := sent[0].Retry(); err == nil {
+		t.Error("expected an error when retrying a sent transaction")
+	}
+	if _, err := sent[0].Cancel(); err == nil {
+		t.Error("expected an error when cancelling a sent transaction")
+	}
+
+	tx := failed[0]
+
+	entry, err := tx.Retry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.State != common.TransactionStateRetry || tx.RetryCount != 1 || tx.Error != "" || tx.TransactionID != "" {
+		t.Errorf("unexpected transaction after retry %+v", tx)
+	}
+	if entry.Action != AuditActionRetry || entry.PreviousState != common.TransactionStateFailed ||
+		entry.PreviousError != "error" || entry.PreviousTransactionID != "abc" || entry.RetryCount != 1 {
+		t.Errorf("unexpected audit entry %+v", entry)
+	}
+	if err := tx.Save(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := InsertAuditEntry(db, entry); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tx.Retry(); err == nil {
+		t.Error("expected an error when retrying a re-queued transaction")
+	}
+
+	entry, err = tx.Cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.State != common.TransactionStateCancelled || entry.PreviousState != common.TransactionStateRetry {
+		t.Errorf("unexpected cancellation %+v, %+v", tx, entry)
+	}
+	if err := tx.Save(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := InsertAuditEntry(db, entry); err != nil {
+		t.Fatal(err)
+	}
+
+	withAudit, err := GetTransactionWithAudit(db, tx.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(withAudit.Audit) != 2 {
+		t.Fatalf("expected 2 audit entries, got %d", len(withAudit.Audit))
+	}
+	if withAudit.Audit[0].Action != AuditActionRetry || withAudit.Audit[1].Action != AuditActionCancel {
+		t.Errorf("unexpected audit entries %+v", withAudit.Audit)
+	}
+}
+
+func TestTransactionExpiry(t *testing.T) {
+	tx := StorableTransaction{State: common.TransactionStateSent, ReferenceBlockHeight: 100}
+
+	// A transaction whose sending timed out may still be executed until it expires
+	if tx.IsExpired(100 + flow_helpers.TransactionExpiry) {
+		t.Error("expected transaction not to be expired within the expiry window")
+	}
+	if !tx.IsExpired(101 + flow_helpers.TransactionExpiry) {
+		t.Error("expected transaction to be expired after the expiry window")
+	}
+}
+
+func TestTransactionRetryPolicy(t *testing.T) {
+	policies := RetryPolicies{
+		flow_helpers.ErrorClassTransient: {MaxRetries: 2, BaseDelay: time.Second, MaxDelay: 3 * time.Second},
+		flow_helpers.ErrorClassCadence:   {MaxRetries: 0},
+	}
+
+	policy := policies[flow_helpers.ErrorClassTransient]
+	for retry, expected := range map[uint]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 3 * time.Second, 10: 3 * time.Second} {
+		if got := policy.Backoff(retry); got != expected {
+			t.Errorf("expected backoff %s for retry %d, got %s", expected, retry, got)
+		}
+	}
+
+	now := time.Now()
+	unavailable := fmt.Errorf("error while sending transaction: %w", client.RPCError{GRPCErr: status.Error(codes.Unavailable, "unavailable")})
+
+	tx := StorableTransaction{State: common.TransactionStateSent}
+
+	tx.SetError(unavailable, policies, now)
+	if tx.State != common.TransactionStateRetry || tx.RetryCount != 1 || !tx.NextAttemptAt.Equal(now.Add(time.Second)) {
+		t.Errorf("expected first retry after a second, got %s, %d, %s", tx.State, tx.RetryCount, tx.NextAttemptAt)
+	}
+
+	tx.SetError(unavailable, policies, now)
+	if tx.State != common.TransactionStateRetry || tx.RetryCount != 2 || !tx.NextAttemptAt.Equal(now.Add(2*time.Second)) {
+		t.Errorf("expected second retry after two seconds, got %s, %d, %s", tx.State, tx.RetryCount, tx.NextAttemptAt)
+	}
+
+	tx.SetError(unavailable, policies, now)
+	if tx.State != common.TransactionStateFailed || tx.ErrorClass != flow_helpers.ErrorClassTransient {
+		t.Errorf("expected transaction to fail once retries are exhausted, got %s", tx.State)
+	}
+
+	// Invalid sequence numbers are retried right away, within the same limit
+	seqNumber := fmt.Errorf("%s invalid proposal key", flow_helpers.InvalidProposalSeqNumberErrorString)
+	tx = StorableTransaction{State: common.TransactionStateSent}
+	tx.SetError(seqNumber, policies, now)
+	if tx.State != common.TransactionStateRetry || tx.RetryCount != 1 || !tx.NextAttemptAt.Equal(now) {
+		t.Errorf("expected invalid sequence number to be retried right away, got %s, %d, %s", tx.State, tx.RetryCount, tx.NextAttemptAt)
+	}
+
+	tx.SetError(seqNumber, policies, now)
+	tx.SetError(seqNumber, policies, now)
+	if tx.State != common.TransactionStateFailed || tx.RetryCount != 2 {
+		t.Errorf("expected invalid sequence number to fail once retries are exhausted, got %s, %d", tx.State, tx.RetryCount)
+	}
+
+	tx = StorableTransaction{State: common.TransactionStateSent}
+	tx.SetError(fmt.Errorf("panic: test"), policies, now)
+	if tx.State != common.TransactionStateFailed || tx.ErrorClass != flow_helpers.ErrorClassCadence || tx.RetryCount != 0 {
+		t.Errorf("expected Cadence failure not to be retried, got %s, %s", tx.State, tx.ErrorClass)
+	}
+
+	tx = StorableTransaction{State: common.TransactionStateSent}
+	tx.SetError(fmt.Errorf("[Error Code: 1104] computation limit exceeded"), policies, now)
+	if tx.State != common.TransactionStateFailed || tx.ErrorClass != flow_helpers.ErrorClassOutOfGas {
+		t.Errorf("expected out of gas to fail for splitting, got %s, %s", tx.State, tx.ErrorClass)
+	}
+}
+
+func TestSplitTransaction(t *testing.T) {
+	distID := uuid.New()
+
+	ids := []cadence.Value{cadence.UInt64(1), cadence.UInt64(2), cadence.UInt64(3)}
+	tx, err := NewTransactionWithDistributionID("settle", []byte("script"), []cadence.Value{
+		cadence.UInt64(1),
+		cadence.String("A.0000000000000001.CollectibleNFT"),
+		cadence.NewArray(ids),
+	}, distID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	halves, err := tx.Split(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(halves) != 2 {
+		t.Fatalf("expected 2 transactions, got %d", len(halves))
+	}
+
+	expected := [][]uint64{{1}, {2, 3}}
+	for i, h := range halves {
+		if h.Name != "settle" || h.Script != "script" || h.DistributionID != distID || h.State != common.TransactionStateInit || h.ParentID != tx.ID {
+			t.Errorf("unexpected split transaction %+v", h)
+		}
+
+		arguments, err := h.ArgumentsAsCadence()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(arguments) != 3 || arguments[0] != cadence.UInt64(1) || arguments[1] != cadence.String("A.0000000000000001.CollectibleNFT") {
+			t.Errorf("expected the other arguments to be kept, got %v", arguments)
+		}
+
+		batch := arguments[2].(cadence.Array).Values
+		if len(batch) != len(expected[i]) {
+			t.Fatalf("expected %d collectibles, got %d", len(expected[i]), len(batch))
+		}
+		for j, v := range batch {
+			if uint64(v.(cadence.UInt64)) != expected[i][j] {
+				t.Errorf("expected collectible %d, got %s", expected[i][j], v)
+			}
+		}
+
+		if n, err := h.BatchLen(2); err != nil || n != len(expected[i]) {
+			t.Errorf("expected batch of %d, got %d (%v)", len(expected[i]), n, err)
+		}
+	}
+
+	single, err := halves[0].Split(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if single != nil {
+		t.Error("expected a single collectible batch not to be split")
+	}
+
+	if _, err := tx.Split(0); err == nil {
+		t.Error("expected an error when splitting a non-array argument")
+	}
+}
+
+func TestGetNextSendable(t *testing.T) {
+	db := newTestDB(t)
+
+	waiting, err := NewTransaction("waiting", []byte{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waiting.State = common.TransactionStateRetry
+	waiting.NextAttemptAt = time.Now().Add(time.Hour)
+	if err := waiting.Save(db); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := GetNextSendable(db); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("expected no sendable transactions during backoff, got %v", err)
+	}
+
+	ready, err := NewTransaction("ready", []byte{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ready.Save(db); err != nil {
+		t.Fatal(err)
+	}
+
+	next, err := GetNextSendable(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.ID != ready.ID {
+		t.Errorf("expected the transaction without backoff, got %s", next.Name)
+	}
+}
+
+func TestCancelPendingTransactions(t *testing.T) {
+	db := newTestDB(t)
+
+	distID := uuid.New()
+	states := []common.TransactionState{
+		common.TransactionStateInit,
+		common.TransactionStateRetry,
+		common.TransactionStateSent,
+		common.TransactionStateComplete,
+	}
+
+	for _, id := range []uuid.UUID{distID, uuid.New()} {
+		for _, state := range states {
+			tx, err := NewTransactionWithDistributionID("test", []byte{}, nil, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tx.State = state
+			if err := tx.Save(db); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	cancelled, err := CancelPending(db, distID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cancelled != 2 {
+		t.Errorf("expected 2 cancelled transactions, got %d", cancelled)
+	}
+
+	counts, err := CountByState(db, distID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[common.TransactionState]int64{
+		common.TransactionStateCancelled: 2,
+		common.TransactionStateSent:      1,
+		common.TransactionStateComplete:  1,
+	}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Errorf("expected %v, got %v", expected, counts)
+	}
+}
+
+func TestCancelFailedTransactions(t *testing.T) {
+	db := newTestDB(t)
+
+	distID := uuid.New()
+
+	var failed *StorableTransaction
+	for _, name := range []string{"settle", "mint"} {
+		for _, state := range []common.TransactionState{common.TransactionStateFailed, common.TransactionStateSent} {
+			tx, err := NewTransactionWithDistributionID(name, []byte{}, nil, distID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tx.State = state
+			if err := tx.Save(db); err != nil {
+				t.Fatal(err)
+			}
+			if name == "settle" && state == common.TransactionStateFailed {
+				failed = tx
+			}
+		}
+	}
+
+	cancelled, err := CancelFailed(db, distID, "settle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cancelled != 1 {
+		t.Errorf("expected 1 cancelled transaction, got %d", cancelled)
+	}
+
+	// A cancelled transaction can not be retried manually anymore
+	tx, err := GetTransactionWithAudit(db, failed.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.Audit) != 1 || tx.Audit[0].Action != AuditActionCancel {
+		t.Errorf("expected the cancellation to be audited, got %v", tx.Audit)
+	}
+	if _, err := tx.Retry(); err == nil {
+		t.Error("expected retrying a cancelled transaction to fail")
+	}
+
+	counts, err := CountByState(db, distID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[common.TransactionState]int64{
+		common.TransactionStateCancelled: 1,
+		common.TransactionStateFailed:    1,
+		common.TransactionStateSent:      2,
+	}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Errorf("expected %v, got %v", expected, counts)
+	}
+}
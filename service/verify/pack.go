@@ -0,0 +1,90 @@
+// Package verify allows anyone to check the contents of a revealed pack
+// against the commitment hash the pack was minted with.
+package verify
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/flow-hydraulics/flow-pds/service/app"
+	"github.com/flow-hydraulics/flow-pds/service/flow_helpers"
+	"github.com/onflow/cadence"
+	"github.com/onflow/flow-go-sdk/client"
+)
+
+// HashDelim joins the inputs of a pack commitment hash.
+const HashDelim = ","
+
+const GET_COMMIT_HASH_SCRIPT = "./cadence-scripts/packNFT/get_commit_hash.cdc"
+
+// PackProof is the data needed to verify the contents of a revealed pack.
+// It matches the response of 'GET /v1/packs/{id}/proof'.
+type PackProof struct {
+	FlowID         uint64   `json:"flowID"`         // Flow ID of the pack NFT
+	Salt           string   `json:"salt"`           // Hex encoded salt of the pack
+	Collectibles   []string `json:"collectibles"`   // Collectibles of the pack, e.g. "A.01cf0e2f2f715450.ExampleNFT.1"
+	HashInput      string   `json:"hashInput"`      // Pre-image of the commitment hash
+	CommitmentHash string   `json:"commitmentHash"` // Hex encoded commitment hash of the pack NFT
+}
+
+// HashInput builds the pre-image of a pack commitment hash from the salt
+// and the collectibles of the pack.
+func HashInput(salt string, collectibles []string) string {
+	return strings.Join(append([]string{salt}, collectibles...), HashDelim)
+}
+
+// Verify recomputes the commitment hash of the pack and compares it to the
+// given hex encoded commit hash of the pack NFT, which has to be read from
+// chain and not from the proof.
+func (p PackProof) Verify(commitHash string) error {
+	input := HashInput(p.Salt, p.Collectibles)
+	if p.HashInput != "" && p.HashInput != input {
+		return fmt.Errorf("hash input does not match salt and collectibles, expected '%s' got '%s'", input, p.HashInput)
+	}
+
+	if p.CommitmentHash != "" && !strings.EqualFold(p.CommitmentHash, commitHash) {
+		return fmt.Errorf("commitment hash of the proof does not match the onchain commit hash, expected %s got %s", commitHash, p.CommitmentHash)
+	}
+
+	expected, err := hex.DecodeString(commitHash)
+	if err != nil {
+		return fmt.Errorf("invalid commit hash: %w", err)
+	}
+
+	hash := sha256.Sum256([]byte(input))
+	if !bytes.Equal(hash[:], expected) {
+		return fmt.Errorf("commit hash mismatch, expected %s got %x", commitHash, hash)
+	}
+
+	return nil
+}
+
+// GetCommitHash reads the commit hash of a pack NFT from chain.
+func GetCommitHash(ctx context.Context, flowClient *client.Client, packContract app.AddressLocation, flowID uint64) (string, error) {
+	script, err := flow_helpers.ParseCadenceTemplate(
+		GET_COMMIT_HASH_SCRIPT,
+		&flow_helpers.CadenceTemplateVars{
+			PackNFTName:    packContract.Name,
+			PackNFTAddress: packContract.Address.String(),
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := flowClient.ExecuteScriptAtLatestBlock(ctx, script, []cadence.Value{cadence.UInt64(flowID)})
+	if err != nil {
+		return "", err
+	}
+
+	commitHash, ok := res.(cadence.String)
+	if !ok {
+		return "", fmt.Errorf("unexpected result from commit hash script: %v", res)
+	}
+
+	return string(commitHash), nil
+}
@@ -0,0 +1,78 @@
+package verify
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/flow-hydraulics/flow-pds/service/app"
+	"github.com/flow-hydraulics/flow-pds/service/common"
+	"github.com/onflow/flow-go-sdk"
+)
+
+func TestVerifyPackProof(t *testing.T) {
+	ref := app.AddressLocation{
+		Name:    "TestCollectibleNFT",
+		Address: common.FlowAddress(flow.HexToAddress("0x2")),
+	}
+
+	pack := app.Pack{
+		State: common.PackStateInit,
+		Collectibles: app.Collectibles{
+			{ContractReference: ref, FlowID: common.FlowID{Int64: 3, Valid: true}},
+			{ContractReference: ref, FlowID: common.FlowID{Int64: 1, Valid: true}},
+		},
+	}
+
+	if err := pack.SetCommitmentHash(); err != nil {
+		t.Fatal(err)
+	}
+
+	collectibles := make([]string, len(pack.Collectibles))
+	for i, c := range pack.Collectibles {
+		collectibles[i] = c.HashString()
+	}
+
+	onchain := pack.CommitmentHash.String()
+
+	proof := PackProof{
+		FlowID:         1,
+		Salt:           pack.Salt.String(),
+		Collectibles:   collectibles,
+		HashInput:      pack.HashInput(),
+		CommitmentHash: pack.CommitmentHash.String(),
+	}
+
+	if err := proof.Verify(onchain); err != nil {
+		t.Fatalf("didn't expect an error, got %s", err)
+	}
+
+	// Order of the collectibles matters
+	swapped := proof
+	swapped.Collectibles = []string{collectibles[1], collectibles[0]}
+	swapped.HashInput = ""
+	if err := swapped.Verify(onchain); err == nil {
+		t.Error("expected an error for reordered collectibles")
+	}
+
+	tampered := proof
+	tampered.HashInput = HashInput(proof.Salt, []string{collectibles[0]})
+	if err := tampered.Verify(onchain); err == nil {
+		t.Error("expected an error for a hash input not matching the collectibles")
+	}
+
+	// A proof consistent in itself has to match the onchain commit hash
+	forged := PackProof{Salt: proof.Salt, Collectibles: []string{collectibles[0]}}
+	forgedHash := sha256.Sum256([]byte(HashInput(forged.Salt, forged.Collectibles)))
+	forged.CommitmentHash = hex.EncodeToString(forgedHash[:])
+	if err := forged.Verify(forged.CommitmentHash); err != nil {
+		t.Fatalf("didn't expect an error, got %s", err)
+	}
+	if err := forged.Verify(onchain); err == nil {
+		t.Error("expected an error for a commitment hash not matching the onchain commit hash")
+	}
+	forged.CommitmentHash = ""
+	if err := forged.Verify(onchain); err == nil {
+		t.Error("expected an error for collectibles not matching the onchain commit hash")
+	}
+}
@@ -115,13 +115,26 @@ func makeTestCollection(size int) []common.FlowID {
 }
 
 func getExampleNFTBalance(flowClient *client.Client, address flow.Address) (uint64, error) {
+	return getCollectibleBalance(flowClient, exampleNFT, address)
+}
+
+func getExampleNFTIDs(flowClient *client.Client, address flow.Address, balance uint64) (common.FlowIDList, error) {
+	return getCollectibleIDs(flowClient, exampleNFT, address, balance)
+}
+
+var exampleNFT = app.AddressLocation{
+	Name:    "ExampleNFT",
+	Address: common.FlowAddressFromString("01cf0e2f2f715450"),
+}
+
+func getCollectibleBalance(flowClient *client.Client, contract app.AddressLocation, address flow.Address) (uint64, error) {
 
 	balanceScript, err := flow_helpers.ParseCadenceTemplate(
 		"./cadence-scripts/collectibleNFT/balance.cdc",
 		&flow_helpers.CadenceTemplateVars{
 			NonFungibleToken:      "f8d6e0586b0a20c7",
-			CollectibleNFTName:    "ExampleNFT",
-			CollectibleNFTAddress: "01cf0e2f2f715450",
+			CollectibleNFTName:    contract.Name,
+			CollectibleNFTAddress: contract.Address.String(),
 		},
 	)
 	if err != nil {
@@ -140,14 +153,14 @@ func getExampleNFTBalance(flowClient *client.Client, address flow.Address) (uint
 	return v.ToGoValue().(*big.Int).Uint64(), err
 }
 
-func getExampleNFTIDs(flowClient *client.Client, address flow.Address, balance uint64) (common.FlowIDList, error) {
+func getCollectibleIDs(flowClient *client.Client, contract app.AddressLocation, address flow.Address, balance uint64) (common.FlowIDList, error) {
 
 	idsScript, err := flow_helpers.ParseCadenceTemplate(
 		"./cadence-scripts/collectibleNFT/balance_ids.cdc",
 		&flow_helpers.CadenceTemplateVars{
 			NonFungibleToken:      "f8d6e0586b0a20c7",
-			CollectibleNFTName:    "ExampleNFT",
-			CollectibleNFTAddress: "01cf0e2f2f715450",
+			CollectibleNFTName:    contract.Name,
+			CollectibleNFTAddress: contract.Address.String(),
 		},
 	)
 	if err != nil {